@@ -17,10 +17,14 @@ type Event struct {
 	StkID uint64    // unique stack ID
 	Stk   []*Frame  // stack trace (can be empty)
 	Args  [3]uint64 // event-type-specific arguments
+	SArgs []string  // event-type-specific string args
 	// linked event (can be nil), depends on event type:
 	// for GCStart: the GCStop
-	// for GCScanStart: the GCScanDone
+	// for GCSTWStart: the GCSTWDone
 	// for GCSweepStart: the GCSweepDone
+	// for GCMarkAssistStart: the associated GCMarkAssistDone
+	// for UserTaskCreate: the UserTaskEnd
+	// for UserRegion: if the start region, the corresponding UserRegion end event
 	// for GoCreate: first GoStart of the created goroutine
 	// for GoStart: the associated GoEnd, GoBlock or other blocking event
 	// for GoSched/GoPreempt: the next GoStart
@@ -77,7 +81,7 @@ func forGoroutine(id uint64) EventFilter {
 				return true
 			}
 		case trace.EvGoUnblock: // who unblocked
-			if e.Args[0] == id {
+			if g, _ := e.TargetG(); g == id {
 				return true
 			}
 		}
@@ -126,6 +126,7 @@ type Event struct {
 	Args  [3]uint64
 	Link  int64
 	Off   int32
+	Ver   int32
 }
 
 func (d *Event) Size() (s uint64) {
@@ -213,6 +214,20 @@ func (d *Event) Size() (s uint64) {
 		}
 		s++
 
+	}
+	{
+
+		t := uint32(d.Ver)
+		t <<= 1
+		if d.Ver < 0 {
+			t = ^t
+		}
+		for t >= 0x80 {
+			t >>= 7
+			s++
+		}
+		s++
+
 	}
 	s += 25
 	return
@@ -413,6 +428,24 @@ func (d *Event) Marshal(buf []byte) ([]byte, error) {
 		buf[i+25] = byte(t)
 		i++
 
+	}
+	{
+
+		t := uint32(d.Ver)
+
+		t <<= 1
+		if d.Ver < 0 {
+			t = ^t
+		}
+
+		for t >= 0x80 {
+			buf[i+25] = byte(t) | 0x80
+			t >>= 7
+			i++
+		}
+		buf[i+25] = byte(t)
+		i++
+
 	}
 	return buf[:i+25], nil
 }
@@ -545,6 +578,23 @@ func (d *Event) Unmarshal(buf []byte) (uint64, error) {
 			d.Off = ^d.Off
 		}
 
+	}
+	{
+
+		bs := uint8(7)
+		t := uint32(buf[i+25] & 0x7F)
+		for buf[i+25]&0x80 == 0x80 {
+			i++
+			t |= uint32(buf[i+25]&0x7F) << bs
+			bs += 7
+		}
+		i++
+
+		d.Ver = int32(t >> 1)
+		if t&1 != 0 {
+			d.Ver = ^d.Ver
+		}
+
 	}
 	return i + 25, nil
 }
@@ -738,20 +788,28 @@ func (d *Frame) Unmarshal(buf []byte) (uint64, error) {
 }
 
 type GDesc struct {
-	ID            uint64
-	Name          string
-	PC            uint64
-	CreationTime  int64
-	StartTime     int64
-	EndTime       int64
-	ExecTime      int64
-	SchedWaitTime int64
-	IOTime        int64
-	BlockTime     int64
-	SyscallTime   int64
-	GCTime        int64
-	SweepTime     int64
-	TotalTime     int64
+	ID               uint64
+	Name             string
+	PC               uint64
+	CreationTime     int64
+	StartTime        int64
+	EndTime          int64
+	ExecTime         int64
+	SchedWaitTime    int64
+	IOTime           int64
+	BlockTime        int64
+	SyscallTime      int64
+	GCTime           int64
+	SweepTime        int64
+	TotalTime        int64
+	BlockSendTime    int64
+	BlockRecvTime    int64
+	BlockSelectTime  int64
+	BlockSyncTime    int64
+	BlockCondTime    int64
+	BlockGCTime      int64
+	BlockUnknownTime int64
+	SleepTime        int64
 }
 
 func (d *GDesc) Size() (s uint64) {
@@ -771,7 +829,7 @@ func (d *GDesc) Size() (s uint64) {
 		}
 		s += l
 	}
-	s += 104
+	s += 168
 	return
 }
 func (d *GDesc) Marshal(buf []byte) ([]byte, error) {
@@ -1051,7 +1109,159 @@ func (d *GDesc) Marshal(buf []byte) ([]byte, error) {
 		buf[i+7+96] = byte(d.TotalTime >> 56)
 
 	}
-	return buf[:i+104], nil
+	{
+
+		buf[i+0+104] = byte(d.BlockSendTime >> 0)
+
+		buf[i+1+104] = byte(d.BlockSendTime >> 8)
+
+		buf[i+2+104] = byte(d.BlockSendTime >> 16)
+
+		buf[i+3+104] = byte(d.BlockSendTime >> 24)
+
+		buf[i+4+104] = byte(d.BlockSendTime >> 32)
+
+		buf[i+5+104] = byte(d.BlockSendTime >> 40)
+
+		buf[i+6+104] = byte(d.BlockSendTime >> 48)
+
+		buf[i+7+104] = byte(d.BlockSendTime >> 56)
+
+	}
+	{
+
+		buf[i+0+112] = byte(d.BlockRecvTime >> 0)
+
+		buf[i+1+112] = byte(d.BlockRecvTime >> 8)
+
+		buf[i+2+112] = byte(d.BlockRecvTime >> 16)
+
+		buf[i+3+112] = byte(d.BlockRecvTime >> 24)
+
+		buf[i+4+112] = byte(d.BlockRecvTime >> 32)
+
+		buf[i+5+112] = byte(d.BlockRecvTime >> 40)
+
+		buf[i+6+112] = byte(d.BlockRecvTime >> 48)
+
+		buf[i+7+112] = byte(d.BlockRecvTime >> 56)
+
+	}
+	{
+
+		buf[i+0+120] = byte(d.BlockSelectTime >> 0)
+
+		buf[i+1+120] = byte(d.BlockSelectTime >> 8)
+
+		buf[i+2+120] = byte(d.BlockSelectTime >> 16)
+
+		buf[i+3+120] = byte(d.BlockSelectTime >> 24)
+
+		buf[i+4+120] = byte(d.BlockSelectTime >> 32)
+
+		buf[i+5+120] = byte(d.BlockSelectTime >> 40)
+
+		buf[i+6+120] = byte(d.BlockSelectTime >> 48)
+
+		buf[i+7+120] = byte(d.BlockSelectTime >> 56)
+
+	}
+	{
+
+		buf[i+0+128] = byte(d.BlockSyncTime >> 0)
+
+		buf[i+1+128] = byte(d.BlockSyncTime >> 8)
+
+		buf[i+2+128] = byte(d.BlockSyncTime >> 16)
+
+		buf[i+3+128] = byte(d.BlockSyncTime >> 24)
+
+		buf[i+4+128] = byte(d.BlockSyncTime >> 32)
+
+		buf[i+5+128] = byte(d.BlockSyncTime >> 40)
+
+		buf[i+6+128] = byte(d.BlockSyncTime >> 48)
+
+		buf[i+7+128] = byte(d.BlockSyncTime >> 56)
+
+	}
+	{
+
+		buf[i+0+136] = byte(d.BlockCondTime >> 0)
+
+		buf[i+1+136] = byte(d.BlockCondTime >> 8)
+
+		buf[i+2+136] = byte(d.BlockCondTime >> 16)
+
+		buf[i+3+136] = byte(d.BlockCondTime >> 24)
+
+		buf[i+4+136] = byte(d.BlockCondTime >> 32)
+
+		buf[i+5+136] = byte(d.BlockCondTime >> 40)
+
+		buf[i+6+136] = byte(d.BlockCondTime >> 48)
+
+		buf[i+7+136] = byte(d.BlockCondTime >> 56)
+
+	}
+	{
+
+		buf[i+0+144] = byte(d.BlockGCTime >> 0)
+
+		buf[i+1+144] = byte(d.BlockGCTime >> 8)
+
+		buf[i+2+144] = byte(d.BlockGCTime >> 16)
+
+		buf[i+3+144] = byte(d.BlockGCTime >> 24)
+
+		buf[i+4+144] = byte(d.BlockGCTime >> 32)
+
+		buf[i+5+144] = byte(d.BlockGCTime >> 40)
+
+		buf[i+6+144] = byte(d.BlockGCTime >> 48)
+
+		buf[i+7+144] = byte(d.BlockGCTime >> 56)
+
+	}
+	{
+
+		buf[i+0+152] = byte(d.BlockUnknownTime >> 0)
+
+		buf[i+1+152] = byte(d.BlockUnknownTime >> 8)
+
+		buf[i+2+152] = byte(d.BlockUnknownTime >> 16)
+
+		buf[i+3+152] = byte(d.BlockUnknownTime >> 24)
+
+		buf[i+4+152] = byte(d.BlockUnknownTime >> 32)
+
+		buf[i+5+152] = byte(d.BlockUnknownTime >> 40)
+
+		buf[i+6+152] = byte(d.BlockUnknownTime >> 48)
+
+		buf[i+7+152] = byte(d.BlockUnknownTime >> 56)
+
+	}
+	{
+
+		buf[i+0+160] = byte(d.SleepTime >> 0)
+
+		buf[i+1+160] = byte(d.SleepTime >> 8)
+
+		buf[i+2+160] = byte(d.SleepTime >> 16)
+
+		buf[i+3+160] = byte(d.SleepTime >> 24)
+
+		buf[i+4+160] = byte(d.SleepTime >> 32)
+
+		buf[i+5+160] = byte(d.SleepTime >> 40)
+
+		buf[i+6+160] = byte(d.SleepTime >> 48)
+
+		buf[i+7+160] = byte(d.SleepTime >> 56)
+
+	}
+	return buf[:i+168], nil
 }
 
 func (d *GDesc) Unmarshal(buf []byte) (uint64, error) {
@@ -1142,5 +1352,45 @@ func (d *GDesc) Unmarshal(buf []byte) (uint64, error) {
 		d.TotalTime = 0 | (int64(buf[i+0+96]) << 0) | (int64(buf[i+1+96]) << 8) | (int64(buf[i+2+96]) << 16) | (int64(buf[i+3+96]) << 24) | (int64(buf[i+4+96]) << 32) | (int64(buf[i+5+96]) << 40) | (int64(buf[i+6+96]) << 48) | (int64(buf[i+7+96]) << 56)
 
 	}
-	return i + 104, nil
+	{
+
+		d.BlockSendTime = 0 | (int64(buf[i+0+104]) << 0) | (int64(buf[i+1+104]) << 8) | (int64(buf[i+2+104]) << 16) | (int64(buf[i+3+104]) << 24) | (int64(buf[i+4+104]) << 32) | (int64(buf[i+5+104]) << 40) | (int64(buf[i+6+104]) << 48) | (int64(buf[i+7+104]) << 56)
+
+	}
+	{
+
+		d.BlockRecvTime = 0 | (int64(buf[i+0+112]) << 0) | (int64(buf[i+1+112]) << 8) | (int64(buf[i+2+112]) << 16) | (int64(buf[i+3+112]) << 24) | (int64(buf[i+4+112]) << 32) | (int64(buf[i+5+112]) << 40) | (int64(buf[i+6+112]) << 48) | (int64(buf[i+7+112]) << 56)
+
+	}
+	{
+
+		d.BlockSelectTime = 0 | (int64(buf[i+0+120]) << 0) | (int64(buf[i+1+120]) << 8) | (int64(buf[i+2+120]) << 16) | (int64(buf[i+3+120]) << 24) | (int64(buf[i+4+120]) << 32) | (int64(buf[i+5+120]) << 40) | (int64(buf[i+6+120]) << 48) | (int64(buf[i+7+120]) << 56)
+
+	}
+	{
+
+		d.BlockSyncTime = 0 | (int64(buf[i+0+128]) << 0) | (int64(buf[i+1+128]) << 8) | (int64(buf[i+2+128]) << 16) | (int64(buf[i+3+128]) << 24) | (int64(buf[i+4+128]) << 32) | (int64(buf[i+5+128]) << 40) | (int64(buf[i+6+128]) << 48) | (int64(buf[i+7+128]) << 56)
+
+	}
+	{
+
+		d.BlockCondTime = 0 | (int64(buf[i+0+136]) << 0) | (int64(buf[i+1+136]) << 8) | (int64(buf[i+2+136]) << 16) | (int64(buf[i+3+136]) << 24) | (int64(buf[i+4+136]) << 32) | (int64(buf[i+5+136]) << 40) | (int64(buf[i+6+136]) << 48) | (int64(buf[i+7+136]) << 56)
+
+	}
+	{
+
+		d.BlockGCTime = 0 | (int64(buf[i+0+144]) << 0) | (int64(buf[i+1+144]) << 8) | (int64(buf[i+2+144]) << 16) | (int64(buf[i+3+144]) << 24) | (int64(buf[i+4+144]) << 32) | (int64(buf[i+5+144]) << 40) | (int64(buf[i+6+144]) << 48) | (int64(buf[i+7+144]) << 56)
+
+	}
+	{
+
+		d.BlockUnknownTime = 0 | (int64(buf[i+0+152]) << 0) | (int64(buf[i+1+152]) << 8) | (int64(buf[i+2+152]) << 16) | (int64(buf[i+3+152]) << 24) | (int64(buf[i+4+152]) << 32) | (int64(buf[i+5+152]) << 40) | (int64(buf[i+6+152]) << 48) | (int64(buf[i+7+152]) << 56)
+
+	}
+	{
+
+		d.SleepTime = 0 | (int64(buf[i+0+160]) << 0) | (int64(buf[i+1+160]) << 8) | (int64(buf[i+2+160]) << 16) | (int64(buf[i+3+160]) << 24) | (int64(buf[i+4+160]) << 32) | (int64(buf[i+5+160]) << 40) | (int64(buf[i+6+160]) << 48) | (int64(buf[i+7+160]) << 56)
+
+	}
+	return i + 168, nil
 }
@@ -145,6 +145,7 @@ func Unmarshal(r io.Reader) ([]*trace.Event, map[uint64]*trace.GDesc, error) {
 			Args:  ev.Args,
 			Link:  toEvent(events, ev.Link),
 		}
+		events[i].SetVersion(int(ev.Ver))
 	}
 
 	// Frames
@@ -180,20 +181,28 @@ func Unmarshal(r io.Reader) ([]*trace.Event, map[uint64]*trace.GDesc, error) {
 			c.Advance(int(n))
 		}
 		gdescs[gd.ID] = &trace.GDesc{
-			ID:            gd.ID,
-			Name:          gd.Name,
-			PC:            gd.PC,
-			CreationTime:  gd.CreationTime,
-			StartTime:     gd.StartTime,
-			EndTime:       gd.EndTime,
-			ExecTime:      gd.ExecTime,
-			SchedWaitTime: gd.SchedWaitTime,
-			IOTime:        gd.IOTime,
-			BlockTime:     gd.BlockTime,
-			SyscallTime:   gd.SyscallTime,
-			GCTime:        gd.GCTime,
-			SweepTime:     gd.SweepTime,
-			TotalTime:     gd.TotalTime,
+			ID:               gd.ID,
+			Name:             gd.Name,
+			PC:               gd.PC,
+			CreationTime:     gd.CreationTime,
+			StartTime:        gd.StartTime,
+			EndTime:          gd.EndTime,
+			ExecTime:         gd.ExecTime,
+			SchedWaitTime:    gd.SchedWaitTime,
+			IOTime:           gd.IOTime,
+			BlockTime:        gd.BlockTime,
+			BlockSendTime:    gd.BlockSendTime,
+			BlockRecvTime:    gd.BlockRecvTime,
+			BlockSelectTime:  gd.BlockSelectTime,
+			BlockSyncTime:    gd.BlockSyncTime,
+			BlockCondTime:    gd.BlockCondTime,
+			BlockGCTime:      gd.BlockGCTime,
+			BlockUnknownTime: gd.BlockUnknownTime,
+			SleepTime:        gd.SleepTime,
+			SyscallTime:      gd.SyscallTime,
+			GCTime:           gd.GCTime,
+			SweepTime:        gd.SweepTime,
+			TotalTime:        gd.TotalTime,
 		}
 	}
 
@@ -246,6 +255,7 @@ func Marshal(w io.Writer, events []*trace.Event, gdesc map[uint64]*trace.GDesc)
 			Args:  ev.Args,
 			Link:  toEventID(eventsID, ev.Link),
 			Off:   int32(ev.Off),
+			Ver:   int32(ev.Version()),
 		}
 		buf, err = e.Marshal(buf)
 		if err != nil {
@@ -276,20 +286,28 @@ func Marshal(w io.Writer, events []*trace.Event, gdesc map[uint64]*trace.GDesc)
 	// GDesc
 	for _, gd := range gdesc {
 		g := GDesc{
-			ID:            gd.ID,
-			Name:          gd.Name,
-			PC:            gd.PC,
-			CreationTime:  gd.CreationTime,
-			StartTime:     gd.StartTime,
-			EndTime:       gd.EndTime,
-			ExecTime:      gd.ExecTime,
-			SchedWaitTime: gd.SchedWaitTime,
-			IOTime:        gd.IOTime,
-			BlockTime:     gd.BlockTime,
-			SyscallTime:   gd.SyscallTime,
-			GCTime:        gd.GCTime,
-			SweepTime:     gd.SweepTime,
-			TotalTime:     gd.TotalTime,
+			ID:               gd.ID,
+			Name:             gd.Name,
+			PC:               gd.PC,
+			CreationTime:     gd.CreationTime,
+			StartTime:        gd.StartTime,
+			EndTime:          gd.EndTime,
+			ExecTime:         gd.ExecTime,
+			SchedWaitTime:    gd.SchedWaitTime,
+			IOTime:           gd.IOTime,
+			BlockTime:        gd.BlockTime,
+			BlockSendTime:    gd.BlockSendTime,
+			BlockRecvTime:    gd.BlockRecvTime,
+			BlockSelectTime:  gd.BlockSelectTime,
+			BlockSyncTime:    gd.BlockSyncTime,
+			BlockCondTime:    gd.BlockCondTime,
+			BlockGCTime:      gd.BlockGCTime,
+			BlockUnknownTime: gd.BlockUnknownTime,
+			SleepTime:        gd.SleepTime,
+			SyscallTime:      gd.SyscallTime,
+			GCTime:           gd.GCTime,
+			SweepTime:        gd.SweepTime,
+			TotalTime:        gd.TotalTime,
 		}
 		buf, err = g.Marshal(buf)
 		if err != nil {
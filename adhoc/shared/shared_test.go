@@ -80,9 +80,21 @@ func TestMarshalUnmarshal(t *testing.T) {
 		Ts:   23,
 	}
 	gdesc := trace.GDesc{
-		ID: 12345,
+		ID:            12345,
+		Name:          "main.worker",
+		TotalTime:     100,
+		ExecTime:      40,
+		BlockTime:     30,
+		BlockRecvTime: 20,
+		BlockSyncTime: 10,
+		SleepTime:     30,
 	}
 
+	// The version decides how the arguments of the events are read.
+	ev0.SetVersion(1005)
+	ev1.SetVersion(1011)
+	ev2.SetVersion(1026)
+
 	events := []*trace.Event{&ev0, &ev1, &ev2}
 	gdescs := map[uint64]*trace.GDesc{12345: &gdesc}
 	var buf bytes.Buffer
@@ -97,6 +109,11 @@ func TestMarshalUnmarshal(t *testing.T) {
 	if !reflect.DeepEqual(gotEvents, events) {
 		t.Errorf("Got events %+v, want %+v", gotEvents, events)
 	}
+	for i, ev := range gotEvents {
+		if got, want := ev.Version(), events[i].Version(); got != want {
+			t.Errorf("Got version %v of event %d, want %v", got, i, want)
+		}
+	}
 	if !reflect.DeepEqual(gotGDesc, gdescs) {
 		t.Errorf("Got gdescs %+v, want %+v", gotGDesc, gdescs)
 	}
@@ -26,5 +26,6 @@ func RegisterHTTPHandlers(events []*trace.Event, goroutines map[uint64]*trace.GD
 
 		http.HandleFunc("/goroutines", httpGoroutines)
 		http.HandleFunc("/goroutine", httpGoroutine)
+		http.HandleFunc("/leaks", httpLeaks)
 	})
 }
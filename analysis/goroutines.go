@@ -9,6 +9,7 @@ package analysis
 import (
 	"fmt"
 	"html/template"
+	"math"
 	"net/http"
 	"sort"
 	"strconv"
@@ -52,8 +53,54 @@ func (l gdescList) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
 
+// window is the part of the trace the goroutine pages account for,
+// selected by the start and end query parameters in nanoseconds since
+// the start of the trace.
+type window struct {
+	Start, End int64
+	Set        bool // whether the parameters were given
+}
+
+func parseWindow(r *http.Request) (window, error) {
+	win := window{Start: 0, End: math.MaxInt64}
+	startStr, endStr := r.FormValue("start"), r.FormValue("end")
+	if startStr == "" && endStr == "" {
+		return win, nil
+	}
+	win.Set = true
+	var err error
+	if startStr != "" {
+		if win.Start, err = strconv.ParseInt(startStr, 10, 64); err != nil {
+			return win, fmt.Errorf("failed to parse start parameter '%v': %v", startStr, err)
+		}
+	}
+	if endStr != "" {
+		if win.End, err = strconv.ParseInt(endStr, 10, 64); err != nil {
+			return win, fmt.Errorf("failed to parse end parameter '%v': %v", endStr, err)
+		}
+	}
+	if win.Start >= win.End {
+		return win, fmt.Errorf("empty window [%v, %v]", win.Start, win.End)
+	}
+	return win, nil
+}
+
+// stats returns the goroutine statistics for the window.
+func (win window) stats() map[uint64]*trace.GDesc {
+	if !win.Set {
+		return gs
+	}
+	return trace.GoroutineStatsWindow(traceEvents, win.Start, win.End)
+}
+
 // httpGoroutines serves list of goroutine groups.
 func httpGoroutines(w http.ResponseWriter, r *http.Request) {
+	win, err := parseWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gs := win.stats()
 	gss := make(map[uint64]gtype)
 	for _, g := range gs {
 		gs1 := gss[g.PC]
@@ -69,15 +116,18 @@ func httpGoroutines(w http.ResponseWriter, r *http.Request) {
 		glist = append(glist, v)
 	}
 	sort.Sort(glist)
-	templGoroutines.Execute(w, glist)
+	templGoroutines.Execute(w, struct {
+		Window window
+		Groups gtypeList
+	}{win, glist})
 }
 
 var templGoroutines = template.Must(template.New("").Parse(`
 <html>
 <body>
-Goroutines: <br>
-{{range $}}
-  <a href="/goroutine?id={{.ID}}">{{.Name}}</a> N={{.N}} <br>
+Goroutines{{if $.Window.Set}} in [{{$.Window.Start}}, {{$.Window.End}}] ns{{end}}: <br>
+{{range $.Groups}}
+  <a href="/goroutine?id={{.ID}}{{if $.Window.Set}}&start={{$.Window.Start}}&end={{$.Window.End}}{{end}}">{{.Name}}</a> N={{.N}} <br>
 {{end}}
 </body>
 </html>
@@ -90,8 +140,13 @@ func httpGoroutine(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, fmt.Sprintf("failed to parse id parameter '%v': %v", r.FormValue("id"), err), http.StatusInternalServerError)
 		return
 	}
+	win, err := parseWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	var glist gdescList
-	for _, g := range gs {
+	for _, g := range win.stats() {
 		if g.PC != pc || g.ExecTime == 0 {
 			continue
 		}
@@ -114,7 +169,15 @@ var templGoroutine = template.Must(template.New("").Parse(`
 <th> Total time, ns </th>
 <th> Execution time, ns </th>
 <th> Network wait time, ns </th>
-<th> Sync block time, ns </th>
+<th> Sync block time (chan, select, mutex, cond), ns </th>
+<th> Chan send block time, ns </th>
+<th> Chan receive block time, ns </th>
+<th> Select block time, ns </th>
+<th> Mutex block time, ns </th>
+<th> Cond block time, ns </th>
+<th> GC assist block time, ns </th>
+<th> Unknown block time, ns </th>
+<th> Sleep time, ns </th>
 <th> Blocking syscall time, ns </th>
 <th> Scheduler wait time, ns </th>
 <th> GC sweeping time, ns </th>
@@ -127,6 +190,14 @@ var templGoroutine = template.Must(template.New("").Parse(`
     <td> {{.ExecTime}} </td>
     <td> {{.IOTime}} </td>
     <td> {{.BlockTime}} </td>
+    <td> {{.BlockSendTime}} </td>
+    <td> {{.BlockRecvTime}} </td>
+    <td> {{.BlockSelectTime}} </td>
+    <td> {{.BlockSyncTime}} </td>
+    <td> {{.BlockCondTime}} </td>
+    <td> {{.BlockGCTime}} </td>
+    <td> {{.BlockUnknownTime}} </td>
+    <td> {{.SleepTime}} </td>
     <td> {{.SyscallTime}} </td>
     <td> {{.SchedWaitTime}} </td>
     <td> {{.SweepTime}} </td>
@@ -0,0 +1,88 @@
+// Goroutine leak report.
+
+package analysis
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/hyangah/tracer/trace" // copy of go/src/internal/trace
+)
+
+// httpLeaks serves the goroutines still blocked at the end of the trace,
+// and those blocked longer than the threshold parameter, as HTML or,
+// with format=json, as JSON.
+func httpLeaks(w http.ResponseWriter, r *http.Request) {
+	var threshold time.Duration
+	if s := r.FormValue("threshold"); s != "" {
+		var err error
+		if threshold, err = time.ParseDuration(s); err != nil {
+			http.Error(w, fmt.Sprintf("failed to parse threshold parameter '%v': %v", s, err), http.StatusBadRequest)
+			return
+		}
+	}
+	report := trace.Leaks(traceEvents, int64(threshold))
+	if r.FormValue("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := report.WriteJSON(w); err != nil {
+			http.Error(w, fmt.Sprintf("failed to write report: %v", err), http.StatusInternalServerError)
+		}
+		return
+	}
+	err := templLeaks.Execute(w, struct {
+		Threshold time.Duration
+		*trace.LeakReport
+	}{threshold, report})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to execute template: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+var templLeaks = template.Must(template.New("").Funcs(template.FuncMap{
+	"dur": func(ns int64) time.Duration { return time.Duration(ns) },
+}).Parse(`
+<html>
+<body>
+<form>
+Also list goroutines blocked for at least <input name="threshold" value="{{if $.Threshold}}{{$.Threshold}}{{end}}" placeholder="e.g. 500ms">
+<input type="submit" value="Update">
+<a href="/leaks?format=json{{if $.Threshold}}&threshold={{$.Threshold}}{{end}}">JSON</a>
+</form>
+{{$.AtEnd}} goroutines blocked at the end of the trace{{if $.Threshold}}, {{$.Long}} blocked for {{$.Threshold}} or longer{{end}}.
+{{range $.Groups}}
+<h3>{{len .Goroutines}} blocked on {{if .Reason}}{{.Reason}}{{else}}unknown reason{{end}} for up to {{dur .MaxBlocked}}</h3>
+Blocked at:
+<pre>
+{{range .Stack}}{{.Fn}}
+	{{.File}}:{{.Line}}
+{{else}}unknown
+{{end}}</pre>
+Created at:
+<pre>
+{{range .Creation}}{{.Fn}}
+	{{.File}}:{{.Line}}
+{{else}}unknown
+{{end}}</pre>
+<table border="1" sortable="1">
+<tr>
+<th> Goroutine </th>
+<th> Blocked time </th>
+<th> Age </th>
+<th> Blocked at the end </th>
+</tr>
+{{range .Goroutines}}
+  <tr>
+    <td> <a href="/trace?goid={{.G}}">{{.G}}</a> </td>
+    <td> {{dur .Blocked}} </td>
+    <td> {{dur .Age}} </td>
+    <td> {{.AtEnd}} </td>
+  </tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
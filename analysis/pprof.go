@@ -28,81 +28,129 @@ type record struct {
 
 // IOProfile computes IO pprof-like profile (time spent in IO wait).
 func IOProfile(w io.Writer) error {
-	events := traceEvents
-	prof := make(map[uint64]record)
-	for _, ev := range events {
-		if ev.Type != trace.EvGoBlockNet || ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
-			continue
-		}
-		rec := prof[ev.StkID]
-		rec.stk = ev.Stk
-		rec.n++
-		rec.time += ev.Link.Ts - ev.Ts
-		prof[ev.StkID] = rec
-	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(eventProfiles().io).Write(w)
 }
 
 // BlockProfile computes blocking pprof-like profile (time spent blocked on synchronization primitives).
 func BlockProfile(w io.Writer) error {
-	events := traceEvents
-	prof := make(map[uint64]record)
-	for _, ev := range events {
-		switch ev.Type {
-		case trace.EvGoBlockSend, trace.EvGoBlockRecv, trace.EvGoBlockSelect,
-			trace.EvGoBlockSync, trace.EvGoBlockCond:
-		default:
-			continue
-		}
-		if ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
-			continue
-		}
-		rec := prof[ev.StkID]
-		rec.stk = ev.Stk
-		rec.n++
-		rec.time += ev.Link.Ts - ev.Ts
-		prof[ev.StkID] = rec
-	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(eventProfiles().block).Write(w)
 }
 
 // SyscallProfile computes syscall pprof-like profile (time spent blocked in syscalls).
 func SyscallProfile(w io.Writer) error {
-	events := traceEvents
-	prof := make(map[uint64]record)
-	for _, ev := range events {
-		if ev.Type != trace.EvGoSysCall || ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
-			continue
-		}
-		rec := prof[ev.StkID]
-		rec.stk = ev.Stk
-		rec.n++
-		rec.time += ev.Link.Ts - ev.Ts
-		prof[ev.StkID] = rec
-	}
-	return buildProfile(prof).Write(w)
+	return buildProfile(eventProfiles().syscall).Write(w)
 }
 
 // ScheduleLatencyProfile serves scheduler latency pprof-like profile
 // (time between a goroutine become runnable and actually scheduled for execution).
 func ScheduleLatencyProfile(w io.Writer) error {
-	events := traceEvents
-	prof := make(map[uint64]record)
-	for _, ev := range events {
-		if (ev.Type != trace.EvGoUnblock && ev.Type != trace.EvGoCreate) ||
-			ev.Link == nil || ev.StkID == 0 || len(ev.Stk) == 0 {
-			continue
+	return buildProfile(eventProfiles().sched).Write(w)
+}
+
+// Profiles holds the pprof-like profiles of a trace.
+type Profiles struct {
+	IO      *profile.Profile // time spent in IO wait
+	Block   *profile.Profile // time spent blocked on synchronization primitives
+	Syscall *profile.Profile // time spent blocked in syscalls
+	Sched   *profile.Profile // scheduler latency
+}
+
+// ReadProfiles computes all pprof-like profiles in a single pass
+// over the events read from r.
+func ReadProfiles(r *trace.Reader) (*Profiles, error) {
+	p := newProfiler()
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
-		if ev.Type == trace.EvGoCreate && ev.G == 0 { // Fake EvGoCreate event added when starting trace.
-			continue
+		p.add(ev)
+	}
+	return &Profiles{
+		IO:      buildProfile(p.io),
+		Block:   buildProfile(p.block),
+		Syscall: buildProfile(p.syscall),
+		Sched:   buildProfile(p.sched),
+	}, nil
+}
+
+func eventProfiles() *profiler {
+	p := newProfiler()
+	for _, ev := range traceEvents {
+		p.add(ev)
+	}
+	return p
+}
+
+// profiler accumulates pprof-like profiles over a stream of events.
+// An event contributes the time until the event it is linked to.
+// Link is not known until that event is seen, so the event waits
+// in pending, keyed by the goroutine the linked event is about.
+type profiler struct {
+	io, block, syscall, sched map[uint64]record
+	pending                   map[uint64]*trace.Event
+}
+
+func newProfiler() *profiler {
+	return &profiler{
+		io:      make(map[uint64]record),
+		block:   make(map[uint64]record),
+		syscall: make(map[uint64]record),
+		sched:   make(map[uint64]record),
+		pending: make(map[uint64]*trace.Event),
+	}
+}
+
+func (p *profiler) add(ev *trace.Event) {
+	g := ev.G
+	if ev.Type == trace.EvGoUnblock || ev.Type == trace.EvGoCreate {
+		g, _ = ev.TargetG()
+	}
+	if pev := p.pending[g]; pev != nil && pev.Link == ev {
+		delete(p.pending, g)
+		p.record(pev)
+	}
+
+	switch ev.Type {
+	case trace.EvGoBlockNet, trace.EvGoBlockSend, trace.EvGoBlockRecv, trace.EvGoBlockSelect,
+		trace.EvGoBlockSync, trace.EvGoBlockCond, trace.EvGoBlockGC, trace.EvGoSysCall, trace.EvGoUnblock:
+	case trace.EvGoCreate:
+		if ev.G == 0 { // Fake EvGoCreate event added when starting trace.
+			return
 		}
-		rec := prof[ev.StkID]
-		rec.stk = ev.Stk
-		rec.n++
-		rec.time += ev.Link.Ts - ev.Ts
-		prof[ev.StkID] = rec
+	default:
+		return
+	}
+	if ev.StkID == 0 || len(ev.Stk) == 0 {
+		return
+	}
+	if ev.Link != nil {
+		p.record(ev)
+		return
+	}
+	p.pending[g] = ev
+}
+
+func (p *profiler) record(ev *trace.Event) {
+	var prof map[uint64]record
+	switch ev.Type {
+	case trace.EvGoBlockNet:
+		prof = p.io
+	case trace.EvGoSysCall:
+		prof = p.syscall
+	case trace.EvGoUnblock, trace.EvGoCreate:
+		prof = p.sched
+	default:
+		prof = p.block
 	}
-	return buildProfile(prof).Write(w)
+	rec := prof[ev.StkID]
+	rec.stk = ev.Stk
+	rec.n++
+	rec.time += ev.Link.Ts - ev.Ts
+	prof[ev.StkID] = rec
 }
 
 // serveSVGProfile generates pprof-like profile stored in prof and writes in to w.
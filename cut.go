@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hyangah/tracer/trace"
+)
+
+const cutUsageMessage = "" +
+	`Usage of 'tracer cut':
+Extract a time window or a set of goroutines of a trace into a smaller trace:
+	tracer cut [flags] [pkg.test] trace.out
+Traces of Go 1.22 and later are written in the format of Go 1.11,
+which loses some details like the kinds of stop-the-world phases.
+
+Flags:
+	-start=ns: start of the window, in nanoseconds since the start of the trace
+	-end=ns: end of the window, in nanoseconds since the start of the trace
+	-g=id,...: keep only the events of these goroutines
+	-o=file: output file (default: standard output)
+`
+
+// cutMain runs the cut command with the arguments following "cut".
+func cutMain(args []string) {
+	fs := flag.NewFlagSet("cut", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, cutUsageMessage)
+		os.Exit(2)
+	}
+	start := fs.Int64("start", 0, "start of the window, in nanoseconds since the start of the trace")
+	end := fs.Int64("end", math.MaxInt64, "end of the window, in nanoseconds since the start of the trace")
+	gFlag := fs.String("g", "", "keep only the events of these comma-separated goroutines")
+	out := fs.String("o", "", "output file (default: standard output)")
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 1:
+		traceFile = fs.Arg(0)
+	case 2:
+		programBinary = fs.Arg(0)
+		traceFile = fs.Arg(1)
+	default:
+		fs.Usage()
+	}
+	if *start >= *end {
+		dief("empty window [%v, %v)\n", *start, *end)
+	}
+	var goroutines map[uint64]bool
+	if *gFlag != "" {
+		goroutines = make(map[uint64]bool)
+		for _, id := range strings.Split(*gFlag, ",") {
+			goid, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+			if err != nil {
+				dief("bad goroutine id %q\n", id)
+			}
+			goroutines[goid] = true
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			dief("failed to create output file: %v\n", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	if err := cutTrace(bw, *start, *end, goroutines); err != nil {
+		dief("%v\n", err)
+	}
+	if err := bw.Flush(); err != nil {
+		dief("failed to write trace: %v\n", err)
+	}
+}
+
+// cutTrace writes the window [start, end) of the trace file to w,
+// keeping only the events of goroutines if it is not nil.
+func cutTrace(w io.Writer, start, end int64, goroutines map[uint64]bool) error {
+	r, err := parseOptions().OpenReader(traceFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse trace: %v", err)
+	}
+	defer r.Close()
+	var events []*trace.Event
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse trace: %v", err)
+		}
+		events = append(events, ev)
+	}
+	tw, err := trace.NewWriterVersion(w, trace.WriterVersion(r.Version()))
+	if err != nil {
+		return err
+	}
+	for _, ev := range trace.Cut(events, start, end, goroutines) {
+		if err := tw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hyangah/tracer/trace"
+)
+
+const leaksUsageMessage = "" +
+	`Usage of 'tracer leaks':
+List the goroutines still blocked at the end of the trace, grouped by
+blocking stack and creation site:
+	tracer leaks [flags] [pkg.test] trace.out
+
+Flags:
+	-threshold=d: also list the goroutines that were blocked for d or
+	 longer before they were unblocked (e.g. '500ms')
+	-json: write the report as JSON
+`
+
+// leaksMain runs the leaks command with the arguments following "leaks".
+func leaksMain(args []string) {
+	fs := flag.NewFlagSet("leaks", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, leaksUsageMessage)
+		os.Exit(2)
+	}
+	threshold := fs.Duration("threshold", 0, "also list the goroutines blocked for this long")
+	jsonFlag := fs.Bool("json", false, "write the report as JSON")
+	fs.Parse(args)
+
+	switch fs.NArg() {
+	case 1:
+		traceFile = fs.Arg(0)
+	case 2:
+		programBinary = fs.Arg(0)
+		traceFile = fs.Arg(1)
+	default:
+		fs.Usage()
+	}
+
+	events, err := parseEvents()
+	if err != nil {
+		dief("%v\n", err)
+	}
+	if err := writeLeaks(os.Stdout, events, *threshold, *jsonFlag); err != nil {
+		dief("failed to write report: %v\n", err)
+	}
+}
+
+// writeLeaks writes the leak report of events to w as text or JSON.
+func writeLeaks(w io.Writer, events []*trace.Event, threshold time.Duration, asJSON bool) error {
+	r := trace.Leaks(events, int64(threshold))
+	bw := bufio.NewWriter(w)
+	var err error
+	if asJSON {
+		err = r.WriteJSON(bw)
+	} else {
+		err = r.WriteText(bw)
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -31,10 +31,12 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/mmap"
 
@@ -54,13 +56,47 @@ Open a web browser displaying trace:
 	tracer [flags] [pkg.test] trace.out
 [pkg.test] argument is required for traces produced by Go 1.6 and below.
 Go 1.7 does not require the binary argument.
+The trace may be gzip- or zstd-compressed, and may be an http or https URL.
+
+Extract a time window or a set of goroutines into a smaller trace:
+	tracer cut [flags] [pkg.test] trace.out
+See 'tracer cut -help' for its flags.
+
+Report every consistency violation of a trace as text or JSON:
+	tracer verify [-json] trace.out
+
+List the goroutines blocked at the end of the trace as text or JSON:
+	tracer leaks [-json] [-threshold=d] [pkg.test] trace.out
 
 Flags:
 	-http=addr: HTTP service address (e.g., ':6060')
+	-addr2line: symbolize traces of Go 1.6 and below with 'go tool addr2line'
+	 instead of reading the ELF binary
+	-symcache=dir: cache the symbols of binaries in dir; empty disables caching
+	-lenient: recover what is possible from a truncated or corrupted trace
+	-skew: repair the timestamps of a trace recorded with unsynchronized
+	 CPU clocks instead of rejecting it
+	-maxevents=n, -maxstack=n, -maxstrings=n, -maxmemory=MB: reject traces
+	 with more events, deeper stack traces, more strings or that take more
+	 memory when parsed, for untrusted traces (0 means no limit)
+	-merge=file[@offset],...: merge the traces of other processes, whose
+	 clocks are shifted by the optional offset (e.g. 'server.out@-1.5ms')
+	-marker=name: align the clocks of the merged traces on the first user
+	 log, task or region named name
 `
 
 var (
-	httpFlag = flag.String("http", "localhost:0", "HTTP service address (e.g., ':6060')")
+	httpFlag      = flag.String("http", "localhost:0", "HTTP service address (e.g., ':6060')")
+	addr2lineFlag = flag.Bool("addr2line", false, "symbolize traces of Go 1.6 and below with 'go tool addr2line'")
+	symcacheFlag  = flag.String("symcache", defaultSymcache(), "cache the symbols of binaries in `dir`; empty disables caching")
+	lenientFlag   = flag.Bool("lenient", false, "recover what is possible from a truncated or corrupted trace")
+	skewFlag      = flag.Bool("skew", false, "repair the timestamps of a trace recorded with unsynchronized CPU clocks")
+	maxEvents     = flag.Int("maxevents", 0, "maximum number of events of the trace")
+	maxStack      = flag.Int("maxstack", 0, "maximum number of frames of a stack trace")
+	maxStrings    = flag.Int("maxstrings", 0, "maximum number of strings of the trace")
+	maxMemory     = flag.Int64("maxmemory", 0, "maximum memory in `MB` taken by the parsed trace")
+	mergeFlag     = flag.String("merge", "", "comma-separated traces of other processes to merge, each optionally followed by @offset")
+	markerFlag    = flag.String("marker", "", "align the clocks of the merged traces on the first user log, task or region named `name`")
 
 	// The binary file name, left here for serveSVGProfile.
 	programBinary string
@@ -70,11 +106,24 @@ var (
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintln(os.Stderr, usageMessage)
+		fmt.Fprint(os.Stderr, usageMessage)
 		os.Exit(2)
 	}
 	flag.Parse()
 
+	if flag.Arg(0) == "cut" {
+		cutMain(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "verify" {
+		verifyMain(flag.Args()[1:])
+		return
+	}
+	if flag.Arg(0) == "leaks" {
+		leaksMain(flag.Args()[1:])
+		return
+	}
+
 	// Go 1.7 traces embed symbol info and does not require the binary.
 	// But we optionally accept binary as first arg for Go 1.5 traces.
 	switch flag.NArg() {
@@ -94,7 +143,7 @@ func main() {
 	}
 	goroutines := trace.GoroutineStats(events)
 
-	ranges = traceviewer.Init(events, goroutines)
+	ranges = traceviewer.InitMerged(events, goroutines, loader.processes)
 
 	analysis.RegisterHTTPHandlers(events, goroutines)
 
@@ -120,34 +169,145 @@ func main() {
 }
 
 var loader struct {
-	once   sync.Once
-	events []*trace.Event
-	err    error
+	once      sync.Once
+	events    []*trace.Event
+	processes []string // names of the processes of a merged trace
+	err       error
 }
 
 func parseEvents() ([]*trace.Event, error) {
 	loader.once.Do(func() {
-		tracef, err := os.Open(traceFile)
-		if err != nil {
-			loader.err = fmt.Errorf("failed to open trace file: %v", err)
-			return
-		}
-		defer tracef.Close()
-
 		// Parse and symbolize.
-		events, err := trace.Parse(bufio.NewReader(tracef), trace.Addr2LineSymbolizer(programBinary))
+		events, err := parseFile(traceFile)
 		if err != nil {
 			loader.err = fmt.Errorf("failed to parse trace: %v", err)
 			return
 		}
+		if *skewFlag {
+			adjusted := 0
+			for _, ev := range events {
+				if ev.ClockAdjusted {
+					adjusted++
+				}
+			}
+			if adjusted > 0 {
+				log.Printf("Repaired the timestamps of %v events because of clock skew", adjusted)
+			}
+		}
+		if *mergeFlag != "" {
+			events, err = mergeTraces(events)
+			if err != nil {
+				loader.err = err
+				return
+			}
+		}
 		loader.events = events
 	})
 	return loader.events, loader.err
 }
 
+// parseFile parses the trace file name with the options of the flags,
+// recovering what it can of a damaged trace with -lenient.
+func parseFile(name string) ([]*trace.Event, error) {
+	if *lenientFlag {
+		return parseLenient(name)
+	}
+	return parseOptions().ParseFile(name)
+}
+
+func parseLenient(name string) ([]*trace.Event, error) {
+	f, err := trace.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	events, rec, err := parseOptions().ParseLenient(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	if rec.Damaged() {
+		log.Printf("Recovered damaged trace %v: %v", name, rec)
+	}
+	return events, nil
+}
+
+// parseOptions returns the options to parse the trace with.
+func parseOptions() *trace.ParseOptions {
+	return &trace.ParseOptions{
+		Symbolizer:      symbolizer(),
+		RepairClockSkew: *skewFlag,
+		Limits: trace.Limits{
+			MaxEvents:     *maxEvents,
+			MaxStackDepth: *maxStack,
+			MaxStrings:    *maxStrings,
+			MaxMemory:     *maxMemory << 20,
+		},
+	}
+}
+
+// mergeTraces merges the events of the trace with the traces of the
+// -merge flag, and records the names of the processes.
+func mergeTraces(events []*trace.Event) ([]*trace.Event, error) {
+	sources := []trace.Source{{Name: filepath.Base(traceFile), Events: events, Marker: *markerFlag}}
+	for _, arg := range strings.Split(*mergeFlag, ",") {
+		name, offset := arg, time.Duration(0)
+		if i := strings.LastIndex(arg, "@"); i >= 0 {
+			d, err := time.ParseDuration(arg[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("bad offset of merged trace %q: %v", arg, err)
+			}
+			name, offset = arg[:i], d
+		}
+		events, err := parseFile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse trace %v: %v", name, err)
+		}
+		sources = append(sources, trace.Source{Name: filepath.Base(name), Events: events, Offset: int64(offset), Marker: *markerFlag})
+	}
+	merged, err := trace.Merge(sources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge traces: %v", err)
+	}
+	for _, src := range sources {
+		loader.processes = append(loader.processes, src.Name)
+	}
+	return merged, nil
+}
+
+// symbolizer returns the Symbolizer of the binary for traces produced
+// by Go 1.6 and below.
+func symbolizer() trace.Symbolizer {
+	var s trace.Symbolizer = trace.ELFSymbolizer(programBinary)
+	if *addr2lineFlag {
+		s = trace.Addr2LineSymbolizer(programBinary)
+	}
+	if *symcacheFlag != "" && programBinary != "" {
+		s = trace.CachedSymbolizer(s, programBinary, *symcacheFlag)
+	}
+	return s
+}
+
+// defaultSymcache returns the default directory of the symbol cache,
+// in the user cache directory.
+func defaultSymcache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "tracer", "symbols")
+}
+
 // httpMain serves the starting page.
 func httpMain(w http.ResponseWriter, r *http.Request) {
-	if err := templMain.Execute(w, ranges); err != nil {
+	data := struct {
+		Ranges       []traceviewer.Range
+		ThreadRanges []traceviewer.Range
+	}{Ranges: ranges}
+	if ranges != nil {
+		// The thread view is split only if the trace is.
+		data.ThreadRanges = traceviewer.ThreadRanges()
+	}
+	if err := templMain.Execute(w, data); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -156,15 +316,24 @@ func httpMain(w http.ResponseWriter, r *http.Request) {
 var templMain = template.Must(template.New("").Parse(`
 <html>
 <body>
-{{if $}}
-	{{range $e := $}}
+{{if $.Ranges}}
+	{{range $e := $.Ranges}}
 		<a href="/trace?start={{$e.Start}}&end={{$e.End}}">View trace ({{$e.Name}})</a><br>
 	{{end}}
 	<br>
 {{else}}
 	<a href="/trace">View trace</a><br>
 {{end}}
+{{if $.ThreadRanges}}
+	{{range $e := $.ThreadRanges}}
+		<a href="/trace?view=thread&start={{$e.Start}}&end={{$e.End}}">View trace by thread ({{$e.Name}})</a><br>
+	{{end}}
+	<br>
+{{else}}
+	<a href="/trace?view=thread">View trace by thread</a><br>
+{{end}}
 <a href="/goroutines">Goroutine analysis</a><br>
+<a href="/leaks">Goroutine leaks</a><br>
 <a href="/io">Network blocking profile</a><br>
 <a href="/block">Synchronization blocking profile</a><br>
 <a href="/syscall">Syscall blocking profile</a><br>
@@ -291,6 +460,8 @@ func customCommand(in string, events []*trace.Event, goroutines map[uint64]*trac
 		return pprofCmd(cmd[1:], events, goroutines)
 	case ":goroutine":
 		return goroutineCmd(cmd[1:], events, goroutines)
+	case ":leaks":
+		return leaksCmd(cmd[1:], events)
 	}
 	return false, nil
 }
@@ -323,6 +494,22 @@ func pprofCmd(args []string, events []*trace.Event, goroutines map[uint64]*trace
 	return true, f.Close()
 }
 
+func leaksCmd(args []string, events []*trace.Event) (handled bool, err error) {
+	const usage = "usage: :leaks [-json] [threshold]"
+	var threshold time.Duration
+	asJSON := false
+	for _, arg := range args {
+		if arg == "-json" {
+			asJSON = true
+			continue
+		}
+		if threshold, err = time.ParseDuration(arg); err != nil {
+			return true, fmt.Errorf(usage)
+		}
+	}
+	return true, writeLeaks(os.Stdout, events, threshold, asJSON)
+}
+
 func goroutineCmd(args []string, events []*trace.Event, goroutines map[uint64]*trace.GDesc) (handled bool, err error) {
 	for _, id := range args {
 		goid, err := strconv.ParseUint(id, 10, 64)
@@ -0,0 +1,159 @@
+package trace
+
+// Task is a logical operation created with runtime/trace.NewTask.
+type Task struct {
+	ID       uint64
+	Name     string
+	Parent   *Task   // parent task, nil for top-level tasks
+	Children []*Task // subtasks in the order of creation
+
+	// Create is the EvUserTaskCreate event, or nil if the task
+	// was created before tracing started.
+	Create *Event
+	// End is the EvUserTaskEnd event, or nil if the task
+	// did not end before tracing stopped.
+	End *Event
+
+	// StartTime and EndTime are the task boundaries.
+	// Missing boundaries are set to the first or the last
+	// timestamp in the trace.
+	StartTime int64
+	EndTime   int64
+
+	Goroutines map[uint64]bool // goroutines that participated in the task
+	Regions    []*Region       // regions associated with the task
+	Logs       []*Log          // log messages associated with the task
+}
+
+// Region is an interval of a goroutine execution annotated with
+// runtime/trace.WithRegion or runtime/trace.StartRegion.
+type Region struct {
+	Task     *Task // owning task, nil for the background task
+	Name     string
+	G        uint64    // goroutine the region belongs to
+	Parent   *Region   // enclosing region on the same goroutine
+	Children []*Region // nested regions in the order of start
+
+	// Start is the EvUserRegion start event, or nil if the region
+	// started before tracing started.
+	Start *Event
+	// End is the EvUserRegion end event. It can be EvGoEnd or EvGoStop
+	// if the goroutine terminated without explicitly ending the region,
+	// or nil if the region did not end before tracing stopped.
+	End *Event
+
+	// StartTime and EndTime are the region boundaries.
+	// Missing boundaries are set to the first or the last
+	// timestamp in the trace.
+	StartTime int64
+	EndTime   int64
+}
+
+// Log is a message recorded with runtime/trace.Log.
+type Log struct {
+	Task     *Task  // owning task, nil for the background task
+	G        uint64 // goroutine that logged the message
+	Category string
+	Message  string
+	Event    *Event // the EvUserLog event
+}
+
+// Annotations holds all user annotations found in a trace.
+type Annotations struct {
+	Tasks   map[uint64]*Task // tasks by ID
+	Regions []*Region        // all regions in the order of start
+	Logs    []*Log           // all log messages in the order of occurrence
+}
+
+// UserAnnotations reconstructs tasks, regions and log messages from
+// the user annotation events. The events must be post-processed by Parse.
+func UserAnnotations(events []*Event) *Annotations {
+	a := &Annotations{Tasks: make(map[uint64]*Task)}
+	if len(events) == 0 {
+		return a
+	}
+	firstTs, lastTs := events[0].Ts, events[len(events)-1].Ts
+
+	task := func(id uint64) *Task {
+		if id == 0 {
+			// Background task.
+			return nil
+		}
+		t := a.Tasks[id]
+		if t == nil {
+			t = &Task{ID: id, StartTime: firstTs, EndTime: lastTs, Goroutines: make(map[uint64]bool)}
+			a.Tasks[id] = t
+		}
+		return t
+	}
+	addRegion := func(r *Region) {
+		a.Regions = append(a.Regions, r)
+		if r.Parent != nil {
+			r.Parent.Children = append(r.Parent.Children, r)
+		}
+		if r.Task != nil {
+			r.Task.Regions = append(r.Task.Regions, r)
+			r.Task.Goroutines[r.G] = true
+		}
+	}
+
+	active := make(map[uint64][]*Region) // goroutine id to stack of regions
+	for _, ev := range events {
+		switch ev.Type {
+		case EvUserTaskCreate:
+			t := task(ev.Args[0])
+			t.Name = ev.SArgs[0]
+			t.Create = ev
+			t.StartTime = ev.Ts
+			t.Goroutines[ev.G] = true
+			if parent := task(ev.Args[1]); parent != nil {
+				t.Parent = parent
+				parent.Children = append(parent.Children, t)
+			}
+		case EvUserTaskEnd:
+			t := task(ev.Args[0])
+			if t == nil {
+				continue
+			}
+			t.End = ev
+			t.EndTime = ev.Ts
+			t.Goroutines[ev.G] = true
+		case EvUserRegion:
+			stk := active[ev.G]
+			if ev.Args[1] == 0 { // region start
+				r := &Region{Task: task(ev.Args[0]), Name: ev.SArgs[0], G: ev.G, Start: ev, StartTime: ev.Ts, EndTime: lastTs}
+				if len(stk) > 0 {
+					r.Parent = stk[len(stk)-1]
+				}
+				addRegion(r)
+				active[ev.G] = append(stk, r)
+				continue
+			}
+			// Region end.
+			if n := len(stk); n > 0 {
+				r := stk[n-1]
+				r.End = ev
+				r.EndTime = ev.Ts
+				active[ev.G] = stk[:n-1]
+				continue
+			}
+			// The region started before tracing started.
+			addRegion(&Region{Task: task(ev.Args[0]), Name: ev.SArgs[0], G: ev.G, End: ev, StartTime: firstTs, EndTime: ev.Ts})
+		case EvUserLog:
+			l := &Log{Task: task(ev.Args[0]), G: ev.G, Category: ev.SArgs[0], Message: ev.SArgs[1], Event: ev}
+			a.Logs = append(a.Logs, l)
+			if l.Task != nil {
+				l.Task.Logs = append(l.Task.Logs, l)
+				l.Task.Goroutines[l.G] = true
+			}
+		case EvGoEnd, EvGoStop:
+			// Regions do not outlive the goroutine.
+			for _, r := range active[ev.G] {
+				r.End = ev
+				r.EndTime = ev.Ts
+			}
+			delete(active, ev.G)
+		}
+	}
+	return a
+}
@@ -0,0 +1,85 @@
+package trace
+
+import "testing"
+
+func TestUserAnnotations(t *testing.T) {
+	w := newWriterVersion("1.11")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emitString(1, "task")
+	w.emitString(2, "outer")
+	w.emitString(3, "inner")
+	w.emitString(4, "category")
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvUserTaskCreate, 1, 1, 0, 1, 0)
+	w.emit(EvUserTaskCreate, 1, 2, 1, 1, 0)
+	w.emit(EvUserRegion, 1, 2, 0, 2, 0)
+	w.emit(EvUserRegion, 1, 2, 0, 3, 0)
+	w.emit(EvUserLog, 1, 2, 4, 0)
+	w.Write(appendString(nil, "message"))
+	w.emit(EvUserRegion, 1, 2, 1, 3, 0)
+	w.emit(EvUserTaskEnd, 1, 2, 0)
+	w.emit(EvGoEnd, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	a := UserAnnotations(events)
+	if len(a.Tasks) != 2 {
+		t.Fatalf("got %v tasks, want 2", len(a.Tasks))
+	}
+	parent, child := a.Tasks[1], a.Tasks[2]
+	if child.Parent != parent || len(parent.Children) != 1 || parent.Children[0] != child {
+		t.Errorf("task 2 is not a child of task 1")
+	}
+	if child.Create == nil || child.End == nil || child.Create.Link != child.End {
+		t.Errorf("task 2 create/end events are not linked: %v %v", child.Create, child.End)
+	}
+	if parent.End != nil {
+		t.Errorf("task 1 has unexpected end event %v", parent.End)
+	}
+	if !child.Goroutines[1] || len(child.Goroutines) != 1 {
+		t.Errorf("got task 2 goroutines %v, want {1}", child.Goroutines)
+	}
+
+	if len(a.Regions) != 2 {
+		t.Fatalf("got %v regions, want 2", len(a.Regions))
+	}
+	outer, inner := a.Regions[0], a.Regions[1]
+	if outer.Name != "outer" || inner.Name != "inner" {
+		t.Errorf("got regions %q and %q, want outer and inner", outer.Name, inner.Name)
+	}
+	if inner.Parent != outer || outer.Task != child || inner.Task != child {
+		t.Errorf("regions are not nested in task 2")
+	}
+	if inner.End == nil || inner.Start.Link != inner.End {
+		t.Errorf("inner region start/end events are not linked")
+	}
+	if outer.End == nil || outer.End.Type != EvGoEnd {
+		t.Errorf("outer region is not ended by goroutine end: %v", outer.End)
+	}
+
+	if len(a.Logs) != 1 || len(child.Logs) != 1 {
+		t.Fatalf("got %v logs, want 1", len(a.Logs))
+	}
+	if l := a.Logs[0]; l.Category != "category" || l.Message != "message" || l.G != 1 {
+		t.Errorf("bad log %+v", l)
+	}
+}
+
+func TestUserRegionMisuse(t *testing.T) {
+	w := newWriterVersion("1.11")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emitString(1, "a")
+	w.emitString(2, "b")
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvUserRegion, 1, 0, 0, 1, 0)
+	w.emit(EvUserRegion, 1, 0, 1, 2, 0)
+	if _, err := Parse(w, nil); err == nil {
+		t.Fatalf("no error on mismatched region end")
+	}
+}
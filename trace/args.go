@@ -0,0 +1,178 @@
+package trace
+
+// Typed accessors of the arguments of events. Each returns false if the
+// event does not carry the value, because of its type or because the
+// version of the trace does not record it. Events that were not parsed
+// from a trace and have no version set by SetVersion are taken to be in
+// the format of the latest version.
+
+// latestVersion is the latest version of the trace format.
+const latestVersion = 1026
+
+// version returns the version of the trace of the event.
+func (ev *Event) version() int {
+	if ev.ver == 0 {
+		return latestVersion
+	}
+	return int(ev.ver)
+}
+
+// Version returns the version of the trace of the event, for example
+// 1022 for Go 1.22.
+func (ev *Event) Version() int {
+	return ev.version()
+}
+
+// SetVersion sets the version of the trace of the event, which decides
+// how its arguments are read. It is meant for events decoded from other
+// formats, like those of the adhoc/shared package.
+func (ev *Event) SetVersion(ver int) {
+	ev.ver = uint16(ver)
+}
+
+// TargetG returns the goroutine the event acts on: the created goroutine
+// of GoCreate, the unblocked goroutine of GoUnblock and the goroutine of
+// GoStart, GoStartLabel, GoSysExit, GoWaiting and GoInSyscall.
+func (ev *Event) TargetG() (uint64, bool) {
+	switch ev.Type {
+	case EvGoCreate, EvGoUnblock, EvGoStart, EvGoStartLabel, EvGoSysExit, EvGoWaiting, EvGoInSyscall:
+		return ev.Args[0], true
+	}
+	return 0, false
+}
+
+// Seq returns the sequence number of the goroutine of GoStart,
+// GoStartLabel, GoUnblock and GoSysExit, which orders the events of the
+// goroutine, or the sequence number of the GC of GCStart. Traces of
+// Go 1.5 and 1.6 record neither, and traces of Go 1.22 and later do not
+// record that of GoSysExit.
+func (ev *Event) Seq() (uint64, bool) {
+	switch ev.Type {
+	case EvGoStart, EvGoStartLabel, EvGoUnblock:
+		return ev.Args[1], ev.version() >= 1007
+	case EvGCStart:
+		return ev.Args[0], ev.version() >= 1007
+	case EvGoSysExit:
+		return ev.Args[1], ev.version() >= 1007 && ev.version() < 1022
+	}
+	return 0, false
+}
+
+// CreateStackID returns the id of the stack of the goroutine created by
+// GoCreate at its start, 0 if unknown.
+func (ev *Event) CreateStackID() (uint64, bool) {
+	if ev.Type != EvGoCreate {
+		return 0, false
+	}
+	return ev.Args[1], true
+}
+
+// ThreadID returns the id of the thread that started a P with ProcStart.
+func (ev *Event) ThreadID() (uint64, bool) {
+	if ev.Type != EvProcStart {
+		return 0, false
+	}
+	return ev.Args[0], true
+}
+
+// Procs returns the value of GOMAXPROCS of Gomaxprocs.
+func (ev *Event) Procs() (int, bool) {
+	if ev.Type != EvGomaxprocs {
+		return 0, false
+	}
+	return int(ev.Args[0]), true
+}
+
+// HeapBytes returns the size of the live heap of HeapAlloc in bytes.
+func (ev *Event) HeapBytes() (uint64, bool) {
+	if ev.Type != EvHeapAlloc {
+		return 0, false
+	}
+	return ev.Args[0], true
+}
+
+// NextGCBytes returns the heap goal of NextGC in bytes. It is 0 if
+// there is no goal because the GC is off.
+func (ev *Event) NextGCBytes() (uint64, bool) {
+	if ev.Type != EvNextGC {
+		return 0, false
+	}
+	if ev.Args[0] == ^uint64(0) {
+		return 0, true
+	}
+	return ev.Args[0], true
+}
+
+// STWKind returns the kind of the stop of the world of GCSTWStart, like
+// "mark termination". Traces of Go 1.9 and earlier do not record it.
+func (ev *Event) STWKind() (string, bool) {
+	if ev.Type != EvGCSTWStart || ev.version() < 1010 || len(ev.SArgs) == 0 {
+		return "", false
+	}
+	return ev.SArgs[0], true
+}
+
+// Swept returns the number of bytes swept and reclaimed by a sweep of
+// GCSweepDone. Traces of Go 1.8 and earlier do not record them.
+func (ev *Event) Swept() (swept, reclaimed uint64, ok bool) {
+	if ev.Type != EvGCSweepDone || ev.version() < 1009 {
+		return 0, 0, false
+	}
+	return ev.Args[0], ev.Args[1], true
+}
+
+// Label returns the label of GoStartLabel, like "GC (dedicated)".
+func (ev *Event) Label() (string, bool) {
+	if ev.Type != EvGoStartLabel || len(ev.SArgs) == 0 {
+		return "", false
+	}
+	return ev.SArgs[0], true
+}
+
+// TaskID returns the id of the task of UserTaskCreate, UserTaskEnd,
+// UserRegion and UserLog, 0 for regions and logs outside of tasks.
+func (ev *Event) TaskID() (uint64, bool) {
+	switch ev.Type {
+	case EvUserTaskCreate, EvUserTaskEnd, EvUserRegion, EvUserLog:
+		return ev.Args[0], true
+	}
+	return 0, false
+}
+
+// ParentTaskID returns the id of the parent task of UserTaskCreate,
+// 0 if the task has no parent.
+func (ev *Event) ParentTaskID() (uint64, bool) {
+	if ev.Type != EvUserTaskCreate {
+		return 0, false
+	}
+	return ev.Args[1], true
+}
+
+// Name returns the name of the task of UserTaskCreate and of the region
+// of UserRegion.
+func (ev *Event) Name() (string, bool) {
+	switch ev.Type {
+	case EvUserTaskCreate, EvUserRegion:
+		if len(ev.SArgs) > 0 {
+			return ev.SArgs[0], true
+		}
+	}
+	return "", false
+}
+
+// RegionStart reports whether UserRegion starts rather than ends a
+// region.
+func (ev *Event) RegionStart() (start, ok bool) {
+	if ev.Type != EvUserRegion {
+		return false, false
+	}
+	return ev.Args[1] == 0, true
+}
+
+// Log returns the category and the message of UserLog.
+func (ev *Event) Log() (category, message string, ok bool) {
+	if ev.Type != EvUserLog || len(ev.SArgs) < 2 {
+		return "", "", false
+	}
+	return ev.SArgs[0], ev.SArgs[1], true
+}
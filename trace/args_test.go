@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEventAccessors(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		ver  int
+	}{
+		{"testdata/stress_1_5_good", 1005},
+		{"testdata/stress_1_11_good", 1011},
+		{"testdata/user_task_region_1_11_good", 1011},
+		{"testdata/annotations_1_26_good", 1026},
+	} {
+		data, err := ioutil.ReadFile(tc.file)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		_, events, err := parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", tc.file, err)
+		}
+		seen := make(map[byte]bool)
+		for _, ev := range events {
+			seen[ev.Type] = true
+			g, ok := ev.TargetG()
+			switch ev.Type {
+			case EvGoStart, EvGoStartLabel, EvGoSysExit, EvGoWaiting, EvGoInSyscall:
+				if !ok || g != ev.G {
+					t.Errorf("%v: %v: got target g %v, %v, want %v", tc.file, ev, g, ok, ev.G)
+				}
+			case EvGoCreate, EvGoUnblock:
+				if !ok || g == 0 {
+					t.Errorf("%v: %v: got target g %v, %v", tc.file, ev, g, ok)
+				}
+			default:
+				if ok {
+					t.Errorf("%v: %v has a target g", tc.file, ev)
+				}
+			}
+			if _, ok := ev.Seq(); ok != (tc.ver >= 1007 && (ev.Type == EvGoStart || ev.Type == EvGoStartLabel || ev.Type == EvGoUnblock || ev.Type == EvGCStart ||
+				ev.Type == EvGoSysExit && tc.ver < 1022)) {
+				t.Errorf("%v: %v: got sequence number %v", tc.file, ev, ok)
+			}
+			if _, ok := ev.HeapBytes(); ok != (ev.Type == EvHeapAlloc) {
+				t.Errorf("%v: %v: got heap bytes %v", tc.file, ev, ok)
+			}
+			if kind, ok := ev.STWKind(); ok != (ev.Type == EvGCSTWStart && tc.ver >= 1010) || ok && kind == "" {
+				t.Errorf("%v: %v: got STW kind %q, %v", tc.file, ev, kind, ok)
+			}
+			if _, _, ok := ev.Swept(); ok != (ev.Type == EvGCSweepDone && tc.ver >= 1009) {
+				t.Errorf("%v: %v: got swept bytes %v", tc.file, ev, ok)
+			}
+			if ev.Type == EvUserTaskCreate {
+				if id, ok := ev.TaskID(); !ok || id == 0 {
+					t.Errorf("%v: %v: got task id %v, %v", tc.file, ev, id, ok)
+				}
+				if name, ok := ev.Name(); !ok || name == "" {
+					t.Errorf("%v: %v: got task name %q, %v", tc.file, ev, name, ok)
+				}
+			}
+			if ev.Type == EvUserLog {
+				if _, msg, ok := ev.Log(); !ok || msg != ev.SArgs[1] {
+					t.Errorf("%v: %v: got log message %q, %v", tc.file, ev, msg, ok)
+				}
+			}
+		}
+		for _, typ := range []byte{EvGoCreate, EvGoStart, EvProcStart} {
+			if !seen[typ] {
+				t.Errorf("%v: no %v events", tc.file, EventDescriptions[typ].Name)
+			}
+		}
+	}
+}
+
+func TestEventAccessorsUnknownVersion(t *testing.T) {
+	ev := &Event{Type: EvGoSysExit, Args: [3]uint64{1, 2, 3}}
+	if _, ok := ev.Seq(); ok {
+		t.Errorf("GoSysExit of unknown version has a sequence number")
+	}
+	ev = &Event{Type: EvNextGC, Args: [3]uint64{^uint64(0)}}
+	if goal, ok := ev.NextGCBytes(); !ok || goal != 0 {
+		t.Errorf("got heap goal %v, %v, want 0 without a goal", goal, ok)
+	}
+	ev = &Event{Type: EvUserRegion, SArgs: []string{"region"}, Args: [3]uint64{0, 1}}
+	if start, ok := ev.RegionStart(); !ok || start {
+		t.Errorf("region end is a region start")
+	}
+}
@@ -0,0 +1,284 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// maxLookahead is the maximum number of ordered events that a
+// batchStream holds back because the exit of a syscall may still be
+// ordered before them.
+const maxLookahead = 1 << 16
+
+// A batchStream reads the events of a trace produced by Go 1.7 to 1.21
+// one at a time, for Reader. The batches of each P are indexed first and
+// decoded only as their events are merged, as by order1007.
+type batchStream struct {
+	stacks    map[uint64][]*Frame
+	timerGoid uint64
+	freq      float64 // nanoseconds per tick
+	minTs     int64   // timestamp of the first event, in ticks
+	m         *merger
+	merged    int   // number of merged events
+	lastTs    int64 // timestamp of the last merged event, in ticks
+	lastType  byte  // type of the last merged event
+	returnTs  int64 // timestamp of the last returned event, in ticks
+	done      bool  // all events are merged
+
+	lastSysBlock map[uint64]int64 // start of the last syscall of each goroutine
+	inSyscall    map[uint64]int64 // start of the syscall of goroutines in a syscall
+	syscalls     []gSyscall       // started syscalls by start, some of them exited
+	pending      []*Event         // merged events that were not returned yet, by time
+
+	futile futileTracker
+	pp     *postProcessor
+}
+
+// gSyscall is a syscall of goroutine g that started at ts.
+type gSyscall struct {
+	g  uint64
+	ts int64
+}
+
+// batchCursor decodes the events of the batches of a P as they are merged.
+type batchCursor struct {
+	d        *rawDecoder
+	segs     []segment // segments that were not started yet
+	off, end int       // range of the rest of the current segment
+	bp       batchParser
+}
+
+// newBatchStream indexes the batches of the trace of version ver in data.
+// It decodes the whole trace once but keeps only the stacks and strings,
+// and the ranges of the batches, which are in bytes of data.
+func newBatchStream(ver int, data []byte, lim *limiter) (*batchStream, error) {
+	var (
+		segs        []segment
+		stackEvents []rawEvent
+		ticksPerSec int64
+		timerGoid   uint64
+		events      int
+	)
+	// Events before the first EvBatch belong to P 0.
+	seg := segment{start: 16}
+	_, strings, _, err := decodeTrace(data, nil, lim, func(raw rawEvent, end int) error {
+		switch raw.typ {
+		case EvBatch, EvFrequency, EvTimerGoroutine, EvStack:
+		default:
+			events++
+			return nil
+		}
+		if err := checkArgNum(raw, ver); err != nil {
+			return err
+		}
+		switch raw.typ {
+		case EvBatch:
+			seg.end = raw.off
+			segs = append(segs, seg)
+			seg = segment{p: int(raw.args[0]), start: end, ts: int64(raw.args[1])}
+		case EvFrequency:
+			ticksPerSec = int64(raw.args[0])
+			if ticksPerSec <= 0 {
+				return &ParseError{Kind: KindTimeOrder, Off: raw.off, Type: raw.typ, P: -1,
+					Msg: fmt.Sprintf("time stamps out of order: frequency %v is not positive", ticksPerSec)}
+			}
+		case EvTimerGoroutine:
+			timerGoid = raw.args[0]
+		case EvStack:
+			stackEvents = append(stackEvents, raw)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	seg.end = len(data)
+	segs = append(segs, seg)
+
+	var first firstError
+	stacks := make(map[uint64][]*Frame)
+	indices := make([]int, len(stackEvents))
+	for i := range indices {
+		indices[i] = i
+	}
+	parseStacks(ver, stackEvents, indices, strings, stacks, &first)
+	if first.err != nil {
+		return nil, first.err
+	}
+	if events == 0 {
+		return nil, &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: "trace is empty"}
+	}
+	if ticksPerSec == 0 {
+		return nil, &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: "no EvFrequency event"}
+	}
+
+	pSegs := make(map[int][]segment)
+	var ps []int
+	for _, seg := range segs {
+		if _, ok := pSegs[seg.p]; !ok {
+			ps = append(ps, seg.p)
+		}
+		pSegs[seg.p] = append(pSegs[seg.p], seg)
+	}
+	sort.Ints(ps)
+	d := newRawDecoder(data, ver, nil, nil)
+	var batches []*eventBatch
+	for _, p := range ps {
+		c := &batchCursor{d: d, segs: pSegs[p], bp: batchParser{ver: ver, strings: strings}}
+		batches = append(batches, &eventBatch{next: c.next})
+	}
+	return &batchStream{
+		stacks:       stacks,
+		timerGoid:    timerGoid,
+		freq:         1e9 / float64(ticksPerSec),
+		m:            newMerger(batches, nil),
+		lastSysBlock: make(map[uint64]int64),
+		inSyscall:    make(map[uint64]int64),
+		pp:           newPostProcessor(ver),
+	}, nil
+}
+
+// next returns the next event of the P, or nil at the end of its batches.
+func (c *batchCursor) next() (*Event, error) {
+	for {
+		if c.off >= c.end {
+			if len(c.segs) == 0 {
+				return nil, nil
+			}
+			seg := c.segs[0]
+			c.segs = c.segs[1:]
+			c.bp.start(seg)
+			c.off, c.end = seg.start, seg.end
+			continue
+		}
+		raw, off, err := c.d.read(c.off)
+		if err != nil {
+			return nil, err
+		}
+		c.off = off
+		ev, err := c.bp.event(raw)
+		if ev != nil || err != nil {
+			return ev, err
+		}
+	}
+}
+
+// next returns the next event of the trace, which is verified and has
+// its stack attached. It returns io.EOF when there are no more events.
+func (s *batchStream) next() (*Event, error) {
+	for !s.ready() {
+		f, err := s.m.next()
+		if err != nil {
+			return nil, err
+		}
+		if f.ev == nil {
+			s.done = true
+			break
+		}
+		if err := s.add(f.ev); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.pending) == 0 {
+		return nil, io.EOF
+	}
+	ev := s.pending[0]
+	s.pending[0] = nil
+	s.pending = s.pending[1:]
+
+	// Translate cpu ticks to real time.
+	s.returnTs = ev.Ts
+	ev.Ts = int64(float64(ev.Ts-s.minTs) * s.freq)
+	// Move timers and syscalls to separate fake Ps.
+	if s.timerGoid != 0 && ev.G == s.timerGoid && ev.Type == EvGoUnblock {
+		ev.P = TimerP
+	}
+	if ev.Type == EvGoSysExit {
+		ev.P = SyscallP
+	}
+	for _, ev1 := range s.futile.add(ev) {
+		ev1.Futile = true
+	}
+	if err := s.pp.process(ev); err != nil {
+		return nil, err
+	}
+	if ev.StkID != 0 {
+		ev.Stk = s.stacks[ev.StkID]
+	}
+	return ev, nil
+}
+
+// ready reports whether the first pending event can be returned, that
+// is, whether no event merged later can be ordered before it. This is
+// the case when no syscall that is not exited yet started before it.
+func (s *batchStream) ready() bool {
+	if s.done || len(s.pending) > maxLookahead {
+		return true
+	}
+	if len(s.pending) == 0 {
+		return false
+	}
+	for len(s.syscalls) != 0 {
+		sc := s.syscalls[0]
+		if ts, ok := s.inSyscall[sc.g]; ok && ts == sc.ts {
+			break
+		}
+		s.syscalls = s.syscalls[1:]
+	}
+	// Events merged later are not before the last merged event.
+	ts := s.pending[0].Ts
+	return ts <= s.lastTs && (len(s.syscalls) == 0 || ts <= s.syscalls[0].ts)
+}
+
+// add adds the next merged event to the pending events. As in order1007,
+// the timestamps of merged events must not go backwards, and syscall
+// exits are moved to the time the syscall returned.
+func (s *batchStream) add(ev *Event) error {
+	if s.merged == 0 {
+		s.minTs = ev.Ts
+	} else if ev.Ts < s.lastTs {
+		err := ev.errorf("time stamps out of order: %v is before the preceding %v", EventDescriptions[ev.Type].Name, EventDescriptions[s.lastType].Name)
+		err.Kind = KindTimeOrder
+		return err
+	}
+	s.merged++
+	s.lastTs, s.lastType = ev.Ts, ev.Type
+
+	switch ev.Type {
+	case EvGoSysBlock, EvGoInSyscall:
+		s.lastSysBlock[ev.G] = ev.Ts
+		s.inSyscall[ev.G] = ev.Ts
+		s.syscalls = append(s.syscalls, gSyscall{ev.G, ev.Ts})
+	case EvGoSysExit:
+		delete(s.inSyscall, ev.G)
+		ts := int64(ev.Args[2])
+		if ts == 0 {
+			break
+		}
+		block := s.lastSysBlock[ev.G]
+		if block == 0 {
+			return ev.errorf("stray syscall exit")
+		}
+		if ts < block {
+			err := ev.errorf("time stamps out of order: syscall exit at %v before the syscall at %v", ts, block)
+			err.Kind = KindTimeOrder
+			return err
+		}
+		if ts < s.returnTs {
+			// The syscall returned before events that were already
+			// returned, beyond the look-ahead.
+			ts = s.returnTs
+		}
+		ev.Ts = ts
+	}
+	// Keep the pending events sorted as by a stable sort.
+	i := len(s.pending)
+	if i != 0 && s.pending[i-1].Ts > ev.Ts {
+		i = sort.Search(i, func(i int) bool { return s.pending[i].Ts > ev.Ts })
+	}
+	s.pending = append(s.pending, nil)
+	copy(s.pending[i+1:], s.pending[i:])
+	s.pending[i] = ev
+	return nil
+}
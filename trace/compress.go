@@ -0,0 +1,95 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/hyangah/tracer/internal/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Decompress returns a reader of the decompressed contents of r if r is
+// gzip- or zstd-compressed, which is detected by its magic bytes, and a
+// reader of the contents of r otherwise.
+func Decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress trace: %v", err)
+		}
+		return zr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstd.NewReader(br), nil
+	}
+	return br, nil
+}
+
+// decompress returns the decompressed data if data is compressed, and
+// data itself otherwise. The decompressed data is at most max bytes if
+// max is positive.
+func decompress(data []byte, max int64) ([]byte, error) {
+	if !bytes.HasPrefix(data, gzipMagic) && !bytes.HasPrefix(data, zstdMagic) {
+		return data, nil
+	}
+	r, err := Decompress(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	data, err = readLimited(r, max)
+	if err != nil {
+		if _, ok := err.(*ParseError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to decompress trace: %v", err)
+	}
+	return data, nil
+}
+
+// Open opens the trace in the named file, or at the URL name if it is an
+// http or https URL, and decompresses it as by Decompress.
+func Open(name string) (io.ReadCloser, error) {
+	var rc io.ReadCloser
+	if isURL(name) {
+		resp, err := http.Get(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch trace: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to fetch trace: %v", resp.Status)
+		}
+		rc = resp.Body
+	} else {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		rc = f
+	}
+	r, err := Decompress(rc)
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{r, rc}, nil
+}
+
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
@@ -0,0 +1,132 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+const compressTestTrace = "testdata/stress_1_11_good"
+
+func TestParseCompressed(t *testing.T) {
+	data, err := ioutil.ReadFile(compressTestTrace)
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	want, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse trace: %v", err)
+	}
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(data)
+	zw.Close()
+	zst, err := ioutil.ReadFile(compressTestTrace + ".zst")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	compressed := map[string][]byte{"gzip": gz.Bytes(), "zstd": zst}
+
+	for name, data := range compressed {
+		got, err := ParseBytes(data, nil)
+		if err != nil {
+			t.Errorf("%v: failed to parse compressed trace: %v", name, err)
+		} else if !reflect.DeepEqual(eventTypes(got), eventTypes(want)) {
+			t.Errorf("%v: compressed trace has different events", name)
+		}
+
+		r, err := NewReader(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Errorf("%v: failed to read compressed trace: %v", name, err)
+		} else if r.Version() != 1011 {
+			t.Errorf("%v: got version %v, want 1011", name, r.Version())
+		}
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/trace.out.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(gz.Bytes())
+	}))
+	defer srv.Close()
+	got, err := (&ParseOptions{Quiet: true}).ParseFile(srv.URL + "/trace.out.gz")
+	if err != nil {
+		t.Fatalf("failed to parse trace over HTTP: %v", err)
+	}
+	if !reflect.DeepEqual(eventTypes(got), eventTypes(want)) {
+		t.Errorf("trace fetched over HTTP has different events")
+	}
+	if _, err := Open(srv.URL + "/missing"); err == nil {
+		t.Errorf("no error on missing trace")
+	}
+}
+
+func eventTypes(events []*Event) []byte {
+	var types []byte
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	return types
+}
+
+func TestDecompressZstd(t *testing.T) {
+	// The compressed traces were produced by the zstd command at level 19,
+	// with checksums, and span several blocks.
+	files, err := filepath.Glob("testdata/*.zst")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no zstd-compressed traces in ./testdata")
+	}
+	for _, f := range files {
+		zst, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		want, err := ioutil.ReadFile(strings.TrimSuffix(f, ".zst"))
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		got, err := decompress(zst, 0)
+		if err != nil {
+			t.Errorf("%v: failed to decompress: %v", f, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%v: decompressed %v bytes that differ from the trace of %v bytes", f, len(got), len(want))
+		}
+
+		// Decompress the trace as it is read, in small pieces.
+		r, err := Decompress(iotest.OneByteReader(bytes.NewReader(zst)))
+		if err != nil {
+			t.Fatalf("%v: failed to decompress: %v", f, err)
+		}
+		got, err = ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%v: failed to decompress: %v", f, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("%v: decompressed %v bytes that differ from the trace of %v bytes", f, len(got), len(want))
+		}
+
+		// A corrupted trace fails the checksum or the decoding.
+		bad := append([]byte(nil), zst...)
+		bad[len(bad)/2] ^= 0xff
+		if _, err := decompress(bad, 0); err == nil {
+			t.Errorf("%v: no error for corrupted trace", f)
+		}
+
+		// A truncated trace fails.
+		if _, err := decompress(zst[:len(zst)-10], 0); err == nil {
+			t.Errorf("%v: no error for truncated trace", f)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package trace
+
+import "sort"
+
+// Cut returns the events of the time window [start, end) of a trace,
+// as returned by Parse, preceded by synthetic events that recreate the
+// state of the trace at start: the running Ps, the existing goroutines
+// and whether they are waiting, in a syscall or running, and the GC,
+// stop-the-world, sweep and mark assist phases in progress. The
+// synthetic events have timestamp start. The result can be written with
+// a Writer and parses cleanly.
+//
+// If goroutines is not nil, only the events of the goroutines in it
+// are kept, along with the events of Ps and of the GC. Creations and
+// unblocks of those goroutines by other goroutines are attributed to
+// goroutine 0.
+//
+// The events are copies without Link set; the events passed in are
+// not modified. User tasks and regions in progress at start are not
+// recreated; their ends are accepted by Parse without a start.
+func Cut(events []*Event, start, end int64, goroutines map[uint64]bool) []*Event {
+	c := &cutter{
+		gs:     make(map[uint64]*cutG),
+		ps:     make(map[int]*cutP),
+		stw:    make(map[int]*Event),
+		filter: goroutines,
+	}
+	i := 0
+	for ; i < len(events) && events[i].Ts < start; i++ {
+		c.replay(events[i])
+	}
+	res := c.state(start)
+	for ; i < len(events) && events[i].Ts < end; i++ {
+		if ev := c.keep(events[i]); ev != nil {
+			res = append(res, ev)
+		}
+	}
+	// A GoCreate refers to the stack of the goroutine start, which is
+	// only written with the first start. Drop the references to the
+	// stacks of goroutines that do not start in the window.
+	stacks := make(map[uint64]bool)
+	for _, ev := range res {
+		if ev.StkID != 0 && len(ev.Stk) != 0 {
+			stacks[ev.StkID] = true
+		}
+	}
+	for _, ev := range res {
+		if ev.Type == EvGoCreate && !stacks[ev.Args[1]] {
+			ev.Args[1] = 0
+		}
+	}
+	return res
+}
+
+// cutter tracks the state of a trace up to the start of a window.
+type cutter struct {
+	gs     map[uint64]*cutG
+	ps     map[int]*cutP
+	gc     *Event          // GCStart of the GC in progress
+	stw    map[int]*Event  // GCSTWStart in progress by P
+	last   map[byte]*Event // last HeapAlloc, NextGC and Gomaxprocs
+	filter map[uint64]bool
+}
+
+type cutG struct {
+	create     *Event // GoCreate of the goroutine, if seen
+	started    *Event // first GoStart, which has the stack of the start
+	state      byte   // EvGoCreate, EvGoWaiting, EvGoInSyscall or EvGoStart
+	start      *Event // GoStart if running
+	markAssist *Event // GCMarkAssistStart in progress
+}
+
+type cutP struct {
+	start *Event // ProcStart if running
+	sweep *Event // GCSweepStart in progress
+}
+
+func (c *cutter) g(id uint64) *cutG {
+	g := c.gs[id]
+	if g == nil {
+		g = &cutG{state: EvGoCreate}
+		c.gs[id] = g
+	}
+	return g
+}
+
+func (c *cutter) p(id int) *cutP {
+	p := c.ps[id]
+	if p == nil {
+		p = new(cutP)
+		c.ps[id] = p
+	}
+	return p
+}
+
+// replay updates the state with an event before the window.
+func (c *cutter) replay(ev *Event) {
+	switch ev.Type {
+	case EvProcStart:
+		c.p(ev.P).start = ev
+	case EvProcStop:
+		c.p(ev.P).start = nil
+	case EvGCStart:
+		c.gc = ev
+	case EvGCDone:
+		c.gc = nil
+	case EvGCSTWStart:
+		c.stw[ev.P] = ev
+	case EvGCSTWDone:
+		if _, ok := c.stw[ev.P]; ok {
+			delete(c.stw, ev.P)
+		} else {
+			// Since Go 1.10, stop-the-world phases are global.
+			c.stw = make(map[int]*Event)
+		}
+	case EvGCSweepStart:
+		c.p(ev.P).sweep = ev
+	case EvGCSweepDone:
+		c.p(ev.P).sweep = nil
+	case EvGCMarkAssistStart:
+		c.g(ev.G).markAssist = ev
+	case EvGCMarkAssistDone:
+		c.g(ev.G).markAssist = nil
+	case EvHeapAlloc, EvNextGC, EvGomaxprocs:
+		if c.last == nil {
+			c.last = make(map[byte]*Event)
+		}
+		c.last[ev.Type] = ev
+	case EvGoCreate:
+		c.gs[ev.Args[0]] = &cutG{create: ev, state: EvGoCreate}
+	case EvGoWaiting, EvGoInSyscall:
+		c.g(ev.G).state = ev.Type
+	case EvGoStart, EvGoStartLabel:
+		g := c.g(ev.G)
+		g.state, g.start = EvGoStart, ev
+		if g.started == nil {
+			g.started = ev
+		}
+	case EvGoEnd, EvGoStop:
+		delete(c.gs, ev.G)
+	case EvGoSched, EvGoPreempt:
+		g := c.g(ev.G)
+		g.state, g.start = EvGoCreate, nil
+	case EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoBlockGC:
+		g := c.g(ev.G)
+		g.state, g.start = EvGoWaiting, nil
+	case EvGoSysBlock:
+		g := c.g(ev.G)
+		g.state, g.start = EvGoInSyscall, nil
+	case EvGoUnblock:
+		c.g(ev.Args[0]).state = EvGoCreate
+	case EvGoSysExit:
+		c.g(ev.G).state = EvGoCreate
+	}
+}
+
+// state returns the synthetic events that recreate the state at ts.
+func (c *cutter) state(ts int64) []*Event {
+	var res []*Event
+	add := func(ev *Event) *Event {
+		ev.Ts, ev.Link = ts, nil
+		res = append(res, ev)
+		return ev
+	}
+	if c.gc != nil {
+		add(c.attribute(c.gc))
+	}
+
+	var ps []int
+	for id, p := range c.ps {
+		if p.start != nil {
+			ps = append(ps, id)
+		}
+	}
+	sort.Ints(ps)
+	for _, id := range ps {
+		add(c.attribute(c.ps[id].start))
+	}
+	// Goroutines are created by goroutine 0 on a running P.
+	createP, stopP := 0, false
+	if len(ps) > 0 {
+		createP = ps[0]
+	} else {
+		add(&Event{Type: EvProcStart, P: createP})
+		stopP = true
+	}
+	for _, typ := range []byte{EvGomaxprocs, EvHeapAlloc, EvNextGC} {
+		if ev := c.last[typ]; ev != nil {
+			ev = add(copyEvent(ev))
+			ev.P, ev.G, ev.StkID, ev.Stk = createP, 0, 0, nil
+		}
+	}
+
+	var gids []uint64
+	for id := range c.gs {
+		if id != 0 && c.keepG(id) {
+			gids = append(gids, id)
+		}
+	}
+	sort.Slice(gids, func(i, j int) bool { return gids[i] < gids[j] })
+	for _, id := range gids {
+		g := c.gs[id]
+		ev := &Event{Type: EvGoCreate, Args: [3]uint64{id}}
+		if g.create != nil {
+			ev = copyEvent(g.create)
+		}
+		ev = add(ev)
+		ev.P, ev.G = createP, 0
+	}
+	for _, id := range gids {
+		if typ := c.gs[id].state; typ == EvGoWaiting || typ == EvGoInSyscall {
+			add(&Event{Type: typ, P: createP, G: id, Args: [3]uint64{id}})
+		}
+	}
+	if stopP {
+		add(&Event{Type: EvProcStop, P: createP})
+	}
+
+	var stw []int
+	for id := range c.stw {
+		stw = append(stw, id)
+	}
+	sort.Ints(stw)
+	for _, id := range stw {
+		add(c.attribute(c.stw[id]))
+	}
+	for _, id := range ps {
+		if ev := c.ps[id].sweep; ev != nil {
+			ev = add(copyEvent(ev))
+			ev.G, ev.StkID, ev.Stk = 0, 0, nil
+		}
+	}
+	for _, id := range gids {
+		g := c.gs[id]
+		if g.state != EvGoStart {
+			continue
+		}
+		ev := add(copyEvent(g.start))
+		ev.StkID, ev.Stk = g.started.StkID, g.started.Stk
+		if g.markAssist != nil {
+			add(copyEvent(g.markAssist))
+		}
+	}
+	return res
+}
+
+// keep returns a copy of the event in the window if it is kept.
+func (c *cutter) keep(ev *Event) *Event {
+	if c.filter == nil {
+		return copyEvent(ev)
+	}
+	switch ev.Type {
+	case EvProcStart, EvProcStop, EvGCStart, EvGCDone, EvGCSTWStart, EvGCSTWDone,
+		EvGCSweepStart, EvGCSweepDone, EvHeapAlloc, EvNextGC, EvGomaxprocs:
+		return c.attribute(ev)
+	case EvGoCreate, EvGoUnblock:
+		if !c.keepG(ev.Args[0]) {
+			return nil
+		}
+		return c.attribute(ev)
+	}
+	if !c.keepG(ev.G) {
+		return nil
+	}
+	return copyEvent(ev)
+}
+
+func (c *cutter) keepG(id uint64) bool {
+	return c.filter == nil || id == 0 || c.filter[id]
+}
+
+// attribute returns a copy of the event, attributed to goroutine 0
+// if its goroutine is not kept.
+func (c *cutter) attribute(ev *Event) *Event {
+	ev = copyEvent(ev)
+	if !c.keepG(ev.G) {
+		ev.G = 0
+	}
+	return ev
+}
+
+func copyEvent(ev *Event) *Event {
+	e := *ev
+	e.Link = nil
+	return &e
+}
@@ -0,0 +1,84 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCut(t *testing.T) {
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		ver, events, err := parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		first, last := events[0].Ts, events[len(events)-1].Ts
+		start, end := first+(last-first)/3, first+(last-first)*2/3
+		var inWindow int
+		gs := make(map[uint64]bool)
+		for _, ev := range events {
+			if ev.Ts >= start && ev.Ts < end {
+				inWindow++
+				if ev.G != 0 && len(gs) < 3 {
+					gs[ev.G] = true
+				}
+			}
+		}
+		for _, filter := range []map[uint64]bool{nil, gs} {
+			cut := Cut(events, start, end, filter)
+			if filter == nil && len(cut) < inWindow {
+				t.Errorf("%v: cut has %v events, want at least the %v in the window", f, len(cut), inWindow)
+			}
+			for _, ev := range cut {
+				if ev.Ts < start || ev.Ts >= end {
+					t.Errorf("%v: event %v is outside of the window [%v, %v)", f, ev, start, end)
+					break
+				}
+				if filter != nil && ev.G != 0 && !filter[ev.G] {
+					t.Errorf("%v: event %v of a goroutine not in %v", f, ev, filter)
+					break
+				}
+			}
+			var buf bytes.Buffer
+			w, err := NewWriterVersion(&buf, WriterVersion(ver))
+			if err != nil {
+				t.Fatalf("failed to create writer: %v", err)
+			}
+			for _, ev := range cut {
+				if err = w.WriteEvent(ev); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			if err != nil {
+				t.Errorf("%v: failed to write cut of goroutines %v: %v", f, filter, err)
+				continue
+			}
+			_, parsed, err := parse(&buf, nil)
+			if err != nil {
+				t.Errorf("%v: failed to parse cut of goroutines %v: %v", f, filter, err)
+				continue
+			}
+			if len(parsed) != len(cut) {
+				t.Errorf("%v: parsed %v events of the cut of goroutines %v, want %v", f, len(parsed), filter, len(cut))
+			}
+			for _, ev := range parsed {
+				if ev.StkID != 0 && len(ev.Stk) == 0 && ver >= 1007 {
+					t.Errorf("%v: event %v of the cut of goroutines %v has no stack", f, ev, filter)
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package trace
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"sort"
+)
+
+// An InlineSymbolizer is a Symbolizer that also reports the functions
+// inlined at the PCs. Stack traces symbolized by an InlineSymbolizer
+// have a frame for each inlined call.
+type InlineSymbolizer interface {
+	Symbolizer
+	// SymbolizeInline returns the frames of the given PCs, innermost
+	// first. All frames of a PC have that PC.
+	SymbolizeInline(pcs []uint64) (map[uint64][]*Frame, error)
+}
+
+// ELFSymbolizer returns a Symbolizer that symbolizes in-process using
+// the Go symbol table and the DWARF information of the given ELF binary.
+// It also reports inlined calls if the binary has DWARF information.
+func ELFSymbolizer(bin string) InlineSymbolizer {
+	return elfSymbolizer(bin)
+}
+
+type elfSymbolizer string
+
+func (s elfSymbolizer) Symbolize(pcs []uint64) (map[uint64]*Frame, error) {
+	frames, err := s.SymbolizeInline(pcs)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[uint64]*Frame, len(frames))
+	for pc, fs := range frames {
+		// The outermost frame is the function the PC is in.
+		ret[pc] = fs[len(fs)-1]
+	}
+	return ret, nil
+}
+
+func (s elfSymbolizer) SymbolizeInline(pcs []uint64) (map[uint64][]*Frame, error) {
+	if len(s) == 0 {
+		return nil, fmt.Errorf("no binary name was specified")
+	}
+	f, err := elf.Open(string(s))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open binary: %v", err)
+	}
+	defer f.Close()
+	tab, err := goSymTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symbol table of %v: %v", s, err)
+	}
+	var funcs []*dwarfFunc
+	if d, err := f.DWARF(); err == nil {
+		// Without DWARF information, inlined calls are not reported.
+		funcs = dwarfFuncs(d)
+	}
+
+	ret := make(map[uint64][]*Frame, len(pcs))
+	for _, pc := range pcs {
+		// pc is a return address; pc-1 is in the call instruction.
+		file, line, fn := tab.PCToLine(pc - 1)
+		if fn == nil {
+			ret[pc] = []*Frame{{PC: pc, Fn: "?", File: "?"}}
+			continue
+		}
+		leaf := &Frame{PC: pc, Fn: fn.Name, File: file, Line: line}
+		frames := []*Frame{leaf}
+		// Each inlined call makes the frame below it a frame of the
+		// inlined function, called at the call site of the inlined call.
+		for _, call := range inlinedCalls(funcs, pc-1) {
+			caller := frames[len(frames)-1]
+			frames = append(frames, &Frame{PC: pc, Fn: caller.Fn, File: call.callFile, Line: call.callLine})
+			caller.Fn = call.name
+		}
+		ret[pc] = frames
+	}
+	return ret, nil
+}
+
+// goSymTable returns the Go symbol table of f.
+func goSymTable(f *elf.File) (*gosym.Table, error) {
+	pclntab := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, fmt.Errorf("no .gopclntab or .text section")
+	}
+	pclndata, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	var symdata []byte
+	if symtab := f.Section(".gosymtab"); symtab != nil {
+		if symdata, err = symtab.Data(); err != nil {
+			return nil, err
+		}
+	}
+	return gosym.NewTable(symdata, gosym.NewLineTable(pclndata, text.Addr))
+}
+
+// A dwarfFunc is a function of the DWARF information with the calls
+// inlined in it.
+type dwarfFunc struct {
+	low, high uint64
+	inlined   []*inlinedCall
+}
+
+// An inlinedCall is a call of an inlined function.
+type inlinedCall struct {
+	ranges   [][2]uint64
+	origin   dwarf.Offset // entry of the inlined function
+	name     string
+	callFile string
+	callLine int
+	inlined  []*inlinedCall // calls inlined in the inlined function
+}
+
+func (c *inlinedCall) contains(pc uint64) bool {
+	for _, r := range c.ranges {
+		if r[0] <= pc && pc < r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// dwarfFuncs returns the functions of d with inlined calls, sorted by
+// address. Malformed parts of the DWARF information are ignored.
+func dwarfFuncs(d *dwarf.Data) []*dwarfFunc {
+	var funcs []*dwarfFunc
+	names := make(map[dwarf.Offset]string)
+	var calls []*inlinedCall
+	r := d.Reader()
+	var files []*dwarf.LineFile // of the current compilation unit
+	var fn *dwarfFunc
+	var stack []*inlinedCall // enclosing inlined calls; nil for other entries
+	for {
+		e, err := r.Next()
+		if err != nil || e == nil {
+			break
+		}
+		if e.Tag == 0 {
+			if n := len(stack); n > 0 {
+				stack = stack[:n-1]
+			}
+			continue
+		}
+		switch e.Tag {
+		case dwarf.TagCompileUnit:
+			files = nil
+			if lr, err := d.LineReader(e); err == nil && lr != nil {
+				files = lr.Files()
+			}
+			fn, stack = nil, nil
+		case dwarf.TagSubprogram:
+			if name, ok := e.Val(dwarf.AttrName).(string); ok {
+				names[e.Offset] = name
+			}
+			if ranges, err := d.Ranges(e); err == nil && len(ranges) > 0 {
+				fn = &dwarfFunc{low: ranges[0][0], high: ranges[0][1]}
+				funcs = append(funcs, fn)
+			} else {
+				fn = nil
+			}
+		case dwarf.TagInlinedSubroutine:
+			call := &inlinedCall{}
+			call.ranges, _ = d.Ranges(e)
+			call.origin, _ = e.Val(dwarf.AttrAbstractOrigin).(dwarf.Offset)
+			if i, ok := e.Val(dwarf.AttrCallFile).(int64); ok && i >= 0 && int(i) < len(files) && files[i] != nil {
+				call.callFile = files[i].Name
+			}
+			if line, ok := e.Val(dwarf.AttrCallLine).(int64); ok {
+				call.callLine = int(line)
+			}
+			calls = append(calls, call)
+			if parent := innermostCall(stack); parent != nil {
+				parent.inlined = append(parent.inlined, call)
+			} else if fn != nil {
+				fn.inlined = append(fn.inlined, call)
+			}
+			if e.Children {
+				stack = append(stack, call)
+			}
+			continue
+		}
+		if e.Children {
+			stack = append(stack, nil)
+		}
+	}
+	for _, call := range calls {
+		call.name = names[call.origin]
+	}
+	sort.Slice(funcs, func(i, j int) bool { return funcs[i].low < funcs[j].low })
+	return funcs
+}
+
+// innermostCall returns the innermost inlined call of the stack of
+// enclosing entries, which may be nested in lexical blocks.
+func innermostCall(stack []*inlinedCall) *inlinedCall {
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i] != nil {
+			return stack[i]
+		}
+	}
+	return nil
+}
+
+// inlinedCalls returns the inlined calls at pc, innermost first.
+func inlinedCalls(funcs []*dwarfFunc, pc uint64) []*inlinedCall {
+	i := sort.Search(len(funcs), func(i int) bool { return funcs[i].low > pc }) - 1
+	if i < 0 || pc >= funcs[i].high {
+		return nil
+	}
+	var calls []*inlinedCall
+	for inlined := funcs[i].inlined; ; {
+		var next *inlinedCall
+		for _, c := range inlined {
+			if c.contains(pc) {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		calls = append([]*inlinedCall{next}, calls...)
+		inlined = next.inlined
+	}
+	return calls
+}
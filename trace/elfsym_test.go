@@ -0,0 +1,124 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// symbolizedProg prints the return address in caller, in the inlined
+// call of inlined, followed by the frames of the address.
+const symbolizedProg = `package main
+
+import (
+	"fmt"
+	"runtime"
+)
+
+//go:noinline
+func caller() []uintptr {
+	return inlined()
+}
+
+func inlined() []uintptr {
+	return callers()
+}
+
+//go:noinline
+func callers() []uintptr {
+	pcs := make([]uintptr, 8)
+	return pcs[:runtime.Callers(2, pcs)]
+}
+
+func main() {
+	pcs := caller()
+	fmt.Println(pcs[0])
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Println(f.Function, f.File, f.Line)
+		if f.Function == "main.caller" || !more {
+			break
+		}
+	}
+}
+`
+
+func TestELFSymbolizer(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skipf("no go command: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "elfsym")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src, bin := filepath.Join(dir, "prog.go"), filepath.Join(dir, "prog")
+	if err := ioutil.WriteFile(src, []byte(symbolizedProg), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "build", "-o", bin, src)
+	cmd.Env = append(os.Environ(), "GO111MODULE=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("failed to build program: %v\n%s", err, out)
+	}
+	if f, err := elf.Open(bin); err != nil {
+		t.Skipf("program is not an ELF file: %v", err)
+	} else {
+		f.Close()
+	}
+	out, err := exec.Command(bin).Output()
+	if err != nil {
+		t.Fatalf("failed to run program: %v", err)
+	}
+
+	var pc uint64
+	var want []*Frame
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		if pc == 0 {
+			if pc, err = strconv.ParseUint(s.Text(), 10, 64); err != nil {
+				t.Fatalf("bad output %q: %v", out, err)
+			}
+			continue
+		}
+		f := &Frame{PC: pc}
+		if _, err := fmt.Sscan(s.Text(), &f.Fn, &f.File, &f.Line); err != nil {
+			t.Fatalf("bad output %q: %v", out, err)
+		}
+		want = append(want, f)
+	}
+	if len(want) != 2 {
+		t.Fatalf("inlined is not inlined: %q", out)
+	}
+
+	frames, err := ELFSymbolizer(bin).SymbolizeInline([]uint64{pc})
+	if err != nil {
+		t.Fatalf("failed to symbolize: %v", err)
+	}
+	if !sameStack(frames[pc], want) {
+		t.Errorf("got frames %v, want %v", frameStrings(frames[pc]), frameStrings(want))
+	}
+	single, err := ELFSymbolizer(bin).Symbolize([]uint64{pc})
+	if err != nil {
+		t.Fatalf("failed to symbolize: %v", err)
+	}
+	if !sameStack([]*Frame{single[pc]}, want[1:]) {
+		t.Errorf("got frame %v, want the outermost frame %v", frameStrings([]*Frame{single[pc]}), frameStrings(want[1:]))
+	}
+}
+
+func frameStrings(stk []*Frame) []string {
+	var s []string
+	for _, f := range stk {
+		s = append(s, fmt.Sprintf("%v %v:%v", f.Fn, f.File, f.Line))
+	}
+	return s
+}
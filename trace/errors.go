@@ -0,0 +1,68 @@
+package trace
+
+import "fmt"
+
+// ErrorKind is the category of a ParseError.
+type ErrorKind int
+
+const (
+	KindWireFormat   ErrorKind = iota // the trace cannot be decoded
+	KindOrdering                      // the events cannot be put into a consistent order
+	KindStateMachine                  // an event is inconsistent with the state of goroutines, Ps or GC
+	KindTimeOrder                     // time stamps do not respect the order of events
+	KindLimit                         // the trace exceeds a limit of ParseOptions.Limits
+)
+
+var kindNames = [...]string{
+	KindWireFormat:   "wire-format",
+	KindOrdering:     "ordering",
+	KindStateMachine: "state-machine",
+	KindTimeOrder:    "time-order",
+	KindLimit:        "limit",
+}
+
+func (k ErrorKind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("ErrorKind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// ParseError is the error returned by the parser for a malformed or
+// inconsistent trace. It locates the offending event or data in the trace.
+type ParseError struct {
+	Kind ErrorKind
+	Off  int    // offset in the trace, -1 if the error is not about a particular place
+	Type byte   // type of the offending event, EvNone if unknown or not an Event type
+	G    uint64 // goroutine of the offending event
+	P    int    // P of the offending event, -1 if unknown
+	Ts   int64  // timestamp of the offending event; not known for KindWireFormat
+	Msg  string // description of the error
+}
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Off < 0:
+		return e.Msg
+	case e.Kind == KindWireFormat, e.Kind == KindLimit:
+		return fmt.Sprintf("%v (offset %v)", e.Msg, e.Off)
+	}
+	return fmt.Sprintf("%v (offset %v, time %v)", e.Msg, e.Off, e.Ts)
+}
+
+// Is reports whether target is ErrTimeOrder and e is of KindTimeOrder,
+// so that errors.Is(err, ErrTimeOrder) holds for all time order errors.
+func (e *ParseError) Is(target error) bool {
+	return target == ErrTimeOrder && e.Kind == KindTimeOrder
+}
+
+// wireErrorf returns a KindWireFormat error for the data at offset off
+// of an event of type typ.
+func wireErrorf(off int, typ byte, format string, args ...interface{}) *ParseError {
+	return &ParseError{Kind: KindWireFormat, Off: off, Type: typ, P: -1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// errorf returns a KindStateMachine error for the event.
+func (ev *Event) errorf(format string, args ...interface{}) *ParseError {
+	return &ParseError{Kind: KindStateMachine, Off: ev.Off, Type: ev.Type, G: ev.G, P: ev.P, Ts: ev.Ts, Msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,629 @@
+package trace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Since Go 1.22 the runtime emits traces in a different format.
+// The trace is split into generations, each of which is self-contained:
+// it has its own string and stack tables, its own clock frequency and
+// per-M (not per-P) batches of events. There is no global sequence
+// number; instead the goroutine and P sequence numbers and the state
+// of goroutines and Ps at the start of each generation establish the
+// partial order of events across Ms. See order122 for the merging.
+
+// Event types of the generation-based trace format.
+// Mirrors src/internal/trace/tracev2/events.go.
+const (
+	ev2None               = 0  // unused
+	ev2EventBatch         = 1  // start of per-M batch of events [generation, M id, timestamp, batch length]
+	ev2Stacks             = 2  // start of a section of the stack table [...ev2Stack]
+	ev2Stack              = 3  // stack table entry [id, number of frames, array of {PC, func string id, file string id, line}]
+	ev2Strings            = 4  // start of a section of the string dictionary [...ev2String]
+	ev2String             = 5  // string dictionary entry [id, length, string]
+	ev2CPUSamples         = 6  // start of a section of CPU samples [...ev2CPUSample]
+	ev2CPUSample          = 7  // CPU profiling sample [timestamp, M id, P id, goroutine id, stack id]
+	ev2Frequency          = 8  // timestamp units per second [frequency]
+	ev2ProcsChange        = 9  // current value of GOMAXPROCS [timestamp, GOMAXPROCS, stack id]
+	ev2ProcStart          = 10 // start of P [timestamp, P id, P seq]
+	ev2ProcStop           = 11 // stop of P [timestamp]
+	ev2ProcSteal          = 12 // P was stolen [timestamp, P id, P seq, M id]
+	ev2ProcStatus         = 13 // P status at the start of a generation [timestamp, P id, status]
+	ev2GoCreate           = 14 // goroutine creation [timestamp, new goroutine id, new stack id, stack id]
+	ev2GoCreateSyscall    = 15 // goroutine appears in syscall (cgo callback) [timestamp, new goroutine id]
+	ev2GoStart            = 16 // goroutine starts running [timestamp, goroutine id, goroutine seq]
+	ev2GoDestroy          = 17 // goroutine ends [timestamp]
+	ev2GoDestroySyscall   = 18 // goroutine ends in syscall (cgo callback) [timestamp]
+	ev2GoStop             = 19 // goroutine yields its time, but is runnable [timestamp, reason string id, stack id]
+	ev2GoBlock            = 20 // goroutine blocks [timestamp, reason string id, stack id]
+	ev2GoUnblock          = 21 // goroutine is unblocked [timestamp, goroutine id, goroutine seq, stack id]
+	ev2GoSyscallBegin     = 22 // syscall enter [timestamp, P seq, stack id]
+	ev2GoSyscallEnd       = 23 // syscall exit [timestamp]
+	ev2GoSyscallEndBlock  = 24 // syscall exit and it blocked at some point [timestamp]
+	ev2GoStatus           = 25 // goroutine status at the start of a generation [timestamp, goroutine id, M id, status]
+	ev2STWBegin           = 26 // STW start [timestamp, kind string id, stack id]
+	ev2STWEnd             = 27 // STW done [timestamp]
+	ev2GCActive           = 28 // GC active [timestamp, seq]
+	ev2GCBegin            = 29 // GC start [timestamp, seq, stack id]
+	ev2GCEnd              = 30 // GC done [timestamp, seq]
+	ev2GCSweepActive      = 31 // GC sweep active [timestamp, P id]
+	ev2GCSweepBegin       = 32 // GC sweep start [timestamp, stack id]
+	ev2GCSweepEnd         = 33 // GC sweep done [timestamp, swept, reclaimed]
+	ev2GCMarkAssistActive = 34 // GC mark assist active [timestamp, goroutine id]
+	ev2GCMarkAssistBegin  = 35 // GC mark assist start [timestamp, stack id]
+	ev2GCMarkAssistEnd    = 36 // GC mark assist done [timestamp]
+	ev2HeapAlloc          = 37 // heap live change [timestamp, heap alloc]
+	ev2HeapGoal           = 38 // heap goal change [timestamp, heap goal]
+	ev2GoLabel            = 39 // apply string label to current running goroutine [timestamp, label string id]
+	ev2UserTaskBegin      = 40 // trace.NewTask [timestamp, task id, parent task id, name string id, stack id]
+	ev2UserTaskEnd        = 41 // end of a task [timestamp, task id, stack id]
+	ev2UserRegionBegin    = 42 // trace.{Start,With}Region [timestamp, task id, name string id, stack id]
+	ev2UserRegionEnd      = 43 // trace.{End,With}Region [timestamp, task id, name string id, stack id]
+	ev2UserLog            = 44 // trace.Log [timestamp, task id, key string id, value string id, stack id]
+	ev2GoSwitch           = 45 // goroutine switch (coroswitch) [timestamp, goroutine id, goroutine seq]
+	ev2GoSwitchDestroy    = 46 // goroutine switch and destroy [timestamp, goroutine id, goroutine seq]
+	ev2GoCreateBlocked    = 47 // goroutine creation (starts blocked) [timestamp, new goroutine id, new stack id, stack id]
+	ev2GoStatusStack      = 48 // goroutine status at the start of a generation, with a stack [timestamp, goroutine id, M id, status, stack id]
+	ev2ExperimentalBatch  = 49 // start of extra data [experiment id, generation, M id, timestamp, batch length, batch data...]
+	ev2Sync               = 50 // start of a sync batch [...ev2Frequency|ev2ClockSnapshot]
+	ev2ClockSnapshot      = 51 // snapshot of trace, mono and wall clocks [timestamp, mono, sec, nsec]
+	ev2EndOfGeneration    = 52 // end of the generation
+	ev2Count              = 53
+
+	// Experimental events are not interpreted, but they may appear in
+	// regular batches when the runtime experiments are enabled.
+	ev2ExperimentalFirst = 128
+	ev2ExperimentalLast  = 136
+)
+
+// ev2Descriptions describes the timed events that appear in the regular
+// batches. Args lists the arguments following the timestamp delta.
+var ev2Descriptions = [ev2Count]struct {
+	Name       string
+	minVersion int
+	Args       []string
+}{
+	ev2ProcsChange:        {"ProcsChange", 1022, []string{"procs", "stack"}},
+	ev2ProcStart:          {"ProcStart", 1022, []string{"p", "pseq"}},
+	ev2ProcStop:           {"ProcStop", 1022, []string{}},
+	ev2ProcSteal:          {"ProcSteal", 1022, []string{"p", "pseq", "m"}},
+	ev2ProcStatus:         {"ProcStatus", 1022, []string{"p", "status"}},
+	ev2GoCreate:           {"GoCreate", 1022, []string{"g", "newstack", "stack"}},
+	ev2GoCreateSyscall:    {"GoCreateSyscall", 1022, []string{"g"}},
+	ev2GoStart:            {"GoStart", 1022, []string{"g", "gseq"}},
+	ev2GoDestroy:          {"GoDestroy", 1022, []string{}},
+	ev2GoDestroySyscall:   {"GoDestroySyscall", 1022, []string{}},
+	ev2GoStop:             {"GoStop", 1022, []string{"reason", "stack"}},
+	ev2GoBlock:            {"GoBlock", 1022, []string{"reason", "stack"}},
+	ev2GoUnblock:          {"GoUnblock", 1022, []string{"g", "gseq", "stack"}},
+	ev2GoSyscallBegin:     {"GoSyscallBegin", 1022, []string{"pseq", "stack"}},
+	ev2GoSyscallEnd:       {"GoSyscallEnd", 1022, []string{}},
+	ev2GoSyscallEndBlock:  {"GoSyscallEndBlocked", 1022, []string{}},
+	ev2GoStatus:           {"GoStatus", 1022, []string{"g", "m", "status"}},
+	ev2STWBegin:           {"STWBegin", 1022, []string{"kind", "stack"}},
+	ev2STWEnd:             {"STWEnd", 1022, []string{}},
+	ev2GCActive:           {"GCActive", 1022, []string{"seq"}},
+	ev2GCBegin:            {"GCBegin", 1022, []string{"seq", "stack"}},
+	ev2GCEnd:              {"GCEnd", 1022, []string{"seq"}},
+	ev2GCSweepActive:      {"GCSweepActive", 1022, []string{"p"}},
+	ev2GCSweepBegin:       {"GCSweepBegin", 1022, []string{"stack"}},
+	ev2GCSweepEnd:         {"GCSweepEnd", 1022, []string{"swept", "reclaimed"}},
+	ev2GCMarkAssistActive: {"GCMarkAssistActive", 1022, []string{"g"}},
+	ev2GCMarkAssistBegin:  {"GCMarkAssistBegin", 1022, []string{"stack"}},
+	ev2GCMarkAssistEnd:    {"GCMarkAssistEnd", 1022, []string{}},
+	ev2HeapAlloc:          {"HeapAlloc", 1022, []string{"mem"}},
+	ev2HeapGoal:           {"HeapGoal", 1022, []string{"mem"}},
+	ev2GoLabel:            {"GoLabel", 1022, []string{"label"}},
+	ev2UserTaskBegin:      {"UserTaskBegin", 1022, []string{"taskid", "parent", "name", "stack"}},
+	ev2UserTaskEnd:        {"UserTaskEnd", 1022, []string{"taskid", "stack"}},
+	ev2UserRegionBegin:    {"UserRegionBegin", 1022, []string{"taskid", "name", "stack"}},
+	ev2UserRegionEnd:      {"UserRegionEnd", 1022, []string{"taskid", "name", "stack"}},
+	ev2UserLog:            {"UserLog", 1022, []string{"taskid", "key", "value", "stack"}},
+	ev2GoSwitch:           {"GoSwitch", 1023, []string{"g", "gseq"}},
+	ev2GoSwitchDestroy:    {"GoSwitchDestroy", 1023, []string{"g", "gseq"}},
+	ev2GoCreateBlocked:    {"GoCreateBlocked", 1023, []string{"g", "newstack", "stack"}},
+	ev2GoStatusStack:      {"GoStatusStack", 1023, []string{"g", "m", "status", "stack"}},
+}
+
+// ev2ExperimentalArgs is the number of arguments following the timestamp
+// delta of the experimental events, starting at ev2ExperimentalFirst.
+var ev2ExperimentalArgs = [ev2ExperimentalLast - ev2ExperimentalFirst + 1]int{3, 3, 1, 2, 2, 1, 2, 2, 1}
+
+// Goroutine statuses in ev2GoStatus events.
+const (
+	go2Bad = iota
+	go2Runnable
+	go2Running
+	go2Syscall
+	go2Waiting
+)
+
+// P statuses in ev2ProcStatus events.
+const (
+	proc2Bad = iota
+	proc2Running
+	proc2Idle
+	proc2Syscall
+	proc2SyscallAbandoned
+)
+
+const (
+	maxBatchSize2    = 64 << 10 // maximum size of a batch
+	maxFramesPerStk2 = 128      // maximum number of frames in a stack
+	maxStringSize2   = 1 << 10  // maximum length of a string
+)
+
+// batch2 is a batch of events of a single M. The data is not decoded.
+type batch2 struct {
+	m    uint64
+	time uint64 // base timestamp of the batch
+	off  int    // offset of the batch data in the input
+	data []byte
+}
+
+// event2 is a decoded event of the generation-based format.
+type event2 struct {
+	typ  byte
+	off  int
+	ts   uint64    // absolute timestamp in trace clock units
+	args [4]uint64 // arguments following the timestamp delta
+}
+
+// frame2 is an entry of the per-generation PC table.
+type frame2 struct {
+	fn   uint64 // function name string id
+	file uint64 // file name string id
+	line uint64
+}
+
+// generation holds the self-contained part of a trace.
+type generation struct {
+	gen     uint64
+	freq    float64             // nanoseconds per trace clock unit
+	minTs   uint64              // smallest batch timestamp
+	batches map[uint64][]batch2 // event batches by M
+	ms      []uint64            // Ms in the order of their first batch
+	strings map[uint64]string
+	stacks  map[uint64][]uint64 // stack id to PCs
+	frames  map[uint64]frame2   // PC to frame
+	lim     *limiter
+}
+
+// offReader keeps track of the offset in the input for error reporting.
+type offReader struct {
+	r   byteReader
+	off int
+}
+
+// byteReader is implemented by bufio.Reader and bytes.Reader.
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func (r *offReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		r.off++
+	}
+	return b, err
+}
+
+func (r *offReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	r.off += n
+	return n, err
+}
+
+// genReader splits the input into generations.
+type genReader struct {
+	r        *offReader
+	ver      int
+	spill    *batch2 // first batch of the next generation
+	spillGen uint64
+	lim      *limiter
+	spillOff int
+	batchOff int // offset of the batch being read
+	eof      bool
+}
+
+// readGenerations parses a trace in the generation-based format.
+// The trace header must be already consumed from r.
+// It returns the ordered events and the stack traces they refer to.
+// If rec is not nil, a read error ends the trace with the part of the
+// generation read before it, and a generation that cannot be decoded
+// ends the trace with the preceding generations.
+// The progress of decoding is reported to t.
+func readGenerations(ver int, r byteReader, rec *Recovery, t *tracker) (events []*Event, stacks map[uint64][]*Frame, err error) {
+	gr, err := newGenReader(ver, r)
+	if err != nil {
+		return
+	}
+	gr.lim = t.limiter()
+	o := newOrdering2(ver)
+	o.rec = rec
+	for {
+		off := gr.offset()
+		var g *generation
+		g, err = gr.next()
+		if err != nil && rec != nil && g != nil && len(g.ms) != 0 {
+			// Keep the part of the generation read before the error
+			// and end the trace with it.
+			rec.fail(gr.batchOff, err)
+			err = nil
+			gr.eof = true
+			if g.freq == 0 {
+				if o.gen != nil {
+					g.freq = o.gen.freq
+				} else {
+					g.freq = 1
+					rec.NoFrequency = true
+				}
+			}
+		}
+		if err == nil && g != nil {
+			t.logf("order generation %v", g.gen)
+			err = o.addGeneration(g)
+			t.done(gr.offset())
+		}
+		if err != nil {
+			if rec == nil || o.gen == nil {
+				return
+			}
+			rec.fail(off, err)
+			err = nil
+			break
+		}
+		if g == nil {
+			break
+		}
+	}
+	events, stacks = o.flush(), o.stacks
+	return
+}
+
+// newGenReader returns a reader of the generations that follow
+// the trace header in r.
+func newGenReader(ver int, r byteReader) (*genReader, error) {
+	switch ver {
+	case 1022, 1023, 1025, 1026:
+		break
+	default:
+		return nil, wireErrorf(0, EvNone, "unsupported trace file version %v.%v (update Go toolchain) %v", ver/1000, ver%1000, ver)
+	}
+	return &genReader{r: &offReader{r: r, off: 16}, ver: ver}, nil
+}
+
+// offset returns the offset of the first batch of the next generation.
+func (gr *genReader) offset() int {
+	if gr.spill != nil {
+		return gr.spillOff
+	}
+	return gr.r.off
+}
+
+// next reads the next generation. It returns nil at the end of the input.
+// On error, it also returns the part of the generation read before the error.
+func (gr *genReader) next() (*generation, error) {
+	g := &generation{
+		batches: make(map[uint64][]batch2),
+		strings: make(map[uint64]string),
+		stacks:  make(map[uint64][]uint64),
+		frames:  make(map[uint64]frame2),
+		lim:     gr.lim,
+	}
+	if gr.spill != nil {
+		g.gen = gr.spillGen
+		gr.batchOff = gr.spillOff
+		if err := g.add(*gr.spill, gr.ver); err != nil {
+			return g, err
+		}
+		gr.spill = nil
+	}
+	for !gr.eof {
+		off0 := gr.r.off
+		gr.batchOff = off0
+		b, gen, typ, err := readBatch2(gr.r, gr.lim)
+		if err == io.EOF && off0 == gr.r.off {
+			gr.eof = true
+			break
+		}
+		if err != nil {
+			return g, err
+		}
+		if typ == ev2EndOfGeneration {
+			if g.gen == 0 {
+				continue
+			}
+			break
+		}
+		if typ == ev2ExperimentalBatch {
+			continue
+		}
+		if gen == 0 {
+			return g, wireErrorf(off0, EvNone, "batch has invalid generation 0")
+		}
+		if g.gen == 0 {
+			g.gen = gen
+		}
+		if gen != g.gen {
+			if gen != g.gen+1 {
+				return g, wireErrorf(off0, EvNone, "batch belongs to generation %v while reading generation %v", gen, g.gen)
+			}
+			// Before Go 1.26 there is no end of generation marker,
+			// the first batch of the next generation ends this one.
+			gr.spill, gr.spillGen, gr.spillOff = &b, gen, off0
+			break
+		}
+		if err := g.add(b, gr.ver); err != nil {
+			return g, err
+		}
+	}
+	if g.gen == 0 {
+		return nil, nil
+	}
+	if g.freq == 0 {
+		gr.batchOff = gr.r.off
+		return g, &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: fmt.Sprintf("no frequency event in generation %v", g.gen)}
+	}
+	return g, nil
+}
+
+// readBatch2 reads a batch header and the batch data within the limits
+// of lim. For ev2EndOfGeneration, b is empty.
+func readBatch2(r *offReader, lim *limiter) (b batch2, gen uint64, typ byte, err error) {
+	off0 := r.off
+	typ, err = r.ReadByte()
+	if err != nil {
+		return
+	}
+	switch typ {
+	case ev2EndOfGeneration:
+		return
+	case ev2EventBatch, ev2ExperimentalBatch:
+	default:
+		err = wireErrorf(off0, EvNone, "expected batch, got event type %v", typ)
+		return
+	}
+	if typ == ev2ExperimentalBatch {
+		// Experiment id.
+		if _, err = r.ReadByte(); err != nil {
+			err = wireErrorf(off0, EvNone, "failed to read batch header: %v", err)
+			return
+		}
+	}
+	var hdr [4]uint64 // generation, M id, timestamp, size
+	for i := range hdr {
+		hdr[i], err = binary.ReadUvarint(r)
+		if err != nil {
+			err = wireErrorf(off0, EvNone, "failed to read batch header: %v", err)
+			return
+		}
+	}
+	if hdr[3] > maxBatchSize2 {
+		err = wireErrorf(off0, EvNone, "batch has too large size %v", hdr[3])
+		return
+	}
+	if err = lim.alloc(off0, EvNone, int64(hdr[3])); err != nil {
+		return
+	}
+	b = batch2{m: hdr[1], time: hdr[2], off: r.off, data: make([]byte, hdr[3])}
+	var n int
+	n, err = io.ReadFull(r, b.data)
+	if err != nil {
+		err = wireErrorf(off0, EvNone, "failed to read batch: read %v, want %v, error %v", n, hdr[3], err)
+		return
+	}
+	return b, hdr[0], typ, nil
+}
+
+// add adds the batch to the generation. Event batches are kept
+// for ordering, the other batches are decoded into the tables.
+func (g *generation) add(b batch2, ver int) error {
+	if len(b.data) == 0 {
+		return nil
+	}
+	r := &batchReader{data: b.data, off: b.off}
+	switch typ := b.data[0]; {
+	case typ == ev2Strings:
+		r.pos++
+		return g.addStrings(r)
+	case typ == ev2Stacks:
+		r.pos++
+		return g.addStacks(r)
+	case typ == ev2CPUSamples:
+		// CPU samples are not part of the event model.
+		return nil
+	case typ == ev2Frequency && ver < 1025, typ == ev2Sync && ver >= 1025:
+		if ver >= 1025 {
+			r.pos++
+		}
+		return g.setSync(r)
+	}
+	if _, ok := g.batches[b.m]; !ok {
+		g.ms = append(g.ms, b.m)
+	}
+	g.batches[b.m] = append(g.batches[b.m], b)
+	if g.minTs == 0 || b.time < g.minTs {
+		g.minTs = b.time
+	}
+	return nil
+}
+
+func (g *generation) addStrings(r *batchReader) error {
+	for !r.done() {
+		off0 := r.offset()
+		if typ := r.byte(); typ != ev2String {
+			return wireErrorf(off0, EvNone, "expected string, got event type %v", typ)
+		}
+		id, ln := r.val(), r.val()
+		if r.err == nil && ln > maxStringSize2 {
+			return wireErrorf(off0, EvNone, "string has too large length %v", ln)
+		}
+		s := r.bytes(int(ln))
+		if r.err != nil {
+			return wireErrorf(off0, EvNone, "failed to read string: %v", r.err)
+		}
+		if _, ok := g.strings[id]; ok {
+			return wireErrorf(off0, EvNone, "string has duplicate id %v", id)
+		}
+		if err := g.lim.str(off0, ln); err != nil {
+			return err
+		}
+		g.strings[id] = string(s)
+	}
+	return nil
+}
+
+func (g *generation) addStacks(r *batchReader) error {
+	for !r.done() {
+		off0 := r.offset()
+		if typ := r.byte(); typ != ev2Stack {
+			return wireErrorf(off0, EvNone, "expected stack, got event type %v", typ)
+		}
+		id, n := r.val(), r.val()
+		if r.err == nil && n > maxFramesPerStk2 {
+			return wireErrorf(off0, EvNone, "stack has too many frames %v", n)
+		}
+		if err := g.lim.stack(off0, n); err != nil {
+			return err
+		}
+		pcs := make([]uint64, 0, n)
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			pc, fn, file, line := r.val(), r.val(), r.val(), r.val()
+			pcs = append(pcs, pc)
+			if _, ok := g.frames[pc]; !ok {
+				g.frames[pc] = frame2{fn: fn, file: file, line: line}
+			}
+		}
+		if r.err != nil {
+			return wireErrorf(off0, EvNone, "failed to read stack: %v", r.err)
+		}
+		if _, ok := g.stacks[id]; ok {
+			return wireErrorf(off0, EvNone, "stack has duplicate id %v", id)
+		}
+		g.stacks[id] = pcs
+	}
+	return nil
+}
+
+func (g *generation) setSync(r *batchReader) error {
+	for !r.done() {
+		off0 := r.offset()
+		switch typ := r.byte(); typ {
+		case ev2Frequency:
+			if g.freq != 0 {
+				return wireErrorf(off0, EvNone, "duplicate frequency")
+			}
+			freq := r.val()
+			if r.err == nil && freq == 0 {
+				return wireErrorf(off0, EvNone, "frequency is zero")
+			}
+			g.freq = 1e9 / float64(freq)
+		case ev2ClockSnapshot:
+			// Wall clock snapshots are not used.
+			r.val()
+			r.val()
+			r.val()
+			r.val()
+		default:
+			return wireErrorf(off0, EvNone, "expected frequency, got event type %v", typ)
+		}
+		if r.err != nil {
+			return wireErrorf(off0, EvNone, "failed to read sync batch: %v", r.err)
+		}
+	}
+	return nil
+}
+
+// events decodes the events of the batch.
+func (g *generation) events(b batch2, ver int) ([]event2, error) {
+	var events []event2
+	r := &batchReader{data: b.data, off: b.off}
+	ts := b.time
+	for !r.done() {
+		ev := event2{off: r.offset(), typ: r.byte()}
+		var narg int
+		switch {
+		case ev.typ >= ev2ExperimentalFirst && ev.typ <= ev2ExperimentalLast && ver >= 1023:
+			narg = ev2ExperimentalArgs[ev.typ-ev2ExperimentalFirst]
+		case ev.typ < ev2Count && ev2Descriptions[ev.typ].Name != "" && ev2Descriptions[ev.typ].minVersion <= ver:
+			narg = len(ev2Descriptions[ev.typ].Args)
+		default:
+			return nil, wireErrorf(ev.off, EvNone, "unknown event type %v", ev.typ)
+		}
+		ts += r.val()
+		ev.ts = ts
+		for i := 0; i < narg; i++ {
+			v := r.val()
+			if i < len(ev.args) {
+				ev.args[i] = v
+			}
+		}
+		if r.err != nil {
+			return nil, wireErrorf(ev.off, EvNone, "failed to read event %v: %v", ev.typ, r.err)
+		}
+		if err := g.lim.event(ev.off, EvNone); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// batchReader decodes the data of a batch.
+// The first error is sticky and is reported in err.
+type batchReader struct {
+	data []byte
+	pos  int
+	off  int // offset of data in the input
+	err  error
+}
+
+func (r *batchReader) done() bool {
+	return r.err != nil || r.pos >= len(r.data)
+}
+
+func (r *batchReader) offset() int {
+	return r.off + r.pos
+}
+
+func (r *batchReader) byte() byte {
+	if r.pos >= len(r.data) {
+		if r.err == nil {
+			r.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *batchReader) val() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = errBadValue
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *batchReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.pos+n > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b
+}
@@ -7,10 +7,16 @@ package trace
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"math"
+	"strings"
 	"time"
 )
 
 // GDesc contains statistics about execution of a single goroutine.
+// ExecTime, SchedWaitTime, IOTime, the Block*Time except BlockTime,
+// SleepTime and SyscallTime split the life of the goroutine and add up
+// to TotalTime. GCTime and SweepTime overlap them.
 type GDesc struct {
 	ID           uint64
 	Name         string
@@ -19,14 +25,22 @@ type GDesc struct {
 	StartTime    int64
 	EndTime      int64
 
-	ExecTime      int64
-	SchedWaitTime int64
-	IOTime        int64
-	BlockTime     int64
-	SyscallTime   int64
-	GCTime        int64
-	SweepTime     int64
-	TotalTime     int64
+	ExecTime         int64
+	SchedWaitTime    int64
+	IOTime           int64
+	BlockTime        int64 // sum of the send, receive, select, sync and cond times
+	BlockSendTime    int64 // blocked on channel send
+	BlockRecvTime    int64 // blocked on channel receive
+	BlockSelectTime  int64 // blocked in select
+	BlockSyncTime    int64 // blocked on Mutex or RWMutex
+	BlockCondTime    int64 // blocked on Cond
+	BlockGCTime      int64 // blocked on GC assist
+	BlockUnknownTime int64 // blocked for other reasons, stopped, or since before tracing started
+	SleepTime        int64
+	SyscallTime      int64
+	GCTime           int64
+	SweepTime        int64
+	TotalTime        int64
 
 	*gdesc // private part
 }
@@ -37,163 +51,316 @@ func dur(nsec int64) time.Duration {
 
 func (gd *GDesc) String() string {
 	buf := new(bytes.Buffer)
-	fmt.Fprintf(buf, "%d\t%s pc=%d creation=%d start=%d end=%d exec=%v sched_wait=%v io=%v block=%v sys=%v gc=%v sweep=%v total=%v\n",
+	fmt.Fprintf(buf, "%d\t%s pc=%d creation=%d start=%d end=%d exec=%v sched_wait=%v io=%v block=%v (send=%v recv=%v select=%v sync=%v cond=%v) gc_assist=%v block_unknown=%v sleep=%v sys=%v gc=%v sweep=%v total=%v\n",
 		gd.ID, gd.Name, gd.PC, gd.CreationTime, gd.StartTime, gd.EndTime,
 		dur(gd.ExecTime), dur(gd.SchedWaitTime), dur(gd.IOTime),
-		dur(gd.BlockTime), dur(gd.SyscallTime), dur(gd.GCTime),
-		dur(gd.SweepTime), dur(gd.TotalTime))
+		dur(gd.BlockTime), dur(gd.BlockSendTime), dur(gd.BlockRecvTime),
+		dur(gd.BlockSelectTime), dur(gd.BlockSyncTime), dur(gd.BlockCondTime),
+		dur(gd.BlockGCTime), dur(gd.BlockUnknownTime), dur(gd.SleepTime),
+		dur(gd.SyscallTime), dur(gd.GCTime), dur(gd.SweepTime), dur(gd.TotalTime))
 	return buf.String()
 }
 
 // gdesc is a private part of GDesc that is required only during analysis.
 type gdesc struct {
-	lastStartTime    int64
-	blockNetTime     int64
-	blockSyncTime    int64
-	blockSyscallTime int64
-	blockSweepTime   int64
-	blockGCTime      int64
-	blockSchedTime   int64
+	state          *int64 // time of the current state, nil once the goroutine ends
+	stateTime      int64  // start of the current state
+	blockSweepTime int64
 }
 
 // GoroutineStats generates statistics for all goroutines in the trace.
 func GoroutineStats(events []*Event) map[uint64]*GDesc {
-	gs := make(map[uint64]*GDesc)
-	var lastTs int64
-	var gcStartTime int64
+	return GoroutineStatsWindow(events, math.MinInt64, math.MaxInt64)
+}
+
+// GoroutineStatsWindow is like GoroutineStats but accounts only the time
+// within the window [start, end] of the trace. The times of states, GC
+// and sweeping, and TotalTime are clipped to the window, while
+// CreationTime, StartTime and EndTime are not. Goroutines that did not
+// exist during the window are omitted.
+func GoroutineStatsWindow(events []*Event, start, end int64) map[uint64]*GDesc {
+	s := newGoroutineStats()
+	s.start, s.end = start, end
 	for _, ev := range events {
-		lastTs = ev.Ts
-		switch ev.Type {
-		case EvGoCreate:
-			g := &GDesc{ID: ev.Args[0], CreationTime: ev.Ts, gdesc: new(gdesc)}
-			g.blockSchedTime = ev.Ts
-			gs[g.ID] = g
-		case EvGoStart:
-			g := gs[ev.G]
-			if g.PC == 0 {
-				g.PC = ev.Stk[0].PC
-				g.Name = ev.Stk[0].Fn
-			}
-			g.lastStartTime = ev.Ts
-			if g.StartTime == 0 {
-				g.StartTime = ev.Ts
-			}
-			if g.blockSchedTime != 0 {
-				g.SchedWaitTime += ev.Ts - g.blockSchedTime
-				g.blockSchedTime = 0
-			}
-		case EvGoEnd, EvGoStop:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-			g.TotalTime = ev.Ts - g.CreationTime
-			g.EndTime = ev.Ts
-		case EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect,
-			EvGoBlockSync, EvGoBlockCond:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-			g.blockSyncTime = ev.Ts
-		case EvGoSched, EvGoPreempt:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-			g.blockSchedTime = ev.Ts
-		case EvGoSleep, EvGoBlock:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-		case EvGoBlockNet:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-			g.blockNetTime = ev.Ts
-		case EvGoUnblock:
-			g := gs[ev.Args[0]]
-			if g.blockNetTime != 0 {
-				g.IOTime += ev.Ts - g.blockNetTime
-				g.blockNetTime = 0
-			}
-			if g.blockSyncTime != 0 {
-				g.BlockTime += ev.Ts - g.blockSyncTime
-				g.blockSyncTime = 0
-			}
-			g.blockSchedTime = ev.Ts
-		case EvGoSysBlock:
-			g := gs[ev.G]
-			g.ExecTime += ev.Ts - g.lastStartTime
-			g.blockSyscallTime = ev.Ts
-		case EvGoSysExit:
-			g := gs[ev.G]
-			if g.blockSyscallTime != 0 {
-				g.SyscallTime += ev.Ts - g.blockSyscallTime
-				g.blockSyscallTime = 0
-			}
-			g.blockSchedTime = ev.Ts
-		case EvGCSweepStart:
-			g := gs[ev.G]
-			if g != nil {
-				// Sweep can happen during GC on system goroutine.
-				g.blockSweepTime = ev.Ts
-			}
-		case EvGCSweepDone:
-			g := gs[ev.G]
-			if g != nil && g.blockSweepTime != 0 {
-				g.SweepTime += ev.Ts - g.blockSweepTime
-				g.blockSweepTime = 0
-			}
-		case EvGCStart:
-			gcStartTime = ev.Ts
-		case EvGCDone:
-			for _, g := range gs {
-				if g.EndTime == 0 {
-					g.GCTime += ev.Ts - gcStartTime
-				}
-			}
+		s.add(ev)
+	}
+	return s.finish()
+}
+
+// ReadGoroutineStats is like GoroutineStats but computes the statistics
+// in a single pass over the events read from r.
+func ReadGoroutineStats(r *Reader) (map[uint64]*GDesc, error) {
+	s := newGoroutineStats()
+	s.start, s.end = math.MinInt64, math.MaxInt64
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
 		}
+		s.add(ev)
 	}
+	return s.finish(), nil
+}
+
+// goroutineStats accumulates the statistics of goroutines over events.
+type goroutineStats struct {
+	gs          map[uint64]*GDesc
+	lastTs      int64
+	gcStartTime int64
+	start, end  int64 // window of the accounted time
+}
 
-	for _, g := range gs {
-		if g.TotalTime == 0 {
-			g.TotalTime = lastTs - g.CreationTime
+func newGoroutineStats() *goroutineStats {
+	return &goroutineStats{gs: make(map[uint64]*GDesc)}
+}
+
+// clip returns the part of the interval [t0, t1] within the window.
+func (s *goroutineStats) clip(t0, t1 int64) int64 {
+	if t0 < s.start {
+		t0 = s.start
+	}
+	if t1 > s.end {
+		t1 = s.end
+	}
+	if t1 < t0 {
+		return 0
+	}
+	return t1 - t0
+}
+
+// enter accounts the time since the last change of state of g to its
+// current state and switches to the state whose time is counted by state.
+func (s *goroutineStats) enter(g *GDesc, state *int64, ts int64) {
+	if g.state != nil {
+		*g.state += s.clip(g.stateTime, ts)
+	}
+	g.state = state
+	g.stateTime = ts
+}
+
+func (s *goroutineStats) add(ev *Event) {
+	s.lastTs = ev.Ts
+	switch ev.Type {
+	case EvGoCreate:
+		g := &GDesc{ID: ev.Args[0], CreationTime: ev.Ts, gdesc: new(gdesc)}
+		s.enter(g, &g.SchedWaitTime, ev.Ts)
+		s.gs[g.ID] = g
+	case EvGoStart, EvGoStartLabel:
+		g := s.gs[ev.G]
+		if g.PC == 0 && len(ev.Stk) > 0 {
+			g.PC = ev.Stk[0].PC
+			g.Name = ev.Stk[0].Fn
 		}
-		if g.EndTime == 0 {
-			g.EndTime = lastTs
+		if g.StartTime == 0 {
+			g.StartTime = ev.Ts
 		}
-		if g.blockNetTime != 0 {
-			g.IOTime += lastTs - g.blockNetTime
-			g.blockNetTime = 0
+		s.enter(g, &g.ExecTime, ev.Ts)
+	case EvGoEnd:
+		g := s.gs[ev.G]
+		s.enter(g, nil, ev.Ts)
+		g.TotalTime = s.clip(g.CreationTime, ev.Ts)
+		g.EndTime = ev.Ts
+	case EvGoBlockSend:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockSendTime, ev.Ts)
+	case EvGoBlockRecv:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockRecvTime, ev.Ts)
+	case EvGoBlockSelect:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockSelectTime, ev.Ts)
+	case EvGoBlockSync:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockSyncTime, ev.Ts)
+	case EvGoBlockCond:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockCondTime, ev.Ts)
+	case EvGoBlockGC:
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockGCTime, ev.Ts)
+	case EvGoBlock, EvGoStop:
+		// A stopped goroutine, like one in select{}, stays blocked
+		// until the end of the trace.
+		g := s.gs[ev.G]
+		s.enter(g, &g.BlockUnknownTime, ev.Ts)
+	case EvGoSleep:
+		g := s.gs[ev.G]
+		s.enter(g, &g.SleepTime, ev.Ts)
+	case EvGoBlockNet:
+		g := s.gs[ev.G]
+		s.enter(g, &g.IOTime, ev.Ts)
+	case EvGoSched, EvGoPreempt:
+		g := s.gs[ev.G]
+		s.enter(g, &g.SchedWaitTime, ev.Ts)
+	case EvGoUnblock:
+		g := s.gs[ev.Args[0]]
+		s.enter(g, &g.SchedWaitTime, ev.Ts)
+	case EvGoWaiting:
+		// The goroutine was blocked for an unknown reason
+		// when tracing started.
+		g := s.gs[ev.Args[0]]
+		s.enter(g, &g.BlockUnknownTime, ev.Ts)
+	case EvGoInSyscall:
+		g := s.gs[ev.Args[0]]
+		s.enter(g, &g.SyscallTime, ev.Ts)
+	case EvGoSysBlock:
+		g := s.gs[ev.G]
+		s.enter(g, &g.SyscallTime, ev.Ts)
+	case EvGoSysExit:
+		g := s.gs[ev.G]
+		s.enter(g, &g.SchedWaitTime, ev.Ts)
+	case EvGCSweepStart:
+		g := s.gs[ev.G]
+		if g != nil {
+			// Sweep can happen during GC on system goroutine.
+			g.blockSweepTime = ev.Ts
 		}
-		if g.blockSyncTime != 0 {
-			g.BlockTime += lastTs - g.blockSyncTime
-			g.blockSyncTime = 0
+	case EvGCSweepDone:
+		g := s.gs[ev.G]
+		if g != nil && g.blockSweepTime != 0 {
+			g.SweepTime += s.clip(g.blockSweepTime, ev.Ts)
+			g.blockSweepTime = 0
 		}
-		if g.blockSyscallTime != 0 {
-			g.SyscallTime += lastTs - g.blockSyscallTime
-			g.blockSyscallTime = 0
+	case EvGCStart:
+		s.gcStartTime = ev.Ts
+	case EvGCDone:
+		for _, g := range s.gs {
+			if g.EndTime == 0 {
+				g.GCTime += s.clip(s.gcStartTime, ev.Ts)
+			}
 		}
-		if g.blockSchedTime != 0 {
-			g.SchedWaitTime += lastTs - g.blockSchedTime
-			g.blockSchedTime = 0
+	}
+}
+
+func (s *goroutineStats) finish() map[uint64]*GDesc {
+	for id, g := range s.gs {
+		if g.state != nil {
+			// The goroutine did not end before tracing stopped.
+			s.enter(g, nil, s.lastTs)
+			g.TotalTime = s.clip(g.CreationTime, s.lastTs)
+			g.EndTime = s.lastTs
+		}
+		if g.CreationTime > s.end || g.EndTime < s.start {
+			delete(s.gs, id)
+			continue
 		}
+		g.BlockTime = g.BlockSendTime + g.BlockRecvTime + g.BlockSelectTime + g.BlockSyncTime + g.BlockCondTime
 		g.gdesc = nil
 	}
 
-	return gs
+	return s.gs
 }
 
-// RelatedGoroutines finds a set of goroutines related to goroutine goid.
+// GEdges is a set of kinds of relations between goroutines that
+// RelatedGoroutinesEdges follows.
+type GEdges uint
+
+const (
+	EdgeCreatedBy   GEdges = 1 << iota // to the goroutine that created the goroutine
+	EdgeCreates                        // to the goroutines the goroutine created
+	EdgeUnblockedBy                    // to the goroutines that unblocked the goroutine
+	EdgeUnblocks                       // to the goroutines the goroutine unblocked
+)
+
+var gEdgeNames = []struct {
+	edge GEdges
+	name string
+}{
+	{EdgeCreatedBy, "created-by"},
+	{EdgeCreates, "creates"},
+	{EdgeUnblockedBy, "unblocked-by"},
+	{EdgeUnblocks, "unblocks"},
+}
+
+// ParseGEdges parses a comma-separated list of the kinds of edges
+// created-by, creates, unblocked-by and unblocks.
+func ParseGEdges(s string) (GEdges, error) {
+	var edges GEdges
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, e := range gEdgeNames {
+			if e.name == name {
+				edges |= e.edge
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown goroutine edge %q", name)
+		}
+	}
+	return edges, nil
+}
+
+func (edges GEdges) String() string {
+	var names []string
+	for _, e := range gEdgeNames {
+		if edges&e.edge != 0 {
+			names = append(names, e.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// RelatedGoroutines finds a set of goroutines related to goroutine goid:
+// the goroutines that unblocked it, and those that unblocked them.
 func RelatedGoroutines(events []*Event, goid uint64) map[uint64]bool {
-	// BFS of depth 2 over "unblock" edges
-	// (what goroutines unblock goroutine goid?).
+	return RelatedGoroutinesEdges(events, goid, 2, EdgeUnblockedBy)
+}
+
+// RelatedGoroutinesEdges finds the goroutines reachable from goroutine
+// goid over at most depth edges of the given kinds.
+func RelatedGoroutinesEdges(events []*Event, goid uint64, depth int, edges GEdges) map[uint64]bool {
+	// Collect the edges of the requested kinds.
+	adj := make(map[uint64][]uint64)
+	link := func(from, to uint64) {
+		adj[from] = append(adj[from], to)
+	}
+	for _, ev := range events {
+		var parent, child GEdges
+		switch ev.Type {
+		case EvGoCreate:
+			parent, child = EdgeCreates, EdgeCreatedBy
+		case EvGoUnblock:
+			parent, child = EdgeUnblocks, EdgeUnblockedBy
+		default:
+			continue
+		}
+		g, _ := ev.TargetG()
+		if edges&parent != 0 {
+			link(ev.G, g)
+		}
+		if edges&child != 0 {
+			link(g, ev.G)
+		}
+	}
+
+	// BFS of the given depth over the edges.
 	gmap := make(map[uint64]bool)
 	gmap[goid] = true
-	for i := 0; i < 2; i++ {
-		gmap1 := make(map[uint64]bool)
-		for g := range gmap {
-			gmap1[g] = true
-		}
-		for _, ev := range events {
-			if ev.Type == EvGoUnblock && gmap[ev.Args[0]] {
-				gmap1[ev.G] = true
+	frontier := []uint64{goid}
+	for i := 0; i < depth && len(frontier) > 0; i++ {
+		var next []uint64
+		for _, g := range frontier {
+			for _, g1 := range adj[g] {
+				if g1 == 0 {
+					// Not a goroutine, like the creator of the
+					// goroutines that existed when tracing started.
+					continue
+				}
+				if !gmap[g1] {
+					gmap[g1] = true
+					next = append(next, g1)
+				}
 			}
 		}
-		gmap = gmap1
+		frontier = next
 	}
 	gmap[0] = true // for GC events
 	return gmap
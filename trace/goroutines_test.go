@@ -0,0 +1,154 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGoroutineStatsSum(t *testing.T) {
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		_, events, err := parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		for _, g := range GoroutineStats(events) {
+			sum := g.ExecTime + g.SchedWaitTime + g.IOTime +
+				g.BlockSendTime + g.BlockRecvTime + g.BlockSelectTime + g.BlockSyncTime + g.BlockCondTime +
+				g.BlockGCTime + g.BlockUnknownTime + g.SleepTime + g.SyscallTime
+			if sum != g.TotalTime {
+				t.Errorf("%v: times of g %v add up to %v, want total %v: %v", f, g.ID, sum, g.TotalTime, g)
+			}
+			if block := g.BlockSendTime + g.BlockRecvTime + g.BlockSelectTime + g.BlockSyncTime + g.BlockCondTime; block != g.BlockTime {
+				t.Errorf("%v: block times of g %v add up to %v, want %v", f, g.ID, block, g.BlockTime)
+			}
+		}
+	}
+
+	// A stopped goroutine is blocked until the end of the trace.
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoStop, 10, 0)
+	w.emit(EvGoCreate, 100, 2, 0, 0)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	g := GoroutineStats(events)[1]
+	if end := events[len(events)-1].Ts; g.EndTime != end || g.TotalTime != end-g.CreationTime {
+		t.Errorf("stopped g ends at %v after %v, want the end of the trace at %v", g.EndTime, g.TotalTime, end)
+	}
+	if g.ExecTime+g.SchedWaitTime+g.BlockUnknownTime != g.TotalTime || g.BlockUnknownTime == 0 {
+		t.Errorf("stopped g is not blocked until the end of the trace: %v", g)
+	}
+}
+
+func TestGoroutineStatsWindow(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/stress_1_11_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	_, events, err := parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	// The statistics of two adjacent windows add up to those of the trace.
+	first, last := events[0].Ts, events[len(events)-1].Ts
+	mid := first + (last-first)/2
+	all := GoroutineStats(events)
+	w1 := GoroutineStatsWindow(events, first, mid)
+	w2 := GoroutineStatsWindow(events, mid, last)
+	times := func(g *GDesc) []int64 {
+		if g == nil {
+			return make([]int64, 6)
+		}
+		return []int64{g.TotalTime, g.ExecTime, g.SchedWaitTime, g.BlockTime, g.SyscallTime, g.GCTime}
+	}
+	for id, g := range all {
+		want, t1, t2 := times(g), times(w1[id]), times(w2[id])
+		for i := range want {
+			if t1[i]+t2[i] != want[i] {
+				t.Errorf("g %v: times %v and %v of the windows do not add up to %v", id, t1, t2, want)
+				break
+			}
+		}
+		if w1[id] == nil && g.CreationTime <= mid {
+			t.Errorf("g %v created at %v is missing in the first window", id, g.CreationTime)
+		}
+	}
+	for id, g := range w1 {
+		if g.TotalTime > mid-first {
+			t.Errorf("g %v: total time %v exceeds the window", id, g.TotalTime)
+		}
+	}
+}
+
+func TestRelatedGoroutinesEdges(t *testing.T) {
+	// g 1 creates g 2 and g 3, g 2 unblocks g 3, and g 3 creates g 4.
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoCreate, 1, 2, 0, 0)
+	w.emit(EvGoCreate, 1, 3, 0, 0)
+	w.emit(EvGoEnd, 1)
+	w.emit(EvGoStart, 1, 3, 1)
+	w.emit(EvGoBlockRecv, 1, 0)
+	w.emit(EvGoStart, 1, 2, 1)
+	w.emit(EvGoUnblock, 1, 3, 2, 0)
+	w.emit(EvGoEnd, 1)
+	w.emit(EvGoStart, 1, 3, 3)
+	w.emit(EvGoCreate, 1, 4, 0, 0)
+	w.emit(EvGoEnd, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	for _, tc := range []struct {
+		goid  uint64
+		depth int
+		edges string
+		want  []uint64
+	}{
+		{3, 2, "unblocked-by", []uint64{0, 2, 3}},
+		{2, 1, "unblocks", []uint64{0, 2, 3}},
+		{3, 1, "created-by", []uint64{0, 1, 3}},
+		{1, 1, "creates", []uint64{0, 1, 2, 3}},
+		{1, 2, "creates", []uint64{0, 1, 2, 3, 4}},
+		{4, 3, "created-by,unblocked-by", []uint64{0, 1, 2, 3, 4}},
+		{4, 0, "created-by", []uint64{0, 4}},
+	} {
+		edges, err := ParseGEdges(tc.edges)
+		if err != nil {
+			t.Fatalf("failed to parse edges %q: %v", tc.edges, err)
+		}
+		if edges.String() != tc.edges {
+			t.Errorf("edges %q are formatted as %q", tc.edges, edges)
+		}
+		got := RelatedGoroutinesEdges(events, tc.goid, tc.depth, edges)
+		want := make(map[uint64]bool)
+		for _, g := range tc.want {
+			want[g] = true
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("g %v, depth %v, edges %v: got goroutines %v, want %v", tc.goid, tc.depth, tc.edges, got, want)
+		}
+	}
+	if _, err := ParseGEdges("creates,spawns"); err == nil {
+		t.Errorf("no error for unknown edge kind")
+	}
+}
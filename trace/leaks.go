@@ -0,0 +1,225 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A LeakedG is a goroutine found by Leaks.
+type LeakedG struct {
+	G            uint64
+	CreationTime int64 // time the goroutine was created, or the trace started
+	BlockTime    int64 // time the goroutine blocked
+	Blocked      int64 // how long the goroutine stayed blocked
+	Age          int64 // age of the goroutine at the end of the trace or when it was unblocked
+	AtEnd        bool  // whether the goroutine was still blocked at the end of the trace
+}
+
+// A LeakGroup is a set of goroutines that block for the same reason at
+// the same stack and were created at the same site.
+type LeakGroup struct {
+	Reason     string   // why the goroutines block, like "chan receive"
+	Stack      []*Frame // stack where the goroutines block
+	Creation   []*Frame // stack of the go statement, empty if unknown
+	Goroutines []*LeakedG
+}
+
+// MaxBlocked returns the longest time a goroutine of the group stayed blocked.
+func (lg *LeakGroup) MaxBlocked() int64 {
+	var max int64
+	for _, g := range lg.Goroutines {
+		if g.Blocked > max {
+			max = g.Blocked
+		}
+	}
+	return max
+}
+
+// A LeakReport is the result of Leaks.
+type LeakReport struct {
+	Threshold int64 // minimum blocked time of the goroutines that did not leak, 0 if none
+	AtEnd     int   // number of goroutines still blocked at the end of the trace
+	Long      int   // number of other goroutines that were blocked longer than Threshold
+	Groups    []*LeakGroup
+}
+
+// Leaks finds the goroutines that are still blocked at the end of the
+// trace and, if threshold is positive, the goroutines that stayed blocked
+// for at least threshold nanoseconds before they were unblocked. The
+// goroutines are grouped by blocking reason, blocking stack and creation
+// site, with the largest groups first. Goroutines that sleep or are in a
+// syscall are not considered blocked. The events must be post-processed
+// by Parse.
+func Leaks(events []*Event, threshold int64) *LeakReport {
+	r := &LeakReport{Threshold: threshold}
+	groups := make(map[string]*LeakGroup)
+	for g, ivs := range GoroutineStates(events) {
+		var creation []*Frame
+		if ivs[0].Start.Type == EvGoCreate {
+			creation = ivs[0].Start.Stk
+		}
+		var found *GInterval
+		for _, iv := range ivs {
+			if iv.State != GBlocked {
+				continue
+			}
+			if iv.End == nil || (threshold > 0 && iv.Duration() >= threshold && (found == nil || iv.Duration() > found.Duration())) {
+				found = iv
+			}
+		}
+		if found == nil {
+			continue
+		}
+		lg := &LeakedG{
+			G:            g,
+			CreationTime: ivs[0].StartTime,
+			BlockTime:    found.StartTime,
+			Blocked:      found.Duration(),
+			Age:          found.EndTime - ivs[0].StartTime,
+			AtEnd:        found.End == nil,
+		}
+		if lg.AtEnd {
+			r.AtEnd++
+		} else {
+			r.Long++
+		}
+		key := found.Reason + "\x00" + stackKey(found.Stack) + "\x00" + stackKey(creation)
+		grp := groups[key]
+		if grp == nil {
+			grp = &LeakGroup{Reason: found.Reason, Stack: found.Stack, Creation: creation}
+			groups[key] = grp
+			r.Groups = append(r.Groups, grp)
+		}
+		grp.Goroutines = append(grp.Goroutines, lg)
+	}
+	for _, grp := range r.Groups {
+		sort.Slice(grp.Goroutines, func(i, j int) bool {
+			return grp.Goroutines[i].G < grp.Goroutines[j].G
+		})
+	}
+	sort.Slice(r.Groups, func(i, j int) bool {
+		a, b := r.Groups[i], r.Groups[j]
+		if len(a.Goroutines) != len(b.Goroutines) {
+			return len(a.Goroutines) > len(b.Goroutines)
+		}
+		if a.MaxBlocked() != b.MaxBlocked() {
+			return a.MaxBlocked() > b.MaxBlocked()
+		}
+		return a.Goroutines[0].G < b.Goroutines[0].G
+	})
+	return r
+}
+
+// stackKey returns a string that identifies the stack stk.
+func stackKey(stk []*Frame) string {
+	var b strings.Builder
+	for _, f := range stk {
+		fmt.Fprintf(&b, "%x,", f.PC)
+	}
+	return b.String()
+}
+
+// WriteText writes the report as text to w.
+func (r *LeakReport) WriteText(w io.Writer) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d goroutines blocked at the end of the trace", r.AtEnd)
+	if r.Threshold > 0 {
+		fmt.Fprintf(&buf, ", %d blocked for %v or longer", r.Long, dur(r.Threshold))
+	}
+	fmt.Fprintf(&buf, "\n")
+	for _, grp := range r.Groups {
+		var ids []string
+		minAge, maxAge := grp.Goroutines[0].Age, grp.Goroutines[0].Age
+		for _, g := range grp.Goroutines {
+			ids = append(ids, fmt.Sprint(g.G))
+			if g.Age < minAge {
+				minAge = g.Age
+			}
+			if g.Age > maxAge {
+				maxAge = g.Age
+			}
+		}
+		reason := grp.Reason
+		if reason == "" {
+			reason = "unknown reason"
+		}
+		fmt.Fprintf(&buf, "\n%d goroutines blocked on %s for up to %v, aged %v to %v\n",
+			len(grp.Goroutines), reason, dur(grp.MaxBlocked()), dur(minAge), dur(maxAge))
+		fmt.Fprintf(&buf, "  goroutines: %s\n", strings.Join(ids, ", "))
+		writeStack(&buf, "blocked at", grp.Stack)
+		writeStack(&buf, "created at", grp.Creation)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeStack(w io.Writer, title string, stk []*Frame) {
+	if len(stk) == 0 {
+		fmt.Fprintf(w, "  %s: unknown\n", title)
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	for _, f := range stk {
+		fmt.Fprintf(w, "    %s\n      %s:%d\n", f.Fn, f.File, f.Line)
+	}
+}
+
+// WriteJSON writes the report as JSON to w.
+func (r *LeakReport) WriteJSON(w io.Writer) error {
+	type frame struct {
+		PC   uint64 `json:"pc"`
+		Fn   string `json:"fn"`
+		File string `json:"file"`
+		Line int    `json:"line"`
+	}
+	type goroutine struct {
+		G            uint64 `json:"g"`
+		CreationTime int64  `json:"creationTime"`
+		BlockTime    int64  `json:"blockTime"`
+		Blocked      int64  `json:"blockedNs"`
+		Age          int64  `json:"ageNs"`
+		AtEnd        bool   `json:"atEnd"`
+	}
+	type group struct {
+		Reason     string      `json:"reason"`
+		Count      int         `json:"count"`
+		MaxBlocked int64       `json:"maxBlockedNs"`
+		Stack      []frame     `json:"stack"`
+		Creation   []frame     `json:"creation"`
+		Goroutines []goroutine `json:"goroutines"`
+	}
+	type report struct {
+		Threshold int64   `json:"thresholdNs"`
+		AtEnd     int     `json:"atEnd"`
+		Long      int     `json:"long"`
+		Groups    []group `json:"groups"`
+	}
+	frames := func(stk []*Frame) []frame {
+		fs := []frame{}
+		for _, f := range stk {
+			fs = append(fs, frame{f.PC, f.Fn, f.File, f.Line})
+		}
+		return fs
+	}
+	jr := report{Threshold: r.Threshold, AtEnd: r.AtEnd, Long: r.Long, Groups: []group{}}
+	for _, grp := range r.Groups {
+		jg := group{
+			Reason:     grp.Reason,
+			Count:      len(grp.Goroutines),
+			MaxBlocked: grp.MaxBlocked(),
+			Stack:      frames(grp.Stack),
+			Creation:   frames(grp.Creation),
+		}
+		for _, g := range grp.Goroutines {
+			jg.Goroutines = append(jg.Goroutines, goroutine(*g))
+		}
+		jr.Groups = append(jr.Groups, jg)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(jr)
+}
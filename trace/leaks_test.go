@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLeaks(t *testing.T) {
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	for g := uint64(1); g <= 4; g++ {
+		w.emit(EvGoCreate, 1, g, 0, 0)
+	}
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoBlockRecv, 1, 0)
+	w.emit(EvGoStart, 1, 2, 1)
+	w.emit(EvGoBlockRecv, 1, 0)
+	w.emit(EvGoStart, 1, 3, 1)
+	w.emit(EvGoBlockSync, 1, 0)
+	w.emit(EvGoStart, 1, 4, 1)
+	w.emit(EvGoUnblock, 1000, 3, 2, 0) // g 3 is blocked for 1000ns
+	w.emit(EvGoEnd, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+
+	r := Leaks(events, 0)
+	if r.AtEnd != 2 || r.Long != 0 || len(r.Groups) != 1 {
+		t.Fatalf("got %v blocked at end, %v long in %v groups, want 2, 0 in 1 group", r.AtEnd, r.Long, len(r.Groups))
+	}
+	if grp := r.Groups[0]; grp.Reason != "chan receive" || len(grp.Goroutines) != 2 || grp.Goroutines[0].G != 1 || !grp.Goroutines[0].AtEnd {
+		t.Errorf("bad group %+v", grp)
+	}
+
+	r = Leaks(events, 500)
+	if r.AtEnd != 2 || r.Long != 1 || len(r.Groups) != 2 {
+		t.Fatalf("got %v blocked at end, %v long in %v groups, want 2, 1 in 2 groups", r.AtEnd, r.Long, len(r.Groups))
+	}
+	if grp := r.Groups[1]; grp.Reason != "sync" || len(grp.Goroutines) != 1 || grp.Goroutines[0].G != 3 || grp.MaxBlocked() < 1000 {
+		t.Errorf("bad group %+v", grp)
+	}
+
+	var text bytes.Buffer
+	if err := r.WriteText(&text); err != nil {
+		t.Fatalf("failed to write text report: %v", err)
+	}
+	if !strings.Contains(text.String(), "2 goroutines blocked on chan receive") {
+		t.Errorf("text report does not contain the leaked goroutines:\n%s", text.Bytes())
+	}
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatalf("failed to write JSON report: %v", err)
+	}
+	var jr struct {
+		AtEnd  int
+		Groups []struct {
+			Reason     string
+			Count      int
+			Goroutines []struct{ G uint64 }
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &jr); err != nil {
+		t.Fatalf("failed to decode JSON report: %v\n%s", err, buf.Bytes())
+	}
+	if jr.AtEnd != 2 || len(jr.Groups) != 2 || jr.Groups[0].Count != 2 || jr.Groups[1].Goroutines[0].G != 3 {
+		t.Errorf("bad JSON report:\n%s", buf.Bytes())
+	}
+}
@@ -0,0 +1,126 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Limits bounds the resources used to parse a trace, so that a malformed
+// or hostile trace cannot exhaust the memory of the process. A zero
+// field means no limit. Parsing fails with a ParseError of KindLimit
+// when a limit is exceeded; ParseLenient keeps the events decoded
+// before.
+type Limits struct {
+	// MaxEvents is the maximum number of events of the trace.
+	MaxEvents int
+	// MaxStackDepth is the maximum number of frames of a stack trace.
+	MaxStackDepth int
+	// MaxStrings is the maximum number of strings of the trace. Traces
+	// produced by Go 1.22 and later repeat the strings of each
+	// generation, and these count again.
+	MaxStrings int
+	// MaxMemory is the approximate maximum number of bytes of memory
+	// taken by the trace, decompressed, and the events, stack traces and
+	// strings decoded from it.
+	MaxMemory int64
+}
+
+// Approximate memory taken by the decoded parts of a trace.
+const (
+	eventMemory  = 256 // an event with its raw form and arguments
+	frameMemory  = 64  // a frame of a stack trace
+	stringMemory = 32  // a string, in addition to its bytes
+)
+
+// limiter enforces Limits while a trace is parsed. A nil limiter
+// enforces nothing.
+type limiter struct {
+	Limits
+	events  int
+	strings int
+	mem     int64
+}
+
+func newLimiter(l Limits) *limiter {
+	if l == (Limits{}) {
+		return nil
+	}
+	return &limiter{Limits: l}
+}
+
+// limitErrorf returns a KindLimit error for the data at offset off of an
+// event of type typ.
+func limitErrorf(off int, typ byte, format string, args ...interface{}) *ParseError {
+	return &ParseError{Kind: KindLimit, Off: off, Type: typ, P: -1, Msg: fmt.Sprintf(format, args...)}
+}
+
+// event accounts for an event of type typ at offset off.
+func (l *limiter) event(off int, typ byte) error {
+	if l == nil {
+		return nil
+	}
+	l.events++
+	if l.MaxEvents > 0 && l.events > l.MaxEvents {
+		return limitErrorf(off, typ, "trace has more than %v events", l.MaxEvents)
+	}
+	return l.alloc(off, typ, eventMemory)
+}
+
+// str accounts for a string of n bytes at offset off.
+func (l *limiter) str(off int, n uint64) error {
+	if l == nil {
+		return nil
+	}
+	l.strings++
+	if l.MaxStrings > 0 && l.strings > l.MaxStrings {
+		return limitErrorf(off, EvString, "trace has more than %v strings", l.MaxStrings)
+	}
+	return l.alloc(off, EvString, stringMemory+int64(n))
+}
+
+// stack accounts for a stack trace of depth frames at offset off.
+func (l *limiter) stack(off int, depth uint64) error {
+	if l == nil {
+		return nil
+	}
+	if l.MaxStackDepth > 0 && depth > uint64(l.MaxStackDepth) {
+		return limitErrorf(off, EvStack, "stack trace has %v frames, more than %v", depth, l.MaxStackDepth)
+	}
+	if depth > 1000 {
+		// Deeper stack traces are rejected as malformed.
+		depth = 1000
+	}
+	return l.alloc(off, EvStack, frameMemory*int64(depth))
+}
+
+// alloc accounts for n bytes of memory taken for an event of type typ
+// at offset off.
+func (l *limiter) alloc(off int, typ byte, n int64) error {
+	if l == nil {
+		return nil
+	}
+	l.mem += n
+	if l.MaxMemory > 0 && l.mem > l.MaxMemory {
+		return limitErrorf(off, typ, "trace takes more than %v bytes of memory", l.MaxMemory)
+	}
+	return nil
+}
+
+// readLimited reads r to the end, but at most max bytes if max is
+// positive.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if max <= 0 {
+		_, err := buf.ReadFrom(r)
+		return buf.Bytes(), err
+	}
+	n, err := buf.ReadFrom(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > max {
+		return nil, limitErrorf(-1, EvNone, "trace takes more than %v bytes of memory", max)
+	}
+	return buf.Bytes(), nil
+}
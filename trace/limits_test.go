@@ -0,0 +1,121 @@
+package trace
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	for _, file := range []string{"testdata/stress_1_11_good", "testdata/annotations_1_26_good"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		for _, tc := range []struct {
+			name   string
+			limits Limits
+			fail   bool
+		}{
+			{"none", Limits{}, false},
+			{"generous", Limits{MaxEvents: 1e6, MaxStackDepth: 128, MaxStrings: 1e4, MaxMemory: 1 << 30}, false},
+			{"events", Limits{MaxEvents: 10}, true},
+			{"stack depth", Limits{MaxStackDepth: 1}, true},
+			{"strings", Limits{MaxStrings: 1}, true},
+			{"memory", Limits{MaxMemory: int64(len(data)) + 1000}, true},
+		} {
+			_, err := (&ParseOptions{Quiet: true, Limits: tc.limits}).ParseBytes(data)
+			if !tc.fail {
+				if err != nil {
+					t.Errorf("%v: %v: failed to parse trace: %v", file, tc.name, err)
+				}
+				continue
+			}
+			if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+				t.Errorf("%v: %v: got error %v, want a limit error", file, tc.name, err)
+			}
+		}
+	}
+}
+
+func TestLimitsDecompressed(t *testing.T) {
+	// A small compressed trace that decompresses to much more memory.
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("go 1.11 trace\x00\x00\x00"))
+	zw.Write(make([]byte, 10<<20))
+	zw.Close()
+	_, err := (&ParseOptions{Quiet: true, Limits: Limits{MaxMemory: 1 << 20}}).Parse(bytes.NewReader(buf.Bytes()))
+	if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+		t.Errorf("got error %v, want a limit error", err)
+	}
+	_, _, err = (&ParseOptions{Quiet: true, Limits: Limits{MaxMemory: 1 << 20}}).ParseLenient(bytes.NewReader(buf.Bytes()))
+	if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+		t.Errorf("ParseLenient: got error %v, want a limit error", err)
+	}
+}
+
+func TestLimitsEventLength(t *testing.T) {
+	// A stack event whose length covers the rest of a large trace.
+	const n = 20 << 20
+	w := newWriter()
+	w.emit(EvBatch, 0, 0)
+	w.WriteByte(EvStack | 3<<6)
+	w.Write(appendVarint(nil, n))
+	w.Write(make([]byte, n))
+	var ms0, ms1 runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms0)
+	_, err := (&ParseOptions{Quiet: true, Limits: Limits{MaxMemory: 2 * n}}).ParseBytes(w.Bytes())
+	runtime.ReadMemStats(&ms1)
+	if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+		t.Errorf("got error %v, want a limit error", err)
+	}
+	if alloc := ms1.TotalAlloc - ms0.TotalAlloc; alloc > 2*n {
+		t.Errorf("allocated %v bytes, more than the limit of %v", alloc, 2*n)
+	}
+	_, err = (&ParseOptions{Quiet: true}).ParseBytes(w.Bytes())
+	if pe, ok := err.(*ParseError); !ok || pe.Kind != KindWireFormat {
+		t.Errorf("without limits: got error %v, want a wire format error", err)
+	}
+}
+
+func TestLimitsUserLog(t *testing.T) {
+	// The value of a user log message counts as a string.
+	w := newWriterVersion("1.11")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emitString(1, "key")
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvUserLog, 1, 0, 1, 0)
+	w.Write(appendString(nil, "value"))
+	w.emit(EvGoEnd, 1)
+	data := w.Bytes()
+	if _, err := (&ParseOptions{Quiet: true, Limits: Limits{MaxStrings: 2}}).ParseBytes(data); err != nil {
+		t.Fatalf("failed to parse trace: %v", err)
+	}
+	_, err := (&ParseOptions{Quiet: true, Limits: Limits{MaxStrings: 1}}).ParseBytes(data)
+	if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+		t.Errorf("got error %v, want a limit error", err)
+	}
+}
+
+func TestLimitsReader(t *testing.T) {
+	for _, file := range []string{"testdata/stress_1_11_good", "testdata/annotations_1_26_good"} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		opts := &ParseOptions{Quiet: true, Limits: Limits{MaxEvents: 10}}
+		r, err := opts.NewReader(bytes.NewReader(data))
+		for err == nil {
+			_, err = r.Next()
+		}
+		if pe, ok := err.(*ParseError); !ok || pe.Kind != KindLimit {
+			t.Errorf("%v: got error %v, want a limit error", file, err)
+		}
+	}
+}
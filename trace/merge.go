@@ -0,0 +1,136 @@
+package trace
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A Source is the trace of one process to be merged with Merge.
+type Source struct {
+	Name   string   // name of the process
+	Events []*Event // events as returned by Parse
+
+	// Offset is added to the timestamps of the events, after the
+	// alignment on Marker.
+	Offset int64
+
+	// Marker, if not empty, aligns the clock of the process on a marker
+	// event: the first user log whose message or category is Marker, or
+	// the first user task or region start named Marker. The marker
+	// events of all sources with a Marker happen at the same time, that
+	// of the marker event of the first of them.
+	Marker string
+}
+
+// Merge merges the traces of several processes onto one timeline.
+// The events of the i-th source have their Process field set to i,
+// and the timestamps of all events start at 0.
+//
+// Goroutine, task and stack ids of the sources after the first are
+// shifted so that they are unique in the merged trace, so the result
+// can be analyzed like the events of one trace; Ps are not shifted.
+// The events are copies; the events of the sources are not modified.
+func Merge(sources []Source) ([]*Event, error) {
+	var events []*Event
+	var ref int64 // time of the marker of the first source with a marker
+	refSet := false
+	var gOff, taskOff, stkOff uint64
+	for i, src := range sources {
+		shift := src.Offset
+		if src.Marker != "" {
+			mev := findMarker(src.Events, src.Marker)
+			if mev == nil {
+				return nil, fmt.Errorf("marker %q not found in the trace of %v", src.Marker, src.Name)
+			}
+			if !refSet {
+				ref, refSet = mev.Ts, true
+			}
+			shift += ref - mev.Ts
+		}
+
+		var maxG, maxTask, maxStk uint64
+		copies := make(map[*Event]*Event, len(src.Events))
+		for _, ev := range src.Events {
+			e := *ev
+			e.Process = i
+			e.Ts += shift
+			desc := EventDescriptions[ev.Type]
+			maxG = max64(maxG, e.G)
+			e.G = shiftID(e.G, gOff)
+			for j, name := range desc.Args {
+				if j >= len(e.Args) {
+					break
+				}
+				switch {
+				case name == "g":
+					maxG = max64(maxG, e.Args[j])
+					e.Args[j] = shiftID(e.Args[j], gOff)
+				case name == "taskid" || name == "pid" && ev.Type == EvUserTaskCreate ||
+					name == "id" && ev.Type == EvUserLog:
+					maxTask = max64(maxTask, e.Args[j])
+					e.Args[j] = shiftID(e.Args[j], taskOff)
+				case name == "stack" && ev.Type == EvGoCreate:
+					maxStk = max64(maxStk, e.Args[j])
+					e.Args[j] = shiftID(e.Args[j], stkOff)
+				}
+			}
+			maxStk = max64(maxStk, e.StkID)
+			e.StkID = shiftID(e.StkID, stkOff)
+			copies[ev] = &e
+			events = append(events, &e)
+		}
+		for _, ev := range src.Events {
+			if ev.Link != nil {
+				copies[ev].Link = copies[ev.Link]
+			}
+		}
+		gOff += maxG
+		taskOff += maxTask
+		stkOff += maxStk
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Ts < events[j].Ts })
+	if len(events) > 0 {
+		min := events[0].Ts
+		for _, ev := range events {
+			ev.Ts -= min
+		}
+	}
+	return events, nil
+}
+
+// findMarker returns the first marker event named marker, or nil.
+func findMarker(events []*Event, marker string) *Event {
+	for _, ev := range events {
+		switch ev.Type {
+		case EvUserLog:
+			if ev.SArgs[0] == marker || ev.SArgs[1] == marker {
+				return ev
+			}
+		case EvUserTaskCreate:
+			if ev.SArgs[0] == marker {
+				return ev
+			}
+		case EvUserRegion:
+			if ev.Args[1] == 0 && ev.SArgs[0] == marker {
+				return ev
+			}
+		}
+	}
+	return nil
+}
+
+// shiftID shifts the id by off. The id 0, which means none, is not shifted.
+func shiftID(id, off uint64) uint64 {
+	if id == 0 {
+		return 0
+	}
+	return id + off
+}
+
+func max64(a, b uint64) uint64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,53 @@
+package trace
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	events, err := ParseFile("testdata/annotations_1_26_good", nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	merged, err := Merge([]Source{
+		{Name: "client", Events: events, Marker: "work"},
+		{Name: "server", Events: events, Marker: "msg", Offset: 1000},
+	})
+	if err != nil {
+		t.Fatalf("failed to merge: %v", err)
+	}
+	if len(merged) != 2*len(events) {
+		t.Fatalf("got %v events, want %v", len(merged), 2*len(events))
+	}
+
+	gs := make(map[uint64]int) // process of each goroutine
+	var markers [2]*Event
+	for i, ev := range merged {
+		if i > 0 && ev.Ts < merged[i-1].Ts {
+			t.Fatalf("event %v is before the previous event %v", ev, merged[i-1])
+		}
+		if ev.G != 0 {
+			if p, ok := gs[ev.G]; ok && p != ev.Process {
+				t.Errorf("g %v is in processes %v and %v", ev.G, p, ev.Process)
+			}
+			gs[ev.G] = ev.Process
+		}
+		if ev.Link != nil && ev.Link.Process != ev.Process {
+			t.Errorf("event %v of process %v links to process %v", ev, ev.Process, ev.Link.Process)
+		}
+		if markers[ev.Process] == nil && findMarker([]*Event{ev}, []string{"work", "msg"}[ev.Process]) != nil {
+			markers[ev.Process] = ev
+		}
+	}
+	if merged[0].Ts != 0 {
+		t.Errorf("merged trace starts at %v, want 0", merged[0].Ts)
+	}
+	if d := markers[1].Ts - markers[0].Ts; d != 1000 {
+		t.Errorf("markers %v and %v are %v apart, want 1000", markers[0], markers[1], d)
+	}
+	if n, want := len(UserAnnotations(merged).Tasks), 2*len(UserAnnotations(events).Tasks); n != want {
+		t.Errorf("got %v tasks, want %v", n, want)
+	}
+
+	if _, err := Merge([]Source{{Name: "client", Events: events, Marker: "none"}}); err == nil {
+		t.Errorf("merge succeeded with a missing marker")
+	}
+}
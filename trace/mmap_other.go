@@ -0,0 +1,18 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package trace
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// mapFile reads the named file into memory.
+func mapFile(name string) ([]byte, func() error, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open trace file: %v", err)
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,210 @@
+package trace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+)
+
+// ParseOptions controls parsing of a trace. The zero value parses
+// like Parse with a nil Symbolizer.
+type ParseOptions struct {
+	// Symbolizer symbolizes the stack traces of traces produced by
+	// Go 1.6 and earlier, which do not contain symbol information.
+	Symbolizer Symbolizer
+
+	// Progress, if not nil, is called at the start of each phase of
+	// parsing and periodically while a phase runs.
+	Progress func(Progress)
+
+	// Logger receives a message at the start of each phase of parsing.
+	// If it is nil, the messages go to the standard logger, unless
+	// Quiet is set.
+	Logger *log.Logger
+	Quiet  bool
+
+	// KeepFutile keeps futile wakeups in the trace instead of removing
+	// them. The events of a futile wakeup sequence (the unblock, the
+	// starts, the FutileWakeup and the block) have their Futile field set.
+	// It has no effect on traces produced by Go 1.22 and later,
+	// which do not record futile wakeups.
+	KeepFutile bool
+
+	// RepairClockSkew accepts traces of Go 1.5 to 1.21 whose timestamps
+	// contradict the order of their events, which happens when the CPU
+	// clocks are not synchronized, instead of failing with ErrTimeOrder.
+	// The events keep their consistent order and their timestamps are
+	// repaired: for Go 1.7 and later, the clock of each P is shifted by
+	// an offset estimated from the events that depend on events of other
+	// Ps, and timestamps that still go backwards are raised to the
+	// preceding one. Events whose timestamps were changed have their
+	// ClockAdjusted field set.
+	RepairClockSkew bool
+
+	// Limits bounds the resources used to parse untrusted traces.
+	Limits Limits
+}
+
+// Progress describes how far parsing of a trace has got.
+type Progress struct {
+	Phase  string // phase of parsing, like "readTrace" or "postProcessTrace"
+	Bytes  int    // number of bytes of the trace decoded so far
+	Total  int    // size of the trace in bytes, 0 if it is not known
+	Events int    // number of events processed in the phase so far
+}
+
+// progressInterval is the number of events between progress reports
+// within a phase.
+const progressInterval = 1 << 16
+
+// Parse is like the package function Parse but with the options.
+// Traces produced by Go 1.22 and later are decoded as they are read from
+// r, one generation at a time. Earlier traces are read into memory first.
+func (opts *ParseOptions) Parse(r io.Reader) ([]*Event, error) {
+	dr, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	br, ok := dr.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(dr)
+	}
+	if header, _ := br.Peek(16); len(header) == 16 {
+		if ver, err := parseHeader(header); err == nil && ver >= 1022 {
+			br.Discard(16)
+			_, events, err := parseGenerations(ver, br, 0, opts, nil, newTracker(opts, 0))
+			return events, err
+		}
+	}
+	data, err := opts.readAll(br)
+	if err != nil {
+		return nil, err
+	}
+	return opts.ParseBytes(data)
+}
+
+// ParseBytes is like the package function ParseBytes but with the options.
+func (opts *ParseOptions) ParseBytes(data []byte) ([]*Event, error) {
+	data, err := decompress(data, opts.Limits.MaxMemory)
+	if err != nil {
+		return nil, err
+	}
+	ver, events, err := parseBytes(data, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ver < 1007 && opts.Symbolizer == nil {
+		return nil, fmt.Errorf("for traces produced by go 1.6 or below, the binary argument must be provided")
+	}
+	return events, nil
+}
+
+// ParseFile is like the package function ParseFile but with the options.
+func (opts *ParseOptions) ParseFile(name string) ([]*Event, error) {
+	if isURL(name) {
+		rc, err := Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return opts.Parse(rc)
+	}
+	data, unmap, err := mapFile(name)
+	if err != nil {
+		return nil, err
+	}
+	defer unmap()
+	return opts.ParseBytes(data)
+}
+
+// readAll reads the trace in r and decompresses it, within the memory
+// limit.
+func (opts *ParseOptions) readAll(r io.Reader) ([]byte, error) {
+	data, err := readLimited(r, opts.Limits.MaxMemory)
+	if err != nil {
+		if _, ok := err.(*ParseError); ok {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read trace: %v", err)
+	}
+	return decompress(data, opts.Limits.MaxMemory)
+}
+
+// tracker reports the progress of parsing as requested by ParseOptions.
+// A nil tracker reports nothing.
+type tracker struct {
+	opts   *ParseOptions
+	phase  string
+	bytes  int
+	total  int
+	events int
+	lim    *limiter
+}
+
+func newTracker(opts *ParseOptions, total int) *tracker {
+	if opts == nil {
+		opts = new(ParseOptions)
+	}
+	return &tracker{opts: opts, total: total, lim: newLimiter(opts.Limits)}
+}
+
+// limiter returns the limiter of the parse, or nil if t is nil.
+func (t *tracker) limiter() *limiter {
+	if t == nil {
+		return nil
+	}
+	return t.lim
+}
+
+// start starts a new phase of parsing.
+func (t *tracker) start(phase string) {
+	if t == nil {
+		return
+	}
+	t.phase, t.events = phase, 0
+	t.logf("%v", phase)
+	t.report()
+}
+
+// event records that one more event was processed in the phase,
+// and that decoding got to offset off in the trace if off is not negative.
+func (t *tracker) event(off int) {
+	if t == nil {
+		return
+	}
+	t.events++
+	if off >= 0 {
+		t.bytes = off
+	}
+	if t.events%progressInterval == 0 {
+		t.report()
+	}
+}
+
+// done records that the trace was decoded up to offset off.
+func (t *tracker) done(off int) {
+	if t == nil {
+		return
+	}
+	t.bytes = off
+	t.report()
+}
+
+func (t *tracker) report() {
+	if t.opts.Progress != nil {
+		t.opts.Progress(Progress{Phase: t.phase, Bytes: t.bytes, Total: t.total, Events: t.events})
+	}
+}
+
+func (t *tracker) logf(format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	switch {
+	case t.opts.Logger != nil:
+		t.opts.Logger.Printf(format, args...)
+	case !t.opts.Quiet:
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,150 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestParseOptionsProgress(t *testing.T) {
+	for _, name := range []string{"testdata/stress_1_11_good", "testdata/annotations_1_26_good"} {
+		var phases []string
+		var last Progress
+		opts := &ParseOptions{
+			Quiet: true,
+			Progress: func(p Progress) {
+				if len(phases) == 0 || phases[len(phases)-1] != p.Phase {
+					phases = append(phases, p.Phase)
+				}
+				if p.Bytes < last.Bytes || p.Bytes > p.Total {
+					t.Errorf("%v: bad progress %+v after %+v", name, p, last)
+				}
+				last = p
+			},
+		}
+		if _, err := opts.ParseFile(name); err != nil {
+			t.Fatalf("failed to parse %v: %v", name, err)
+		}
+		if got := phases[len(phases)-1]; got != "parse done" {
+			t.Errorf("%v: last phase is %q, want %q", name, got, "parse done")
+		}
+		if !contains(phases, "postProcessTrace") {
+			t.Errorf("%v: no postProcessTrace phase in %q", name, phases)
+		}
+		if last.Bytes != last.Total {
+			t.Errorf("%v: decoded %v bytes of %v", name, last.Bytes, last.Total)
+		}
+	}
+}
+
+func TestParseOptionsStream(t *testing.T) {
+	// Parse decodes each generation before it reads the next one.
+	w := newWriter2(1023)
+	for gen := uint64(1); gen <= 3; gen++ {
+		w.gen(gen, nil, nil, mEvents{1, [][]uint64{{ev2ProcStatus, 1, 0, proc2Running}}})
+	}
+	size := w.Len()
+	r := &countingReader{r: iotest.OneByteReader(&w.Buffer)}
+	var early bool
+	opts := &ParseOptions{
+		Quiet: true,
+		Progress: func(p Progress) {
+			if p.Phase == "readGenerations" && p.Bytes > 16 && r.n < size {
+				early = true
+			}
+		},
+	}
+	want, err := (&ParseOptions{Quiet: true}).ParseBytes(w.Bytes())
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	events, err := opts.Parse(r)
+	if err != nil {
+		t.Fatalf("failed to parse the stream: %v", err)
+	}
+	if !early {
+		t.Errorf("no generation was decoded before the end of the input")
+	}
+	if got, want := describe2(events), describe2(want); got != want {
+		t.Errorf("got events\n\t%v\nwant\n\t%v", got, want)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (r *countingReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	r.n += n
+	return n, err
+}
+
+func TestParseOptionsLogger(t *testing.T) {
+	var buf bytes.Buffer
+	opts := &ParseOptions{Logger: log.New(&buf, "", 0)}
+	if _, err := opts.ParseFile("testdata/stress_1_11_good"); err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if !strings.Contains(buf.String(), "postProcessTrace\n") {
+		t.Errorf("log does not contain the phases:\n%s", buf.String())
+	}
+}
+
+func TestParseOptionsKeepFutile(t *testing.T) {
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoBlockSync, 1, 0)
+	w.emit(EvGoCreate, 1, 2, 0, 0)
+	w.emit(EvGoStart, 1, 2, 1)
+	w.emit(EvGoUnblock, 1, 1, 2, 0)
+	w.emit(EvGoEnd, 1)
+	w.emit(EvGoStart, 1, 1, 3)
+	w.emit(EvFutileWakeup, 1)
+	w.emit(EvGoBlockSync, 1, 0)
+	data := w.Bytes()
+
+	removed, err := (&ParseOptions{Quiet: true}).ParseBytes(data)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	kept, err := (&ParseOptions{Quiet: true, KeepFutile: true}).ParseBytes(data)
+	if err != nil {
+		t.Fatalf("failed to parse with futile wakeups: %v", err)
+	}
+	// The unblock, the start, the futile wakeup and the block.
+	var futile []byte
+	for _, ev := range kept {
+		if ev.Futile {
+			futile = append(futile, ev.Type)
+		}
+	}
+	if want := []byte{EvGoUnblock, EvGoStart, EvFutileWakeup, EvGoBlockSync}; !bytes.Equal(futile, want) {
+		t.Errorf("got futile events %v, want %v", futile, want)
+	}
+	if len(kept)-len(removed) != len(futile) {
+		t.Errorf("kept %v events more, but %v are marked futile", len(kept)-len(removed), len(futile))
+	}
+	for _, ev := range removed {
+		if ev.Futile || ev.Type == EvFutileWakeup {
+			t.Errorf("futile event %v is not removed", ev)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
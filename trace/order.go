@@ -12,6 +12,29 @@ import (
 type eventBatch struct {
 	events   []*Event
 	selected bool
+	// next, if not nil, returns the next event of the batch when events
+	// is empty, or nil at the end of the batch.
+	next func() (*Event, error)
+}
+
+// head returns the first unmerged event of the batch, or nil if all its
+// events are merged.
+func (b *eventBatch) head() (*Event, error) {
+	if len(b.events) == 0 && b.next != nil {
+		ev, err := b.next()
+		if err != nil {
+			return nil, err
+		}
+		if ev == nil {
+			b.next = nil
+			return nil, nil
+		}
+		b.events = append(b.events[:0], ev)
+	}
+	if len(b.events) == 0 {
+		return nil, nil
+	}
+	return b.events[0], nil
 }
 
 type orderEvent struct {
@@ -50,58 +73,49 @@ const (
 // event with the lowest timestamp from the subset, merge it and repeat.
 // This approach ensures that we form a consistent stream even if timestamps are
 // incorrect (condition observed on some machines).
-func order1007(m map[int][]*Event) (events []*Event, err error) {
-	pending := 0
+// If rec is not nil, events are merged even if the events they depend on
+// are missing; postProcessTrace then has to deal with the inconsistencies.
+// If skew is set, timestamps that contradict the stream are repaired by
+// repairClockSkew instead of failing with ErrTimeOrder.
+func order1007(m map[int][]*Event, skew bool, rec *Recovery) (events []*Event, err error) {
 	var batches []*eventBatch
-	for _, v := range m {
-		pending += len(v)
-		batches = append(batches, &eventBatch{v, false})
+	for _, p := range sortedPs(m) {
+		batches = append(batches, &eventBatch{events: m[p]})
 	}
-	gs := make(map[uint64]gState)
-	var frontier []orderEvent
-	for ; pending != 0; pending-- {
-		for i, b := range batches {
-			if b.selected || len(b.events) == 0 {
-				continue
-			}
-			ev := b.events[0]
-			g, init, next := stateTransition(ev)
-			if !transitionReady(g, gs[g], init) {
-				continue
-			}
-			frontier = append(frontier, orderEvent{ev, i, g, init, next})
-			b.events = b.events[1:]
-			b.selected = true
-			// Get rid of "Local" events, they are intended merely for ordering.
-			switch ev.Type {
-			case EvGoStartLocal:
-				ev.Type = EvGoStart
-			case EvGoUnblockLocal:
-				ev.Type = EvGoUnblock
-			case EvGoSysExitLocal:
-				ev.Type = EvGoSysExit
-			}
+	mg := newMerger(batches, rec)
+	var edges []clockEdge
+	last := make(map[uint64]*Event) // last merged event of each goroutine
+	for {
+		f, err := mg.next()
+		if err != nil {
+			return nil, err
 		}
-		if len(frontier) == 0 {
-			return nil, fmt.Errorf("no consistent ordering of events possible")
+		if f.ev == nil {
+			break
 		}
-		sort.Sort(orderEventList(frontier))
-		f := frontier[0]
-		frontier[0] = frontier[len(frontier)-1]
-		frontier = frontier[:len(frontier)-1]
 		events = append(events, f.ev)
-		transition(gs, f.g, f.init, f.next)
-		if !batches[f.batch].selected {
-			panic("frontier batch is not selected")
+		if skew && f.g != unordered {
+			if prev := last[f.g]; prev != nil && prev.P != f.ev.P {
+				edges = append(edges, clockEdge{prev, f.ev})
+			}
+			last[f.g] = f.ev
 		}
-		batches[f.batch].selected = false
 	}
 
 	// At this point we have a consistent stream of events.
 	// Make sure time stamps respect the ordering.
 	// The tests will skip (not fail) the test case if they see this error.
-	if !sort.IsSorted(eventList(events)) {
-		return nil, ErrTimeOrder
+	// When recovering, events that became inconsistent by the sort below
+	// are dropped later by postProcessTrace.
+	var offsets map[int]int64
+	if skew {
+		offsets = repairClockSkew(events, edges)
+	}
+	if ev, err := checkTimeOrder(events); ev != nil {
+		if rec == nil {
+			return nil, err
+		}
+		rec.violate(err, "", "").ev = ev
 	}
 
 	// The last part is giving correct timestamps to EvGoSysExit events.
@@ -123,12 +137,30 @@ func order1007(m map[int][]*Event) (events []*Event, err error) {
 			if ts == 0 {
 				continue
 			}
+			ts += offsets[ev.P]
 			block := lastSysBlock[ev.G]
 			if block == 0 {
-				return nil, fmt.Errorf("stray syscall exit")
+				if rec != nil {
+					// The syscall started in a dropped event.
+					rec.violate(ev.errorf("stray syscall exit"), fmt.Sprintf("g %v in a syscall", ev.G), fmt.Sprintf("g %v not in a syscall", ev.G)).ev = ev
+					continue
+				}
+				return nil, ev.errorf("stray syscall exit")
+			}
+			if ts < block && skew {
+				ts = block
 			}
 			if ts < block {
-				return nil, ErrTimeOrder
+				err := ev.errorf("time stamps out of order: syscall exit at %v before the syscall at %v", ts, block)
+				err.Kind = KindTimeOrder
+				if rec != nil {
+					rec.violate(err, "", "").ev = ev
+					continue
+				}
+				return nil, err
+			}
+			if skew && ts != int64(ev.Args[2]) {
+				ev.ClockAdjusted = true
 			}
 			ev.Ts = ts
 		}
@@ -138,6 +170,106 @@ func order1007(m map[int][]*Event) (events []*Event, err error) {
 	return
 }
 
+// merger merges per-P event batches one event at a time, as described
+// for order1007. It needs only the first unmerged event of each batch.
+type merger struct {
+	batches  []*eventBatch
+	gs       map[uint64]gState
+	frontier []orderEvent
+	rec      *Recovery
+}
+
+func newMerger(batches []*eventBatch, rec *Recovery) *merger {
+	return &merger{batches: batches, gs: make(map[uint64]gState), rec: rec}
+}
+
+// next merges the next event. It returns an orderEvent with a nil event
+// when all events are merged.
+func (m *merger) next() (orderEvent, error) {
+	for {
+		for i, b := range m.batches {
+			if b.selected {
+				continue
+			}
+			ev, err := b.head()
+			if err != nil {
+				return orderEvent{}, err
+			}
+			if ev == nil {
+				continue
+			}
+			g, init, next := stateTransition(ev)
+			if !transitionReady(g, m.gs[g], init) {
+				continue
+			}
+			m.frontier = append(m.frontier, orderEvent{ev, i, g, init, next})
+			b.events = b.events[1:]
+			b.selected = true
+			// Get rid of "Local" events, they are intended merely for ordering.
+			switch ev.Type {
+			case EvGoStartLocal:
+				ev.Type = EvGoStart
+			case EvGoUnblockLocal:
+				ev.Type = EvGoUnblock
+			case EvGoSysExitLocal:
+				ev.Type = EvGoSysExit
+			}
+		}
+		if len(m.frontier) == 0 {
+			// The events that make the earliest event ready are missing.
+			var ev *Event
+			for _, b := range m.batches {
+				if len(b.events) != 0 && (ev == nil || b.events[0].Ts < ev.Ts) {
+					ev = b.events[0]
+				}
+			}
+			if ev == nil {
+				return orderEvent{}, nil
+			}
+			g, init, _ := stateTransition(ev)
+			if m.rec == nil {
+				return orderEvent{}, notReady(ev)
+			}
+			// Resynchronize its goroutine with the event and merge it.
+			resync(m.gs, g, init, ev, m.rec)
+			continue
+		}
+		sort.Sort(orderEventList(m.frontier))
+		f := m.frontier[0]
+		m.frontier[0] = m.frontier[len(m.frontier)-1]
+		m.frontier = m.frontier[:len(m.frontier)-1]
+		if m.rec != nil && !transitionReady(f.g, m.gs[f.g], f.init) {
+			// The goroutine was resynchronized with another event
+			// after this one was selected.
+			resync(m.gs, f.g, f.init, f.ev, m.rec)
+		}
+		transition(m.gs, f.g, f.init, f.next)
+		if !m.batches[f.batch].selected {
+			panic("frontier batch is not selected")
+		}
+		m.batches[f.batch].selected = false
+		return f, nil
+	}
+}
+
+// notReady returns the error for an event that cannot be merged because
+// the events it depends on are missing.
+func notReady(ev *Event) *ParseError {
+	err := ev.errorf("no consistent ordering of events possible: %v is not ready", EventDescriptions[ev.Type].Name)
+	err.Kind = KindOrdering
+	return err
+}
+
+// resync records that ev of goroutine g is not ready and puts g in the
+// state init that makes ev ready.
+func resync(gs map[uint64]gState, g uint64, init gState, ev *Event, rec *Recovery) {
+	rec.violate(notReady(ev), init.describe(g), gs[g].describe(g)).ev = ev
+	if init.seq == noseq {
+		init.seq = gs[g].seq
+	}
+	gs[g] = init
+}
+
 // stateTransition returns goroutine state (sequence and status) when the event
 // becomes ready for merging (init) and the goroutine state after the event (next).
 func stateTransition(ev *Event) (g uint64, init, next gState) {
@@ -150,7 +282,7 @@ func stateTransition(ev *Event) (g uint64, init, next gState) {
 		g = ev.G
 		init = gState{1, gRunnable}
 		next = gState{2, gWaiting}
-	case EvGoStart:
+	case EvGoStart, EvGoStartLabel:
 		g = ev.G
 		init = gState{ev.Args[1], gRunnable}
 		next = gState{ev.Args[1] + 1, gRunning}
@@ -165,7 +297,7 @@ func stateTransition(ev *Event) (g uint64, init, next gState) {
 		init = gState{noseq, gRunnable}
 		next = gState{seqinc, gRunning}
 	case EvGoBlock, EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect,
-		EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoSleep, EvGoSysBlock:
+		EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoSleep, EvGoSysBlock, EvGoBlockGC:
 		g = ev.G
 		init = gState{noseq, gRunning}
 		next = gState{noseq, gWaiting}
@@ -214,9 +346,11 @@ func transition(gs map[uint64]gState, g uint64, init, next gState) {
 }
 
 // order1005 merges a set of per-P event batches into a single, consistent stream.
-func order1005(m map[int][]*Event) (events []*Event, err error) {
-	for _, batch := range m {
-		events = append(events, batch...)
+// If skew is set, timestamps that contradict the stream are repaired by
+// repairClockSkew instead of failing with ErrTimeOrder.
+func order1005(m map[int][]*Event, skew bool) (events []*Event, err error) {
+	for _, p := range sortedPs(m) {
+		events = append(events, m[p]...)
 	}
 	for _, ev := range events {
 		if ev.Type == EvGoSysExit {
@@ -229,12 +363,41 @@ func order1005(m map[int][]*Event) (events []*Event, err error) {
 		}
 	}
 	sort.Sort(eventSeqList(events))
-	if !sort.IsSorted(eventList(events)) {
-		return nil, ErrTimeOrder
+	if skew {
+		// The sequence numbers order events of all Ps, so there are no
+		// dependencies to estimate offsets from.
+		repairClockSkew(events, nil)
+	}
+	if ev, err := checkTimeOrder(events); ev != nil {
+		return nil, err
 	}
 	return
 }
 
+// checkTimeOrder returns the first of the events whose time stamp is
+// before that of the preceding event, and a KindTimeOrder error for it.
+// It returns nil if the time stamps respect the order of the events.
+func checkTimeOrder(events []*Event) (*Event, *ParseError) {
+	for i := 1; i < len(events); i++ {
+		if ev := events[i]; ev.Ts < events[i-1].Ts {
+			err := ev.errorf("time stamps out of order: %v is before the preceding %v", EventDescriptions[ev.Type].Name, EventDescriptions[events[i-1].Type].Name)
+			err.Kind = KindTimeOrder
+			return ev, err
+		}
+	}
+	return nil, nil
+}
+
+// sortedPs returns the Ps of the batches in m in increasing order.
+func sortedPs(m map[int][]*Event) []int {
+	ps := make([]int, 0, len(m))
+	for p := range m {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	return ps
+}
+
 type orderEventList []orderEvent
 
 func (l orderEventList) Len() int {
@@ -276,3 +439,18 @@ func (l eventSeqList) Less(i, j int) bool {
 func (l eventSeqList) Swap(i, j int) {
 	l[i], l[j] = l[j], l[i]
 }
+
+var gStatusNames = [...]string{
+	gDead:     "dead",
+	gRunnable: "runnable",
+	gRunning:  "running",
+	gWaiting:  "waiting",
+}
+
+// describe describes the state of goroutine g.
+func (s gState) describe(g uint64) string {
+	if s.seq == noseq || s.seq == seqinc {
+		return fmt.Sprintf("g %v %v", g, gStatusNames[s.status])
+	}
+	return fmt.Sprintf("g %v %v with seq %v", g, gStatusNames[s.status], s.seq)
+}
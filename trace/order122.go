@@ -0,0 +1,812 @@
+package trace
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+)
+
+// ordering2 merges the per-M batches of the generation-based format into
+// a single stream and translates the events into the Event model used by
+// the rest of the package.
+//
+// Events within a batch are ordered, so like order1007 we repeatedly take
+// the frontier of unmerged events, one per M, and merge the earliest event
+// whose dependencies are satisfied. An event is ready if the goroutine or
+// P it refers to is in the right state and its sequence number is the
+// next one. The state of goroutines, Ps and Ms is tracked across
+// generations; status events at the start of each generation reset the
+// sequence numbers.
+//
+// The new format does not emit the events that the Event model expects
+// for goroutines existing when tracing starts, so they are synthesized:
+// EvGoCreate for every such goroutine at the start of the trace, followed
+// by EvGoWaiting or EvGoInSyscall, or EvGoStart once the goroutine is
+// found running. Syscalls are translated the way the old runtime traced
+// them: EvGoSysCall on entry, EvGoSysBlock when the P is taken away from
+// the goroutine, and EvGoSysExit when a goroutine that lost its P returns.
+type ordering2 struct {
+	ver   int
+	gen   *generation
+	first bool      // gen is the first generation
+	rec   *Recovery // if not nil, events that cannot be merged are dropped
+
+	gs      map[uint64]*gState2
+	ps      map[uint64]*pState2
+	ms      map[uint64]*mState2
+	gcSeq   uint64
+	gcState int
+	stw     bool
+
+	base     int64 // start of the trace, in nanoseconds
+	lastTs   int64
+	initial  []*Event // events synthesized for goroutines existing when tracing starts
+	events   []*Event
+	stacks   map[uint64][]*Frame
+	stackIDs map[uint64]uint64 // per-generation stack id to stack id
+	stkKeys  map[string]uint64 // PCs of the stack to stack id
+	frames   map[uint64]*Frame // PC to frame
+	starts   map[uint64]*Event // last EvGoStart of running goroutines
+	noStack  map[uint64]*Event // synthesized EvGoCreate events that lack a start stack
+	sysDead  map[uint64]bool   // goroutines destroyed in a syscall
+}
+
+const (
+	gcUndetermined = iota
+	gcRunning
+	gcNotRunning
+)
+
+// seq2 is a goroutine or P sequence number.
+// Sequence numbers are reset at the start of each generation.
+type seq2 struct {
+	gen uint64
+	n   uint64
+}
+
+func (s seq2) succeeds(prev seq2) bool {
+	return s.gen == prev.gen && s.n == prev.n+1
+}
+
+type gState2 struct {
+	status byte // one of go2*
+	seq    seq2
+	p      int  // P the goroutine is running on in the Event model, -1 if not running
+	assist bool // in mark assist
+}
+
+type pState2 struct {
+	status byte // one of proc2*
+	seq    seq2
+	sweep  bool // sweeping
+}
+
+type mState2 struct {
+	p int    // P held by the M, -1 if none
+	g uint64 // goroutine bound to the M, 0 if none
+}
+
+func newOrdering2(ver int) *ordering2 {
+	return &ordering2{
+		ver:     ver,
+		gs:      make(map[uint64]*gState2),
+		ps:      make(map[uint64]*pState2),
+		ms:      make(map[uint64]*mState2),
+		stacks:  make(map[uint64][]*Frame),
+		stkKeys: make(map[string]uint64),
+		frames:  make(map[uint64]*Frame),
+		starts:  make(map[uint64]*Event),
+		noStack: make(map[uint64]*Event),
+		sysDead: make(map[uint64]bool),
+	}
+}
+
+// mBatch is the unmerged part of the events of an M.
+type mBatch struct {
+	m      uint64
+	events []event2
+}
+
+// frontier is a heap of mBatches ordered by the timestamp of the first event.
+type frontier []*mBatch
+
+func (f frontier) Len() int            { return len(f) }
+func (f frontier) Less(i, j int) bool  { return f[i].events[0].ts < f[j].events[0].ts }
+func (f frontier) Swap(i, j int)       { f[i], f[j] = f[j], f[i] }
+func (f *frontier) Push(x interface{}) { *f = append(*f, x.(*mBatch)) }
+func (f *frontier) Pop() interface{} {
+	old := *f
+	x := old[len(old)-1]
+	*f = old[:len(old)-1]
+	return x
+}
+
+// addGeneration merges and translates the events of the generation.
+func (o *ordering2) addGeneration(g *generation) error {
+	o.first = o.gen == nil
+	if o.first {
+		o.base = int64(float64(g.minTs) * g.freq)
+	}
+	o.gen = g
+	o.stackIDs = make(map[uint64]uint64)
+
+	var f frontier
+	for _, m := range g.ms {
+		b := &mBatch{m: m}
+		for _, raw := range g.batches[m] {
+			events, err := g.events(raw, o.ver)
+			if err != nil {
+				return err
+			}
+			b.events = append(b.events, events...)
+		}
+		if len(b.events) != 0 {
+			f = append(f, b)
+		}
+	}
+	heap.Init(&f)
+	var blocked []*mBatch
+	for f.Len() != 0 {
+		b := heap.Pop(&f).(*mBatch)
+		ev := &b.events[0]
+		ok, err := o.advance(b.m, ev)
+		if err != nil {
+			if o.rec == nil {
+				return err
+			}
+			o.rec.violate(err, "", "")
+			o.rec.DroppedEvents++
+		} else if !ok {
+			blocked = append(blocked, b)
+			if f.Len() != 0 {
+				continue
+			}
+			ev := &blocked[0].events[0]
+			err := o.errorf(ev, "no consistent ordering of events is possible: event on m %v is not ready", blocked[0].m)
+			err.Kind = KindOrdering
+			if o.rec == nil {
+				return err
+			}
+			o.rec.violate(err, "", "")
+			// Drop the earliest event that is not ready.
+			o.rec.DroppedEvents++
+			b, blocked = blocked[0], blocked[1:]
+		}
+		if b.events = b.events[1:]; len(b.events) != 0 {
+			heap.Push(&f, b)
+		}
+		for _, b := range blocked {
+			heap.Push(&f, b)
+		}
+		blocked = blocked[:0]
+	}
+	return nil
+}
+
+// flush returns the events merged since the previous call.
+// The stacks of the events are in o.stacks.
+func (o *ordering2) flush() []*Event {
+	// Goroutines that existed before tracing started have no start stack.
+	// Use the outermost frame of the first stack seen on the goroutine.
+	for _, ev := range o.events {
+		if len(o.noStack) == 0 {
+			break
+		}
+		create := o.noStack[ev.G]
+		if create == nil || ev.StkID == 0 || len(o.stacks[ev.StkID]) == 0 {
+			continue
+		}
+		o.setStartStack(create, ev.StkID)
+	}
+	events := append(o.initial, o.events...)
+	o.initial, o.events = nil, nil
+	return events
+}
+
+func (o *ordering2) setStartStack(create *Event, stkID uint64) {
+	stk := o.stacks[stkID]
+	create.Args[1] = o.addStack(stk[len(stk)-1:])
+	delete(o.noStack, create.Args[0])
+}
+
+// advance merges ev if it is ready. It returns false if ev must wait
+// for events on other Ms.
+func (o *ordering2) advance(m uint64, ev *event2) (bool, error) {
+	ms := o.ms[m]
+	if ms == nil {
+		ms = &mState2{p: -1}
+		o.ms[m] = ms
+	}
+	gen := o.gen.gen
+	a := ev.args
+	switch ev.typ {
+	case ev2ProcStatus:
+		pid, status := a[0], byte(a[1])
+		if status == proc2Bad || status > proc2SyscallAbandoned {
+			return false, o.errorf(ev, "invalid status %v for p %v", status, pid)
+		}
+		p := o.ps[pid]
+		if p == nil {
+			p = &pState2{status: status}
+			o.ps[pid] = p
+			if status == proc2Running || status == proc2Syscall {
+				o.emit(ev, EvProcStart, int(pid), 0, 0, m)
+			}
+		} else if p.status != status && !(status == proc2SyscallAbandoned && p.status == proc2Syscall) {
+			return false, o.errorf(ev, "inconsistent status for p %v: %v vs %v", pid, p.status, status)
+		}
+		p.seq = seq2{gen, 0}
+		if status == proc2Running || status == proc2Syscall {
+			ms.p = int(pid)
+		}
+	case ev2ProcStart:
+		pid, seq := a[0], seq2{gen, a[1]}
+		p := o.ps[pid]
+		if p == nil || p.status != proc2Idle || !seq.succeeds(p.seq) || ms.p >= 0 {
+			return false, nil
+		}
+		p.status = proc2Running
+		p.seq = seq
+		ms.p = int(pid)
+		o.emit(ev, EvProcStart, ms.p, 0, 0, m)
+	case ev2ProcStop:
+		p := o.ps[uint64(ms.p)]
+		if ms.p < 0 || p == nil || (p.status != proc2Running && p.status != proc2Syscall) {
+			return false, o.errorf(ev, "m %v stops p %v that is not running", m, ms.p)
+		}
+		if g := o.gs[ms.g]; g != nil && g.status == go2Running {
+			return false, o.errorf(ev, "m %v stops p %v while g %v is running", m, ms.p, ms.g)
+		}
+		// The goroutine blocked in a syscall and handed off the P.
+		if g := o.gs[ms.g]; g != nil && g.status == go2Syscall && g.p == ms.p {
+			o.stop(ev, EvGoSysBlock, ms.g, 0)
+		}
+		p.status = proc2Idle
+		o.emit(ev, EvProcStop, ms.p, 0, 0)
+		ms.p = -1
+	case ev2ProcSteal:
+		pid, seq, mid := a[0], seq2{gen, a[1]}, a[2]
+		p := o.ps[pid]
+		if p == nil || (p.status != proc2Syscall && p.status != proc2SyscallAbandoned) || !seq.succeeds(p.seq) {
+			return false, nil
+		}
+		abandoned := p.status == proc2SyscallAbandoned
+		p.status = proc2Idle
+		p.seq = seq
+		if abandoned {
+			// The P was already stopped when the goroutine exited.
+			break
+		}
+		victim := o.ms[mid]
+		if victim == nil || victim.p != int(pid) {
+			return false, o.errorf(ev, "m %v steals p %v from m %v that does not hold it", m, pid, mid)
+		}
+		if g := o.gs[victim.g]; g != nil && g.status == go2Syscall && g.p == int(pid) {
+			o.stop(ev, EvGoSysBlock, victim.g, 0)
+		}
+		victim.p = -1
+		o.emit(ev, EvProcStop, int(pid), 0, 0)
+	case ev2GoStatus, ev2GoStatusStack:
+		gid, mid, status := a[0], a[1], byte(a[2])
+		if status == go2Bad || status > go2Waiting {
+			return false, o.errorf(ev, "invalid status %v for g %v", status, gid)
+		}
+		g := o.gs[gid]
+		created := g == nil
+		if created {
+			if !o.first {
+				return false, o.errorf(ev, "status of unknown g %v after the first generation", gid)
+			}
+			g = &gState2{status: status, p: -1}
+			o.gs[gid] = g
+			o.create(ev, gid)
+			if ev.typ == ev2GoStatusStack && a[3] != 0 {
+				if stkID := o.stack(a[3]); len(o.stacks[stkID]) != 0 {
+					o.setStartStack(o.noStack[gid], stkID)
+				}
+			}
+		} else if g.status != status {
+			return false, o.errorf(ev, "inconsistent status for g %v: %v vs %v", gid, g.status, status)
+		}
+		g.seq = seq2{gen, 0}
+		switch status {
+		case go2Running:
+			ms.g = gid
+			if created {
+				if ms.p < 0 {
+					return false, o.errorf(ev, "g %v is running without a p", gid)
+				}
+				o.start(ev, gid, ms.p, 0)
+			}
+		case go2Waiting:
+			if created {
+				o.initial = append(o.initial, &Event{Off: ev.off, Type: EvGoWaiting, ver: uint16(o.ver), G: gid, Args: [3]uint64{gid}})
+			}
+		case go2Syscall:
+			sm := ms
+			if mid != m {
+				if sm = o.ms[mid]; sm == nil {
+					sm = &mState2{p: -1}
+					o.ms[mid] = sm
+				}
+			}
+			if sm.g != 0 && sm.g != gid {
+				return false, o.errorf(ev, "g %v is in syscall on m %v that runs g %v", gid, mid, sm.g)
+			}
+			sm.g = gid
+			if created {
+				if sm.p >= 0 {
+					o.start(ev, gid, sm.p, 0)
+					o.emit(ev, EvGoSysCall, sm.p, gid, 0)
+				} else {
+					o.initial = append(o.initial, &Event{Off: ev.off, Type: EvGoInSyscall, ver: uint16(o.ver), G: gid, Args: [3]uint64{gid}})
+				}
+			}
+		}
+	case ev2GoCreate, ev2GoCreateBlocked:
+		newg := a[0]
+		if ms.p < 0 {
+			return false, o.errorf(ev, "g %v is created without a p", newg)
+		}
+		if _, ok := o.gs[newg]; ok {
+			return false, o.errorf(ev, "g %v already exists", newg)
+		}
+		status := byte(go2Runnable)
+		if ev.typ == ev2GoCreateBlocked {
+			status = go2Waiting
+		}
+		o.gs[newg] = &gState2{status: status, seq: seq2{gen, 0}, p: -1}
+		o.emit(ev, EvGoCreate, ms.p, o.runningG(ms), a[2], newg, o.stack(a[1]))
+		if status == go2Waiting {
+			o.emit(ev, EvGoWaiting, ms.p, newg, 0, newg)
+		}
+	case ev2GoCreateSyscall:
+		newg := a[0]
+		if ms.g != 0 {
+			return false, o.errorf(ev, "g %v is created in syscall on m %v that runs g %v", newg, m, ms.g)
+		}
+		if _, ok := o.gs[newg]; ok {
+			return false, o.errorf(ev, "g %v already exists", newg)
+		}
+		o.gs[newg] = &gState2{status: go2Syscall, seq: seq2{gen, 0}, p: -1}
+		ms.g = newg
+		if o.sysDead[newg] {
+			// The goroutine is still blocked in the syscall in the Event model.
+			delete(o.sysDead, newg)
+			break
+		}
+		p := ms.p
+		if p < 0 {
+			p = SyscallP
+		}
+		o.noStack[newg] = o.emit(ev, EvGoCreate, p, 0, 0, newg, 0)
+		o.emit(ev, EvGoInSyscall, p, newg, 0, newg)
+	case ev2GoStart:
+		gid, seq := a[0], seq2{gen, a[1]}
+		g := o.gs[gid]
+		if g == nil || g.status != go2Runnable || !seq.succeeds(g.seq) {
+			return false, nil
+		}
+		if ms.p < 0 || ms.g != 0 {
+			return false, o.errorf(ev, "g %v starts on m %v without a p or with a running goroutine", gid, m)
+		}
+		g.status = go2Running
+		g.seq = seq
+		ms.g = gid
+		o.start(ev, gid, ms.p, a[1])
+	case ev2GoDestroy, ev2GoStop, ev2GoBlock:
+		g, err := o.running(ev, ms)
+		if err != nil {
+			return false, err
+		}
+		switch ev.typ {
+		case ev2GoDestroy:
+			o.stop(ev, EvGoEnd, ms.g, 0)
+			delete(o.gs, ms.g)
+		case ev2GoStop:
+			g.status = go2Runnable
+			o.stop(ev, stopType(o.str(a[0])), ms.g, a[1])
+		case ev2GoBlock:
+			g.status = go2Waiting
+			o.stop(ev, blockType(o.str(a[0])), ms.g, a[1])
+		}
+		delete(o.starts, ms.g)
+		ms.g = 0
+	case ev2GoUnblock:
+		gid, seq := a[0], seq2{gen, a[1]}
+		g := o.gs[gid]
+		if g == nil || g.status != go2Waiting || !seq.succeeds(g.seq) {
+			return false, nil
+		}
+		g.status = go2Runnable
+		g.seq = seq
+		p := ms.p
+		if p < 0 {
+			p = NetpollP
+		}
+		o.emit(ev, EvGoUnblock, p, o.runningG(ms), a[2], gid, a[1])
+	case ev2GoSwitch, ev2GoSwitchDestroy:
+		cur, err := o.running(ev, ms)
+		if err != nil {
+			return false, err
+		}
+		next, seq := a[0], seq2{gen, a[1]}
+		g := o.gs[next]
+		if g == nil || g.status != go2Waiting || !seq.succeeds(g.seq) {
+			return false, nil
+		}
+		o.emit(ev, EvGoUnblock, ms.p, ms.g, 0, next, a[1])
+		if ev.typ == ev2GoSwitch {
+			cur.status = go2Waiting
+			o.stop(ev, EvGoBlock, ms.g, 0)
+		} else {
+			o.stop(ev, EvGoEnd, ms.g, 0)
+			delete(o.gs, ms.g)
+		}
+		delete(o.starts, ms.g)
+		g.status = go2Running
+		g.seq = seq
+		ms.g = next
+		o.start(ev, next, ms.p, a[1])
+	case ev2GoSyscallBegin:
+		g, err := o.running(ev, ms)
+		if err != nil {
+			return false, err
+		}
+		p := o.ps[uint64(ms.p)]
+		if ms.p < 0 || p == nil {
+			return false, o.errorf(ev, "m %v enters syscall without a p", m)
+		}
+		if seq := (seq2{gen, a[0]}); !seq.succeeds(p.seq) {
+			return false, o.errorf(ev, "p %v has sequence number %v, want %v", ms.p, a[0], p.seq.n+1)
+		} else {
+			p.seq = seq
+		}
+		g.status = go2Syscall
+		p.status = proc2Syscall
+		o.emit(ev, EvGoSysCall, ms.p, ms.g, a[1])
+	case ev2GoSyscallEnd:
+		g := o.gs[ms.g]
+		if g == nil || g.status != go2Syscall {
+			return false, o.errorf(ev, "m %v exits syscall without a goroutine in syscall", m)
+		}
+		p := o.ps[uint64(ms.p)]
+		if ms.p < 0 || p == nil || p.status != proc2Syscall {
+			return false, o.errorf(ev, "m %v exits syscall without a p in syscall", m)
+		}
+		g.status = go2Running
+		p.status = proc2Running
+		if g.p != ms.p {
+			// The goroutine is blocked in the Event model,
+			// for example it was created in the syscall.
+			o.emit(ev, EvGoSysExit, SyscallP, ms.g, 0, ms.g)
+			o.start(ev, ms.g, ms.p, 0)
+		}
+	case ev2GoSyscallEndBlock:
+		if p := o.ps[uint64(ms.p)]; ms.p >= 0 && p != nil && p.status == proc2Syscall {
+			// Wait until the P is stolen.
+			return false, nil
+		}
+		g := o.gs[ms.g]
+		if g == nil || g.status != go2Syscall {
+			return false, o.errorf(ev, "m %v exits syscall without a goroutine in syscall", m)
+		}
+		g.status = go2Runnable
+		if g.p >= 0 {
+			o.stop(ev, EvGoSysBlock, ms.g, 0)
+		}
+		o.emit(ev, EvGoSysExit, SyscallP, ms.g, 0, ms.g)
+		ms.g = 0
+	case ev2GoDestroySyscall:
+		g := o.gs[ms.g]
+		if g == nil || g.status != go2Syscall {
+			return false, o.errorf(ev, "m %v destroys a goroutine that is not in syscall", m)
+		}
+		// The Event model has no way to end a goroutine in a syscall,
+		// so it stays blocked there until it is reused for another callback.
+		if g.p >= 0 {
+			o.stop(ev, EvGoSysBlock, ms.g, 0)
+		}
+		if ms.p >= 0 {
+			p := o.ps[uint64(ms.p)]
+			if p == nil || p.status != proc2Syscall {
+				return false, o.errorf(ev, "m %v destroys g %v in syscall without a p in syscall", m, ms.g)
+			}
+			p.status = proc2SyscallAbandoned
+			o.emit(ev, EvProcStop, ms.p, 0, 0)
+			ms.p = -1
+		}
+		delete(o.gs, ms.g)
+		delete(o.starts, ms.g)
+		o.sysDead[ms.g] = true
+		ms.g = 0
+	case ev2STWBegin:
+		e := o.emit(ev, EvGCSTWStart, o.procOr(ms, GCP), ms.g, a[1])
+		e.SArgs = []string{o.str(a[0])}
+		o.stw = true
+	case ev2STWEnd:
+		if o.stw {
+			o.emit(ev, EvGCSTWDone, o.procOr(ms, GCP), ms.g, 0)
+			o.stw = false
+		}
+	case ev2GCActive:
+		seq := a[0]
+		if o.first && o.gcState == gcUndetermined {
+			o.gcSeq = seq
+			o.gcState = gcRunning
+			o.emit(ev, EvGCStart, GCP, 0, 0, seq)
+			break
+		}
+		if seq != o.gcSeq+1 {
+			return false, nil
+		}
+		if o.gcState != gcRunning {
+			return false, o.errorf(ev, "GC is active while not in progress")
+		}
+		o.gcSeq = seq
+	case ev2GCBegin:
+		seq := a[0]
+		if o.gcState != gcUndetermined {
+			if seq != o.gcSeq+1 {
+				return false, nil
+			}
+			if o.gcState == gcRunning {
+				return false, o.errorf(ev, "previous GC is not ended before a new one")
+			}
+		}
+		o.gcSeq = seq
+		o.gcState = gcRunning
+		o.emit(ev, EvGCStart, GCP, ms.g, a[1], seq)
+	case ev2GCEnd:
+		if a[0] != o.gcSeq+1 {
+			return false, nil
+		}
+		if o.gcState != gcRunning {
+			return false, o.errorf(ev, "bogus GC end")
+		}
+		o.gcSeq = a[0]
+		o.gcState = gcNotRunning
+		o.emit(ev, EvGCDone, GCP, ms.g, 0)
+	case ev2GCSweepActive:
+		p := o.ps[a[0]]
+		if p == nil {
+			return false, o.errorf(ev, "sweep is active on unknown p %v", a[0])
+		}
+		if !p.sweep {
+			p.sweep = true
+			o.emit(ev, EvGCSweepStart, int(a[0]), 0, 0)
+		}
+	case ev2GCSweepBegin, ev2GCSweepEnd:
+		p := o.ps[uint64(ms.p)]
+		if ms.p < 0 || p == nil {
+			return false, o.errorf(ev, "m %v sweeps without a p", m)
+		}
+		if ev.typ == ev2GCSweepBegin {
+			if p.sweep {
+				return false, o.errorf(ev, "previous sweeping is not ended before a new one")
+			}
+			p.sweep = true
+			o.emit(ev, EvGCSweepStart, ms.p, ms.g, a[0])
+			break
+		}
+		if !p.sweep {
+			return false, o.errorf(ev, "bogus sweeping end")
+		}
+		p.sweep = false
+		o.emit(ev, EvGCSweepDone, ms.p, ms.g, 0, a[0], a[1])
+	case ev2GCMarkAssistActive:
+		g := o.gs[a[0]]
+		if g == nil {
+			return false, o.errorf(ev, "mark assist is active on unknown g %v", a[0])
+		}
+		if !g.assist {
+			g.assist = true
+			p := g.p
+			if p < 0 {
+				p = o.procOr(ms, GCP)
+			}
+			o.emit(ev, EvGCMarkAssistStart, p, a[0], 0)
+		}
+	case ev2GCMarkAssistBegin, ev2GCMarkAssistEnd:
+		g, err := o.running(ev, ms)
+		if err != nil {
+			return false, err
+		}
+		if ev.typ == ev2GCMarkAssistBegin {
+			if g.assist {
+				return false, o.errorf(ev, "previous mark assist is not ended before a new one")
+			}
+			g.assist = true
+			o.emit(ev, EvGCMarkAssistStart, ms.p, ms.g, a[0])
+			break
+		}
+		g.assist = false
+		o.emit(ev, EvGCMarkAssistDone, ms.p, ms.g, 0)
+	case ev2HeapAlloc:
+		o.emit(ev, EvHeapAlloc, ms.p, ms.g, 0, a[0])
+	case ev2HeapGoal:
+		o.emit(ev, EvNextGC, ms.p, ms.g, 0, a[0])
+	case ev2ProcsChange:
+		o.emit(ev, EvGomaxprocs, ms.p, ms.g, a[1], a[0])
+	case ev2GoLabel:
+		if start := o.starts[ms.g]; start != nil {
+			start.Type = EvGoStartLabel
+			start.SArgs = []string{o.str(a[0])}
+		}
+	case ev2UserTaskBegin:
+		e := o.emit(ev, EvUserTaskCreate, ms.p, ms.g, a[3], a[0], a[1])
+		e.SArgs = []string{o.str(a[2])}
+	case ev2UserTaskEnd:
+		o.emit(ev, EvUserTaskEnd, ms.p, ms.g, a[1], a[0])
+	case ev2UserRegionBegin, ev2UserRegionEnd:
+		var mode uint64
+		if ev.typ == ev2UserRegionEnd {
+			mode = 1
+		}
+		e := o.emit(ev, EvUserRegion, ms.p, ms.g, a[2], a[0], mode)
+		e.SArgs = []string{o.str(a[1])}
+	case ev2UserLog:
+		e := o.emit(ev, EvUserLog, ms.p, ms.g, a[3], a[0])
+		e.SArgs = []string{o.str(a[1]), o.str(a[2])}
+	default:
+		if ev.typ < ev2ExperimentalFirst {
+			return false, o.errorf(ev, "unexpected event type %v", ev.typ)
+		}
+		// Experimental events are not part of the Event model.
+	}
+	return true, nil
+}
+
+// create synthesizes the creation of a goroutine that existed
+// before tracing started.
+func (o *ordering2) create(ev *event2, gid uint64) {
+	create := &Event{Off: ev.off, Type: EvGoCreate, ver: uint16(o.ver), Args: [3]uint64{gid}}
+	o.initial = append(o.initial, create)
+	o.noStack[gid] = create
+}
+
+// start emits EvGoStart of the goroutine on P p.
+func (o *ordering2) start(ev *event2, gid uint64, p int, seq uint64) {
+	o.gs[gid].p = p
+	o.starts[gid] = o.emit(ev, EvGoStart, p, gid, 0, gid, seq)
+}
+
+// stop emits an event of type typ that stops the goroutine
+// on the P it is running on.
+func (o *ordering2) stop(ev *event2, typ byte, gid uint64, stk uint64) {
+	g := o.gs[gid]
+	o.emit(ev, typ, g.p, gid, stk)
+	g.p = -1
+}
+
+// running returns the state of the goroutine running on the M.
+func (o *ordering2) running(ev *event2, ms *mState2) (*gState2, error) {
+	g := o.gs[ms.g]
+	if g == nil || g.status != go2Running {
+		return nil, o.errorf(ev, "g %v is not running", ms.g)
+	}
+	return g, nil
+}
+
+// runningG returns the goroutine running on the P of the M
+// in the Event model, or 0.
+func (o *ordering2) runningG(ms *mState2) uint64 {
+	if g := o.gs[ms.g]; g != nil && ms.p >= 0 && g.p == ms.p {
+		return ms.g
+	}
+	return 0
+}
+
+// procOr returns the P held by the M, or p if the M has no P.
+func (o *ordering2) procOr(ms *mState2, p int) int {
+	if ms.p >= 0 {
+		return ms.p
+	}
+	return p
+}
+
+func (o *ordering2) emit(ev *event2, typ byte, p int, g uint64, stk uint64, args ...uint64) *Event {
+	ts := int64(float64(ev.ts)*o.gen.freq) - o.base
+	// Timestamps of different Ms may be slightly out of order.
+	if ts < o.lastTs {
+		ts = o.lastTs
+	}
+	o.lastTs = ts
+	e := &Event{Off: ev.off, Type: typ, ver: uint16(o.ver), Ts: ts, P: p, G: g, StkID: o.stack(stk)}
+	copy(e.Args[:], args)
+	o.events = append(o.events, e)
+	return e
+}
+
+func (o *ordering2) str(id uint64) string {
+	return o.gen.strings[id]
+}
+
+// stack returns the stack id for the per-generation stack id.
+func (o *ordering2) stack(id uint64) uint64 {
+	if id == 0 {
+		return 0
+	}
+	if stkID, ok := o.stackIDs[id]; ok {
+		return stkID
+	}
+	pcs, ok := o.gen.stacks[id]
+	if !ok {
+		return 0
+	}
+	stk := make([]*Frame, len(pcs))
+	for i, pc := range pcs {
+		f := o.frames[pc]
+		if f == nil {
+			f2 := o.gen.frames[pc]
+			f = &Frame{PC: pc, Fn: o.str(f2.fn), File: o.str(f2.file), Line: int(f2.line)}
+			o.frames[pc] = f
+		}
+		stk[i] = f
+	}
+	stkID := o.addStack(stk)
+	o.stackIDs[id] = stkID
+	return stkID
+}
+
+// addStack returns the stack id for stk. The same stack is reported
+// in every generation, so stacks are deduplicated by their PCs.
+func (o *ordering2) addStack(stk []*Frame) uint64 {
+	key := make([]byte, 0, len(stk)*binary.MaxVarintLen64)
+	var buf [binary.MaxVarintLen64]byte
+	for _, f := range stk {
+		n := binary.PutUvarint(buf[:], f.PC)
+		key = append(key, buf[:n]...)
+	}
+	if id, ok := o.stkKeys[string(key)]; ok {
+		return id
+	}
+	id := uint64(len(o.stacks) + 1)
+	o.stacks[id] = stk
+	o.stkKeys[string(key)] = id
+	return id
+}
+
+// errorf returns a KindStateMachine error for ev. The event types of the
+// format are not Event types, so the message names the event instead.
+func (o *ordering2) errorf(ev *event2, format string, args ...interface{}) *ParseError {
+	return &ParseError{
+		Kind: KindStateMachine,
+		Off:  ev.off,
+		Type: EvNone,
+		P:    -1,
+		Ts:   int64(float64(ev.ts)*o.gen.freq) - o.base,
+		Msg:  ev2Descriptions[ev.typ].Name + ": " + fmt.Sprintf(format, args...),
+	}
+}
+
+// blockType returns the blocking event type for the block reason.
+func blockType(reason string) byte {
+	switch reason {
+	case "chan send":
+		return EvGoBlockSend
+	case "chan receive":
+		return EvGoBlockRecv
+	case "select":
+		return EvGoBlockSelect
+	case "sync":
+		return EvGoBlockSync
+	case "sync.(*Cond).Wait":
+		return EvGoBlockCond
+	case "network":
+		return EvGoBlockNet
+	case "sleep":
+		return EvGoSleep
+	case "GC mark assist wait for work", "wait until GC ends":
+		return EvGoBlockGC
+	}
+	return EvGoBlock
+}
+
+// stopType returns the event type for the reason of a goroutine yield.
+func stopType(reason string) byte {
+	if reason == "preempted" {
+		return EvGoPreempt
+	}
+	return EvGoSched
+}
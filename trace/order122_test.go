@@ -0,0 +1,259 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// writer2 writes a trace in the generation-based format of Go 1.22 and later.
+type writer2 struct {
+	bytes.Buffer
+	ver int
+}
+
+func newWriter2(ver int) *writer2 {
+	w := &writer2{ver: ver}
+	header := []byte(fmt.Sprintf("go 1.%d trace\x00\x00\x00\x00", ver%1000))
+	w.Write(header[:16])
+	return w
+}
+
+// mEvents are the events of M m in a generation. Each event is its
+// type, its timestamp delta and its arguments.
+type mEvents struct {
+	m      uint64
+	events [][]uint64
+}
+
+// gen writes generation gen with a frequency of one tick per nanosecond,
+// the strings with ids from 1 and the stacks with ids from 1, each given
+// as PC, function string id, file string id and line of its frames.
+// The batches of the Ms start at time 1000*gen.
+func (w *writer2) gen(gen uint64, strs []string, stacks [][]uint64, ms ...mEvents) {
+	var sync []byte
+	if w.ver >= 1025 {
+		sync = append(sync, ev2Sync)
+	}
+	sync = append(sync, ev2Frequency)
+	sync = appendVarint(sync, 1e9)
+	w.batch(gen, 0, 0, sync)
+	if len(strs) > 0 {
+		data := []byte{ev2Strings}
+		for i, s := range strs {
+			data = append(data, ev2String)
+			data = appendVarint(data, uint64(i+1))
+			data = appendVarint(data, uint64(len(s)))
+			data = append(data, s...)
+		}
+		w.batch(gen, 0, 0, data)
+	}
+	if len(stacks) > 0 {
+		data := []byte{ev2Stacks}
+		for i, stk := range stacks {
+			data = append(data, ev2Stack)
+			data = appendVarint(data, uint64(i+1))
+			data = appendVarint(data, uint64(len(stk)/4))
+			for _, v := range stk {
+				data = appendVarint(data, v)
+			}
+		}
+		w.batch(gen, 0, 0, data)
+	}
+	for _, m := range ms {
+		var data []byte
+		for _, ev := range m.events {
+			data = append(data, byte(ev[0]))
+			for _, v := range ev[1:] {
+				data = appendVarint(data, v)
+			}
+		}
+		w.batch(gen, m.m, 1000*gen, data)
+	}
+	if w.ver >= 1026 {
+		w.WriteByte(ev2EndOfGeneration)
+	}
+}
+
+func (w *writer2) batch(gen, m, time uint64, data []byte) {
+	buf := []byte{ev2EventBatch}
+	for _, v := range []uint64{gen, m, time, uint64(len(data))} {
+		buf = appendVarint(buf, v)
+	}
+	w.Write(append(buf, data...))
+}
+
+// describe2 returns the type of each event with the goroutine it acts on.
+func describe2(events []*Event) string {
+	var s []string
+	for _, ev := range events {
+		g := ev.G
+		if target, ok := ev.TargetG(); ok {
+			g = target
+		}
+		s = append(s, fmt.Sprintf("%v %v", EventDescriptions[ev.Type].Name, g))
+	}
+	return strings.Join(s, ", ")
+}
+
+func TestParseGo122Generations(t *testing.T) {
+	// G 1 blocks in the first generation and is unblocked in the second,
+	// on another M and after the time it starts running again.
+	for _, ver := range []int{1022, 1023, 1025, 1026} {
+		w := newWriter2(ver)
+		w.gen(1, []string{"chan receive"}, nil,
+			mEvents{1, [][]uint64{
+				{ev2ProcStatus, 1, 0, proc2Running},
+				{ev2GoStatus, 1, 1, 1, go2Running},
+				{ev2GoCreate, 1, 2, 0, 0},
+				{ev2GoBlock, 1, 1, 0},
+				{ev2GoStart, 1, 2, 1},
+			}})
+		w.gen(2, nil, nil,
+			mEvents{1, [][]uint64{
+				{ev2ProcStatus, 1, 0, proc2Running},
+				{ev2GoStatus, 1, 2, 1, go2Running},
+				{ev2GoDestroy, 10},
+				{ev2GoStart, 10, 1, 2},
+				{ev2GoDestroy, 10},
+			}},
+			mEvents{2, [][]uint64{
+				{ev2GoStatus, 1, 1, 0, go2Waiting},
+				{ev2GoUnblock, 25, 1, 1, 0},
+			}})
+		events, err := Parse(w, nil)
+		if err != nil {
+			t.Errorf("version %v: failed to parse: %v", ver, err)
+			continue
+		}
+		want := "GoCreate 1, ProcStart 0, GoStart 1, GoCreate 2, GoBlockRecv 1, GoStart 2, " +
+			"GoEnd 2, GoUnblock 1, GoStart 1, GoEnd 1"
+		if got := describe2(events); got != want {
+			t.Errorf("version %v: got events\n\t%v\nwant\n\t%v", ver, got, want)
+		}
+		for i := 1; i < len(events); i++ {
+			if events[i].Ts < events[i-1].Ts {
+				t.Errorf("version %v: event %v at %v is before the preceding one at %v", ver, events[i], events[i].Ts, events[i-1].Ts)
+			}
+		}
+	}
+}
+
+func TestParseGo122Status(t *testing.T) {
+	// The goroutines that exist when tracing starts are created at the
+	// start of the trace and put in the state of their status events.
+	w := newWriter2(1023)
+	w.gen(1, []string{"main.f", "f.go"}, [][]uint64{{0x100, 1, 2, 7}},
+		mEvents{1, [][]uint64{
+			{ev2ProcStatus, 1, 0, proc2Running},
+			{ev2ProcStatus, 1, 1, proc2Idle},
+			{ev2GoStatus, 1, 1, 1, go2Running},
+			{ev2GoStatusStack, 1, 2, 0, go2Waiting, 1},
+			{ev2GoStatus, 1, 3, 0, go2Runnable},
+			{ev2GoStatus, 1, 4, 2, go2Syscall},
+			{ev2GoDestroy, 1},
+		}})
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	want := "GoCreate 1, GoCreate 2, GoWaiting 2, GoCreate 3, GoCreate 4, GoInSyscall 4, " +
+		"ProcStart 0, GoStart 1, GoEnd 1"
+	if got := describe2(events); got != want {
+		t.Errorf("got events\n\t%v\nwant\n\t%v", got, want)
+	}
+	for _, ev := range events {
+		if ev.Type != EvGoCreate {
+			continue
+		}
+		g, _ := ev.TargetG()
+		if ev.Ts != 0 {
+			t.Errorf("g %v is created at %v, want the start of the trace", g, ev.Ts)
+		}
+		// Only the stack of the status of g 2 is known.
+		id, _ := ev.CreateStackID()
+		if (id != 0) != (g == 2) {
+			t.Errorf("g %v has start stack id %v", g, id)
+		}
+	}
+}
+
+func TestParseGo123Switch(t *testing.T) {
+	// G 1 switches to g 2, which switches back and ends.
+	w := newWriter2(1023)
+	w.gen(1, nil, nil,
+		mEvents{1, [][]uint64{
+			{ev2ProcStatus, 1, 0, proc2Running},
+			{ev2GoStatus, 1, 1, 1, go2Running},
+			{ev2GoStatus, 1, 2, 0, go2Waiting},
+			{ev2GoSwitch, 1, 2, 1},
+			{ev2GoSwitchDestroy, 1, 1, 1},
+			{ev2GoDestroy, 1},
+		}})
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	want := "GoCreate 1, GoCreate 2, GoWaiting 2, ProcStart 0, GoStart 1, " +
+		"GoUnblock 2, GoBlock 1, GoStart 2, GoUnblock 1, GoEnd 2, GoStart 1, GoEnd 1"
+	if got := describe2(events); got != want {
+		t.Errorf("got events\n\t%v\nwant\n\t%v", got, want)
+	}
+}
+
+func TestParseGo122Errors(t *testing.T) {
+	start := mEvents{1, [][]uint64{
+		{ev2ProcStatus, 1, 0, proc2Running},
+		{ev2GoStatus, 1, 1, 1, go2Running},
+	}}
+	tests := []struct {
+		name string
+		gens [][]mEvents
+		kind ErrorKind
+		typ  byte // type of the offending event in the format
+	}{
+		{"not ready", [][]mEvents{{
+			start,
+			{2, [][]uint64{{ev2GoUnblock, 1, 1, 5, 0}}},
+		}}, KindOrdering, ev2GoUnblock},
+		{"unknown g", [][]mEvents{
+			{start},
+			{{1, [][]uint64{{ev2GoStatus, 1, 7, 0, go2Waiting}}}},
+		}, KindStateMachine, ev2GoStatus},
+		{"inconsistent status", [][]mEvents{
+			{start},
+			{{1, [][]uint64{{ev2GoStatus, 1, 1, 0, go2Waiting}}}},
+		}, KindStateMachine, ev2GoStatus},
+		{"switch without goroutine", [][]mEvents{{
+			{1, [][]uint64{{ev2ProcStatus, 1, 0, proc2Running}, {ev2GoSwitch, 1, 2, 1}}},
+		}}, KindStateMachine, ev2GoSwitch},
+		{"stop p of running goroutine", [][]mEvents{{
+			{1, append(start.events[:2:2], []uint64{ev2ProcStop, 1}, []uint64{ev2GoSyscallBegin, 1, 1, 0})},
+		}}, KindStateMachine, ev2ProcStop},
+		{"syscall without p", [][]mEvents{
+			{start},
+			{{2, [][]uint64{{ev2GoStatus, 1, 1, 2, go2Running}, {ev2GoSyscallBegin, 1, 1, 0}}}},
+		}, KindStateMachine, ev2GoSyscallBegin},
+	}
+	for _, tt := range tests {
+		w := newWriter2(1023)
+		for i, ms := range tt.gens {
+			w.gen(uint64(i+1), nil, nil, ms...)
+		}
+		data := append([]byte(nil), w.Bytes()...)
+		_, err := Parse(w, nil)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%v: got error %v, want a ParseError", tt.name, err)
+			continue
+		}
+		if perr.Kind != tt.kind {
+			t.Errorf("%v: got error %v of kind %v, want %v", tt.name, err, perr.Kind, tt.kind)
+		}
+		if perr.Off <= 0 || perr.Off >= len(data) || data[perr.Off] != tt.typ {
+			t.Errorf("%v: offset %v of error %v does not point to the event", tt.name, perr.Off, err)
+		}
+	}
+}
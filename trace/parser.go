@@ -7,14 +7,17 @@ package trace
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"os"
 	"os/exec"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	_ "unsafe"
 )
 
@@ -22,6 +25,7 @@ import (
 type Event struct {
 	Off   int       // offset in input file (for debugging and error reporting)
 	Type  byte      // one of Ev*
+	ver   uint16    // version of the trace, 0 if unknown
 	seq   int64     // sequence number
 	Ts    int64     // timestamp in nanoseconds
 	P     int       // P on which the event happened (can be one of TimerP, NetpollP, SyscallP)
@@ -29,10 +33,23 @@ type Event struct {
 	StkID uint64    // unique stack ID
 	Stk   []*Frame  // stack trace (can be empty)
 	Args  [3]uint64 // event-type-specific arguments
+	SArgs []string  // event-type-specific string args
+	// Futile is set on the events of a futile wakeup sequence,
+	// which are kept only with ParseOptions.KeepFutile.
+	Futile bool
+	// ClockAdjusted is set on events whose timestamp was repaired
+	// because of clock skew, with ParseOptions.RepairClockSkew.
+	ClockAdjusted bool
+	// Process is the index of the process of the event in a trace
+	// merged by Merge, and 0 otherwise.
+	Process int
 	// linked event (can be nil), depends on event type:
 	// for GCStart: the GCStop
-	// for GCScanStart: the GCScanDone
+	// for GCSTWStart: the GCSTWDone
 	// for GCSweepStart: the GCSweepDone
+	// for GCMarkAssistStart: the associated GCMarkAssistDone
+	// for UserTaskCreate: the UserTaskEnd
+	// for UserRegion: if the start region, the corresponding UserRegion end event
 	// for GoCreate: first GoStart of the created goroutine
 	// for GoStart: the associated GoEnd, GoBlock or other blocking event
 	// for GoSched/GoPreempt: the next GoStart
@@ -55,6 +72,9 @@ func (ev *Event) desc(w io.Writer) {
 	for i, a := range desc.Args {
 		fmt.Fprintf(w, " %v=%v", a, ev.Args[i])
 	}
+	for i, a := range desc.SArgs {
+		fmt.Fprintf(w, " %v=%v", a, ev.SArgs[i])
+	}
 }
 
 func (ev *Event) DebugString() string {
@@ -81,6 +101,7 @@ const (
 	TimerP   // depicts timer unblocks
 	NetpollP // depicts network unblocks
 	SyscallP // depicts returns from syscalls
+	GCP      // depicts GC state
 )
 
 type Symbolizer interface {
@@ -89,201 +110,359 @@ type Symbolizer interface {
 }
 
 // Parse parses, post-processes and verifies the trace.
+// The trace may be gzip- or zstd-compressed.
+// ParseOptions gives more control over parsing.
 func Parse(r io.Reader, symbolizer Symbolizer) ([]*Event, error) {
-	ver, events, err := parse(r, symbolizer)
-	if err != nil {
-		return nil, err
-	}
-	if ver < 1007 && symbolizer == nil {
-		return nil, fmt.Errorf("for traces produced by go 1.6 or below, the binary argument must be provided")
-	}
-	return events, nil
+	return (&ParseOptions{Symbolizer: symbolizer}).Parse(r)
+}
+
+// ParseBytes is like Parse but parses the trace in data.
+// The events do not refer to data.
+func ParseBytes(data []byte, symbolizer Symbolizer) ([]*Event, error) {
+	return (&ParseOptions{Symbolizer: symbolizer}).ParseBytes(data)
 }
 
-// parse parses, post-processes and verifies the trace. It returns the
-// trace version and the list of events.
-func parse(r io.Reader, symbolizer Symbolizer) (int, []*Event, error) {
-	log.Print("readTrace")
-	ver, rawEvents, strings, err := readTrace(r)
+// ParseFile is like Parse but parses the trace in the named file.
+// The file is mapped into memory where possible instead of being read.
+// If name is an http or https URL, the trace is fetched from it instead.
+func ParseFile(name string, symbolizer Symbolizer) ([]*Event, error) {
+	return (&ParseOptions{Symbolizer: symbolizer}).ParseFile(name)
+}
+
+// parseBytes parses, post-processes and verifies the trace in data,
+// with the options. It returns the trace version and the list of events.
+// If rec is not nil, it recovers from damage in the trace as described
+// by ParseLenient and records what it did in rec.
+func parseBytes(data []byte, opts *ParseOptions, rec *Recovery) (int, []*Event, error) {
+	if opts == nil {
+		opts = new(ParseOptions)
+	}
+	t := newTracker(opts, len(data))
+	var ver int
+	if len(data) >= 16 {
+		ver, _ = parseHeader(data[:16])
+	}
+	if ver >= 1022 {
+		return parseGenerations(ver, bytes.NewReader(data[16:]), len(data), opts, rec, t)
+	}
+	t.start("readTrace")
+	ver, rawEvents, strings, n, err := readTraceBytes(data, t)
+	t.done(n)
 	if err != nil {
-		return 0, nil, err
+		if rec == nil || len(rawEvents) == 0 {
+			return 0, nil, err
+		}
+		rec.fail(n, err)
 	}
-	log.Print("parseEvents")
-	events, stacks, err := parseEvents(ver, rawEvents, strings)
+	t.start("parseEvents")
+	events, stacks, err := parseEvents(ver, rawEvents, strings, opts, rec)
 	if err != nil {
 		return 0, nil, err
 	}
-	log.Print("removeFutile")
-	events, err = removeFutile(events)
+	if opts.KeepFutile {
+		t.start("markFutile")
+		markFutile(events)
+	} else {
+		t.start("removeFutile")
+		events, err = removeFutile(events)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+	return postProcess(ver, events, stacks, len(data), opts, rec, t)
+}
+
+// parseGenerations is parseBytes over a trace in the generation-based
+// format of Go 1.22 and later, which is decoded as it is read from r.
+// The trace header must be already consumed from r. size is the size of
+// the trace, which is needed only to recover from damage with rec.
+func parseGenerations(ver int, r byteReader, size int, opts *ParseOptions, rec *Recovery, t *tracker) (int, []*Event, error) {
+	// Futile wakeups are not traced by these versions.
+	t.start("readGenerations")
+	events, stacks, err := readGenerations(ver, r, rec, t)
 	if err != nil {
 		return 0, nil, err
 	}
-	log.Print("postProcessTrace")
-	err = postProcessTrace(ver, events)
-	if err != nil {
+	return postProcess(ver, events, stacks, size, opts, rec, t)
+}
+
+// postProcess verifies the ordered events, attaches their stack traces
+// and symbolizes them. size is the size of the trace.
+func postProcess(ver int, events []*Event, stacks map[uint64][]*Frame, size int, opts *ParseOptions, rec *Recovery, t *tracker) (int, []*Event, error) {
+	t.start("postProcessTrace")
+	if rec != nil {
+		events = postProcessLenient(ver, events, size, rec, t)
+	} else if err := postProcessTrace(ver, events, t); err != nil {
 		return 0, nil, err
 	}
-	log.Print("attach stack traces")
+	t.start("attach stack traces")
 	// Attach stack traces.
 	for _, ev := range events {
 		if ev.StkID != 0 {
 			ev.Stk = stacks[ev.StkID]
 		}
 	}
-	if ver < 1007 && symbolizer != nil {
-		if err := symbolize(events, symbolizer); err != nil {
+	if ver < 1007 && opts.Symbolizer != nil {
+		if err := symbolize(events, opts.Symbolizer); err != nil {
 			return 0, nil, err
 		}
 	}
-	log.Print("parse done")
+	t.start("parse done")
 	return ver, events, nil
 }
 
 // rawEvent is a helper type used during parsing.
 type rawEvent struct {
-	off  int
-	typ  byte
-	args []uint64
+	off   int
+	typ   byte
+	args  []uint64
+	sargs []string
 }
 
-// readTrace does wire-format parsing and verification.
-// It does not care about specific event types and argument meaning.
-func readTrace(r io.Reader) (ver int, events []rawEvent, strings map[uint64]string, err error) {
+// readTraceBytes does wire-format parsing and verification of the trace
+// in data. It does not care about specific event types and argument meaning.
+// The result does not refer to data, so data can be unmapped afterwards.
+// n is the length of the prefix of data that was decoded; on error,
+// events and strings hold what was decoded before the error.
+// The progress of decoding is reported to t.
+func readTraceBytes(data []byte, t *tracker) (ver int, events []rawEvent, strings map[uint64]string, n int, err error) {
+	ver, strings, n, err = decodeTrace(data, t, t.limiter(), func(ev rawEvent, end int) error {
+		events = append(events, ev)
+		return nil
+	})
+	return
+}
+
+// decodeTrace is readTraceBytes without keeping the events: it passes
+// each decoded event to f with the offset of its end, and fails with
+// the error of f. The resources are accounted to lim.
+func decodeTrace(data []byte, t *tracker, lim *limiter, f func(ev rawEvent, end int) error) (ver int, strings map[uint64]string, n int, err error) {
 	// Read and validate trace header.
-	var buf [16]byte
-	off, err := io.ReadFull(r, buf[:])
-	if err != nil {
-		err = fmt.Errorf("failed to read header: read %v, err %v", off, err)
+	if len(data) < 16 {
+		err = wireErrorf(0, EvNone, "failed to read header: read %v, err %v", len(data), io.ErrUnexpectedEOF)
 		return
 	}
-	ver, err = parseHeader(buf[:])
+	ver, err = parseHeader(data[:16])
 	if err != nil {
 		return
 	}
 	switch ver {
-	case 1005, 1007:
+	case 1005, 1007, 1008, 1009, 1010, 1011:
 		break
 	default:
-		err = fmt.Errorf("unsupported trace file version %v.%v (update Go toolchain) %v", ver/1000, ver%1000, ver)
+		err = wireErrorf(0, EvNone, "unsupported trace file version %v.%v (update Go toolchain) %v", ver/1000, ver%1000, ver)
+		return
+	}
+	if err = lim.alloc(0, EvNone, int64(len(data))); err != nil {
 		return
 	}
 
 	// Read events.
 	strings = make(map[uint64]string)
-	for {
-		// Read event type and number of arguments (1 byte).
-		off0 := off
-		var n int
-		n, err = r.Read(buf[:1])
-		if err == io.EOF {
-			err = nil
-			break
-		}
-		if err != nil || n != 1 {
-			err = fmt.Errorf("failed to read trace at offset 0x%x: n=%v err=%v", off0, n, err)
+	d := newRawDecoder(data, ver, lim, strings)
+	n = 16
+	for off := 16; off < len(data); n = off {
+		t.event(off)
+		var ev rawEvent
+		ev, off, err = d.read(off)
+		if err != nil {
 			return
 		}
-		off += n
-		typ := buf[0] << 2 >> 2
-		narg := buf[0]>>6 + 1
-		inlineArgs := byte(4)
-		if ver < 1007 {
-			narg++
-			inlineArgs++
+		if ev.typ == EvString {
+			continue
 		}
-		if typ == EvNone || typ >= EvCount || EventDescriptions[typ].minVersion > ver {
-			err = fmt.Errorf("unknown event type %v at offset 0x%x", typ, off0)
+		if err = f(ev, off); err != nil {
 			return
 		}
-		if typ == EvString {
-			// String dictionary entry [ID, length, string].
-			var id uint64
-			id, off, err = readVal(r, off)
-			if err != nil {
-				return
-			}
-			if id == 0 {
-				err = fmt.Errorf("string at offset %d has invalid id 0", off)
-				return
-			}
-			if strings[id] != "" {
-				err = fmt.Errorf("string at offset %d has duplicate id %v", off, id)
-				return
-			}
-			var ln uint64
-			ln, off, err = readVal(r, off)
-			if err != nil {
-				return
-			}
-			if ln == 0 {
-				err = fmt.Errorf("string at offset %d has invalid length 0", off)
-				return
-			}
-			if ln > 1e6 {
-				err = fmt.Errorf("string at offset %d has too large length %v", off, ln)
-				return
-			}
-			buf := make([]byte, ln)
-			var n int
-			n, err = io.ReadFull(r, buf)
-			if err != nil {
-				err = fmt.Errorf("failed to read trace at offset %d: read %v, want %v, error %v", off, n, ln, err)
+	}
+	return
+}
+
+// rawDecoder decodes the raw events of a trace of Go 1.21 or earlier.
+type rawDecoder struct {
+	data       []byte
+	ver        int
+	inlineArgs byte
+	lim        *limiter
+	strings    map[uint64]string // nil if strings are skipped
+	// Arguments of all events are allocated from one slab,
+	// most events have no more than 4 arguments.
+	slab []uint64
+}
+
+// newRawDecoder returns a decoder of the events in data, which accounts
+// the resources to lim and adds the strings of the trace to strings.
+// If strings is nil, the strings are skipped; they must have been
+// verified before.
+func newRawDecoder(data []byte, ver int, lim *limiter, strings map[uint64]string) *rawDecoder {
+	inlineArgs := byte(4)
+	if ver < 1007 {
+		inlineArgs++
+	}
+	return &rawDecoder{data: data, ver: ver, inlineArgs: inlineArgs, lim: lim, strings: strings}
+}
+
+// read decodes the event at offset off0 and returns it with the offset
+// of the next event. An EvString event is returned without arguments.
+func (d *rawDecoder) read(off0 int) (ev rawEvent, off int, err error) {
+	data, ver, lim := d.data, d.ver, d.lim
+	// Read event type and number of arguments (1 byte).
+	off = off0
+	typ := data[off] << 2 >> 2
+	narg := data[off]>>6 + 1
+	off++
+	if ver < 1007 {
+		narg++
+	}
+	if typ == EvNone || typ >= EvCount || EventDescriptions[typ].minVersion > ver {
+		err = wireErrorf(off0, typ, "unknown event type %v", typ)
+		return
+	}
+	ev = rawEvent{typ: typ, off: off0}
+	if typ == EvString {
+		// String dictionary entry [ID, length, string].
+		var id uint64
+		id, off, err = readVal(data, off)
+		if err != nil {
+			err = wireErrorf(off, typ, "failed to read string id: %v", err)
+			return
+		}
+		if d.strings != nil && id == 0 {
+			err = wireErrorf(off0, typ, "string has invalid id 0")
+			return
+		}
+		if d.strings != nil && d.strings[id] != "" {
+			err = wireErrorf(off0, typ, "string has duplicate id %v", id)
+			return
+		}
+		var ln uint64
+		ln, off, err = readVal(data, off)
+		if err != nil {
+			err = wireErrorf(off, typ, "failed to read string length: %v", err)
+			return
+		}
+		if ln == 0 {
+			err = wireErrorf(off0, typ, "string has invalid length 0")
+			return
+		}
+		if ln > 1e6 {
+			err = wireErrorf(off0, typ, "string has too large length %v", ln)
+			return
+		}
+		if uint64(len(data)-off) < ln {
+			err = wireErrorf(off, typ, "failed to read string: read %v, want %v, error %v", len(data)-off, ln, io.ErrUnexpectedEOF)
+			return
+		}
+		if d.strings != nil {
+			if err = lim.str(off0, ln); err != nil {
 				return
 			}
-			off += n
-			strings[id] = string(buf)
-			continue
+			d.strings[id] = string(data[off : off+int(ln)])
 		}
-		ev := rawEvent{typ: typ, off: off0}
-		if narg < inlineArgs {
-			for i := 0; i < int(narg); i++ {
-				var v uint64
-				v, off, err = readVal(r, off)
-				if err != nil {
-					err = fmt.Errorf("failed to read event %v argument at offset %v (%v)", typ, off, err)
-					return
-				}
-				ev.args = append(ev.args, v)
+		off += int(ln)
+		return
+	}
+	if narg < d.inlineArgs {
+		if cap(d.slab)-len(d.slab) < int(narg) {
+			d.slab = make([]uint64, 0, 4096)
+		}
+		ev.args = d.slab[len(d.slab) : len(d.slab)+int(narg) : len(d.slab)+int(narg)]
+		d.slab = d.slab[:len(d.slab)+int(narg)]
+		off, err = readVals(data, off, ev.args)
+		if err != nil {
+			err = wireErrorf(off, typ, "failed to read event %v argument: %v", typ, err)
+			return
+		}
+	} else {
+		// More than inlineArgs args, the first value is length of the event in bytes.
+		var v uint64
+		v, off, err = readVal(data, off)
+		if err != nil {
+			err = wireErrorf(off, typ, "failed to read event %v argument: %v", typ, err)
+			return
+		}
+		evLen := v
+		if uint64(len(data)-off) < evLen {
+			err = wireErrorf(off, typ, "failed to read event %v arguments: read %v, want %v, error %v", typ, len(data)-off, evLen, io.ErrUnexpectedEOF)
+			return
+		}
+		// Each argument ends with a byte without the continuation bit.
+		nval := 0
+		for _, b := range data[off : off+int(evLen)] {
+			if b < 0x80 {
+				nval++
 			}
-		} else {
-			// More than inlineArgs args, the first value is length of the event in bytes.
-			var v uint64
-			v, off, err = readVal(r, off)
+		}
+		if err = lim.alloc(off0, typ, int64(8*nval)); err != nil {
+			return
+		}
+		if nval > maxArgs(typ, ver) {
+			err = wireErrorf(off0, typ, "event has too many arguments: %v", nval)
+			return
+		}
+		ev.args = make([]uint64, 0, nval)
+		off1 := off
+		for evLen > uint64(off-off1) {
+			v, off, err = readVal(data, off)
 			if err != nil {
-				err = fmt.Errorf("failed to read event %v argument at offset %v (%v)", typ, off, err)
-				return
-			}
-			evLen := v
-			off1 := off
-			for evLen > uint64(off-off1) {
-				v, off, err = readVal(r, off)
-				if err != nil {
-					err = fmt.Errorf("failed to read event %v argument at offset %v (%v)", typ, off, err)
-					return
-				}
-				ev.args = append(ev.args, v)
-			}
-			if evLen != uint64(off-off1) {
-				err = fmt.Errorf("event has wrong length at offset 0x%x: want %v, got %v", off0, evLen, off-off1)
+				err = wireErrorf(off, typ, "failed to read event %v argument: %v", typ, err)
 				return
 			}
+			ev.args = append(ev.args, v)
+		}
+		if evLen != uint64(off-off1) {
+			err = wireErrorf(off0, typ, "event has wrong length: want %v, got %v", evLen, off-off1)
+			return
+		}
+	}
+	if ev.typ == EvUserLog {
+		// EvUserLog records are followed by a value string.
+		var s string
+		s, off, err = readStr(data, off)
+		if err != nil {
+			err = wireErrorf(off, typ, "failed to read event %v string: %v", typ, err)
+			return
+		}
+		if err = lim.str(off0, uint64(len(s))); err != nil {
+			return
+		}
+		ev.sargs = append(ev.sargs, s)
+	}
+	if err = lim.event(off0, typ); err != nil {
+		return
+	}
+	if typ == EvStack && len(ev.args) >= 2 {
+		if err = lim.stack(off0, ev.args[1]); err != nil {
+			return
 		}
-		events = append(events, ev)
 	}
 	return
 }
 
+// maxArgs returns the maximum number of arguments of an event of type typ
+// that is preceded by its length in the trace.
+func maxArgs(typ byte, ver int) int {
+	if typ == EvStack {
+		// Stacks have at most 1000 frames, see parseStacks.
+		if ver < 1007 {
+			return 2 + 1000
+		}
+		return 2 + 4*1000
+	}
+	return argNum(rawEvent{typ: typ}, ver)
+}
+
 // parseHeader parses trace header of the form "go 1.7 trace\x00\x00\x00\x00"
 // and returns parsed version as 1007.
 func parseHeader(buf []byte) (int, error) {
 	if len(buf) != 16 {
-		return 0, fmt.Errorf("bad header length")
+		return 0, wireErrorf(0, EvNone, "bad header length")
 	}
 	if buf[0] != 'g' || buf[1] != 'o' || buf[2] != ' ' ||
 		buf[3] < '1' || buf[3] > '9' ||
 		buf[4] != '.' ||
 		buf[5] < '1' || buf[5] > '9' {
-		return 0, fmt.Errorf("not a trace file")
+		return 0, wireErrorf(0, EvNone, "not a trace file")
 	}
 	ver := int(buf[5] - '0')
 	i := 0
@@ -292,42 +471,55 @@ func parseHeader(buf []byte) (int, error) {
 	}
 	ver += int(buf[3]-'0') * 1000
 	if !bytes.Equal(buf[6+i:], []byte(" trace\x00\x00\x00\x00")[:10-i]) {
-		return 0, fmt.Errorf("not a trace file")
+		return 0, wireErrorf(0, EvNone, "not a trace file")
 	}
 	return ver, nil
 }
 
 // Parse events transforms raw events into events.
 // It does analyze and verify per-event-type arguments.
-func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (events []*Event, stacks map[uint64][]*Frame, err error) {
-	var ticksPerSec, lastSeq, lastTs int64
-	var lastG, timerGoid uint64
-	var lastP int
-	lastGs := make(map[int]uint64) // last goroutine running on P
-	stacks = make(map[uint64][]*Frame)
-	batches := make(map[int][]*Event) // events by P
-	for _, raw := range rawEvents {
-		desc := EventDescriptions[raw.typ]
-		if desc.Name == "" {
-			err = fmt.Errorf("missing description for event type %v", raw.typ)
-			return
+//
+// Batches of different Ps and the stack table do not depend on each
+// other, so they are transformed concurrently. Errors are reported for
+// the first offending event in the trace, as if it was processed
+// sequentially.
+//
+// If rec is not nil, the events from the first offending one on are
+// dropped instead, and events are ordered even if the events they depend
+// on are missing.
+func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string, opts *ParseOptions, rec *Recovery) (events []*Event, stacks map[uint64][]*Frame, err error) {
+	var ticksPerSec int64
+	var timerGoid uint64
+	var first firstError
+
+	// Split events into batches of Ps and handle events that are not
+	// part of the batches. Events before the first EvBatch belong to P 0.
+	var segs []segment
+	seg := segment{}
+	var stackEvents []int
+	n := len(rawEvents)
+loop:
+	for i, raw := range rawEvents {
+		switch raw.typ {
+		case EvBatch, EvFrequency, EvTimerGoroutine, EvStack:
+		default:
+			continue
 		}
-		narg := argNum(raw, ver)
-		if len(raw.args) != narg {
-			err = fmt.Errorf("%v has wrong number of arguments at offset 0x%x: want %v, got %v",
-				desc.Name, raw.off, narg, len(raw.args))
-			return
+		if err := checkArgNum(raw, ver); err != nil {
+			first.set(raw.off, err)
+			n = i
+			break loop
 		}
 		switch raw.typ {
 		case EvBatch:
-			lastGs[lastP] = lastG
-			lastP = int(raw.args[0])
-			lastG = lastGs[lastP]
+			seg.end = i
+			segs = append(segs, seg)
+			seg = segment{p: int(raw.args[0]), start: i + 1}
 			if ver < 1007 {
-				lastSeq = int64(raw.args[1])
-				lastTs = int64(raw.args[2])
+				seg.seq = int64(raw.args[1])
+				seg.ts = int64(raw.args[2])
 			} else {
-				lastTs = int64(raw.args[1])
+				seg.ts = int64(raw.args[1])
 			}
 		case EvFrequency:
 			ticksPerSec = int64(raw.args[0])
@@ -335,92 +527,95 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 				// The most likely cause for this is tick skew on different CPUs.
 				// For example, solaris/amd64 seems to have wildly different
 				// ticks on different CPUs.
-				err = ErrTimeOrder
-				return
+				first.set(raw.off, &ParseError{Kind: KindTimeOrder, Off: raw.off, Type: raw.typ, P: -1,
+					Msg: fmt.Sprintf("time stamps out of order: frequency %v is not positive", ticksPerSec)})
+				n = i
+				break loop
 			}
 		case EvTimerGoroutine:
 			timerGoid = raw.args[0]
 		case EvStack:
-			if len(raw.args) < 2 {
-				err = fmt.Errorf("EvStack has wrong number of arguments at offset 0x%x: want at least 2, got %v",
-					raw.off, len(raw.args))
-				return
-			}
-			size := raw.args[1]
-			if size > 1000 {
-				err = fmt.Errorf("EvStack has bad number of frames at offset 0x%x: %v",
-					raw.off, size)
-				return
-			}
-			want := 2 + 4*size
-			if ver < 1007 {
-				want = 2 + size
-			}
-			if uint64(len(raw.args)) != want {
-				err = fmt.Errorf("EvStack has wrong number of arguments at offset 0x%x: want %v, got %v",
-					raw.off, want, len(raw.args))
-				return
-			}
-			id := raw.args[0]
-			if id != 0 && size > 0 {
-				stk := make([]*Frame, size)
-				for i := 0; i < int(size); i++ {
-					if ver < 1007 {
-						stk[i] = &Frame{PC: raw.args[2+i]}
-					} else {
-						pc := raw.args[2+i*4+0]
-						fn := raw.args[2+i*4+1]
-						file := raw.args[2+i*4+2]
-						line := raw.args[2+i*4+3]
-						stk[i] = &Frame{PC: pc, Fn: strings[fn], File: strings[file], Line: int(line)}
-					}
-				}
-				stacks[id] = stk
-			}
-		default:
-			e := &Event{Off: raw.off, Type: raw.typ, P: lastP, G: lastG}
-			var argOffset int
-			if ver < 1007 {
-				e.seq = lastSeq + int64(raw.args[0])
-				e.Ts = lastTs + int64(raw.args[1])
-				lastSeq = e.seq
-				argOffset = 2
-			} else {
-				e.Ts = lastTs + int64(raw.args[0])
-				argOffset = 1
-			}
-			lastTs = e.Ts
-			for i := argOffset; i < narg; i++ {
-				if i == narg-1 && desc.Stack {
-					e.StkID = raw.args[i]
-				} else {
-					e.Args[i-argOffset] = raw.args[i]
-				}
-			}
-			switch raw.typ {
-			case EvGoStart, EvGoStartLocal:
-				lastG = e.Args[0]
-				e.G = lastG
-			case EvGCStart, EvGCDone, EvGCScanStart, EvGCScanDone:
-				e.G = 0
-			case EvGoEnd, EvGoStop, EvGoSched, EvGoPreempt,
-				EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
-				EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet,
-				EvGoSysBlock:
-				lastG = 0
-			case EvGoSysExit, EvGoWaiting, EvGoInSyscall:
-				e.G = e.Args[0]
-			}
-			batches[lastP] = append(batches[lastP], e)
+			stackEvents = append(stackEvents, i)
+		}
+	}
+	seg.end = n
+	segs = append(segs, seg)
+
+	pSegs := make(map[int][]segment)
+	var ps []int
+	for _, seg := range segs {
+		if _, ok := pSegs[seg.p]; !ok {
+			ps = append(ps, seg.p)
+		}
+		pSegs[seg.p] = append(pSegs[seg.p], seg)
+	}
+	sort.Ints(ps)
+
+	workers := runtime.GOMAXPROCS(0)
+	sem := make(chan bool, workers)
+	var wg sync.WaitGroup
+	pEvents := make([][]*Event, len(ps))
+	for i, p := range ps {
+		wg.Add(1)
+		go func(i int, segs []segment) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			pEvents[i] = parseBatches(ver, rawEvents, segs, strings, &first)
+		}(i, pSegs[p])
+	}
+	chunk := (len(stackEvents) + workers - 1) / workers
+	var chunkStacks []map[uint64][]*Frame
+	for start := 0; start < len(stackEvents); start += chunk {
+		end := start + chunk
+		if end > len(stackEvents) {
+			end = len(stackEvents)
+		}
+		m := make(map[uint64][]*Frame)
+		chunkStacks = append(chunkStacks, m)
+		wg.Add(1)
+		go func(indices []int) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			parseStacks(ver, rawEvents, indices, strings, m, &first)
+		}(stackEvents[start:end])
+	}
+	wg.Wait()
+	if first.err != nil {
+		if rec == nil {
+			err = first.err
+			return
+		}
+		rec.fail(first.off, first.err)
+		i := sort.Search(len(rawEvents), func(i int) bool { return rawEvents[i].off >= first.off })
+		return parseEvents(ver, rawEvents[:i], strings, opts, rec)
+	}
+
+	stacks = make(map[uint64][]*Frame)
+	for _, m := range chunkStacks {
+		for id, stk := range m {
+			stacks[id] = stk
+		}
+	}
+	batches := make(map[int][]*Event) // events by P
+	for i, p := range ps {
+		if len(pEvents[i]) != 0 {
+			batches[p] = pEvents[i]
 		}
 	}
 	if len(batches) == 0 {
-		err = fmt.Errorf("trace is empty")
+		err = &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: "trace is empty"}
 		return
 	}
 	if ticksPerSec == 0 {
-		err = fmt.Errorf("no EvFrequency event")
-		return
+		if rec == nil {
+			err = &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: "no EvFrequency event"}
+			return
+		}
+		// The frequency is written at the end of the trace.
+		ticksPerSec = 1e9
+		rec.NoFrequency = true
 	}
 	if BreakTimestampsForTesting {
 		var batchArr [][]*Event
@@ -433,9 +628,9 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 		}
 	}
 	if ver < 1007 {
-		events, err = order1005(batches)
+		events, err = order1005(batches, opts.RepairClockSkew)
 	} else {
-		events, err = order1007(batches)
+		events, err = order1007(batches, opts.RepairClockSkew, rec)
 	}
 	if err != nil {
 		return
@@ -455,56 +650,210 @@ func parseEvents(ver int, rawEvents []rawEvent, strings map[uint64]string) (even
 			ev.P = SyscallP
 		}
 	}
+	if rec != nil {
+		// Violations found while ordering have timestamps in ticks.
+		for _, v := range rec.Violations {
+			if v.ev != nil {
+				v.Ts, v.ev = v.ev.Ts, nil
+			}
+		}
+	}
 
 	return
 }
 
-// removeFutile removes all constituents of futile wakeups (block, unblock, start).
-// For example, a goroutine was unblocked on a mutex, but another goroutine got
-// ahead and acquired the mutex before the first goroutine is scheduled,
-// so the first goroutine has to block again. Such wakeups happen on buffered
-// channels and sync.Mutex, but are generally not interesting for end user.
-func removeFutile(events []*Event) ([]*Event, error) {
-	// Two non-trivial aspects:
-	// 1. A goroutine can be preempted during a futile wakeup and migrate to another P.
-	//	We want to remove all of that.
-	// 2. Tracing can start in the middle of a futile wakeup.
-	//	That is, we can see a futile wakeup event w/o the actual wakeup before it.
-	// postProcessTrace runs after us and ensures that we leave the trace in a consistent state.
-
-	// Phase 1: determine futile wakeup sequences.
-	type G struct {
-		futile bool
-		wakeup []*Event // wakeup sequence (subject for removal)
-	}
-	gs := make(map[uint64]G)
-	futile := make(map[*Event]bool)
-	for _, ev := range events {
-		switch ev.Type {
-		case EvGoUnblock:
-			g := gs[ev.Args[0]]
-			g.wakeup = []*Event{ev}
-			gs[ev.Args[0]] = g
-		case EvGoStart, EvGoPreempt, EvFutileWakeup:
-			g := gs[ev.G]
-			g.wakeup = append(g.wakeup, ev)
-			if ev.Type == EvFutileWakeup {
-				g.futile = true
+// segment is a range of raw events of a batch of P p.
+type segment struct {
+	p          int
+	start, end int   // range of raw events
+	seq, ts    int64 // sequence number (before 1.7) and timestamp of the batch
+}
+
+// firstError keeps the error of the event with the lowest offset.
+type firstError struct {
+	mu  sync.Mutex
+	off int
+	err error
+}
+
+func (e *firstError) set(off int, err error) {
+	e.mu.Lock()
+	if e.err == nil || off < e.off {
+		e.off, e.err = off, err
+	}
+	e.mu.Unlock()
+}
+
+// checkArgNum verifies the number of arguments of a raw event.
+func checkArgNum(raw rawEvent, ver int) error {
+	desc := EventDescriptions[raw.typ]
+	if desc.Name == "" {
+		return wireErrorf(raw.off, raw.typ, "missing description for event type %v", raw.typ)
+	}
+	narg := argNum(raw, ver)
+	if len(raw.args) != narg {
+		return wireErrorf(raw.off, raw.typ, "%v has wrong number of arguments: want %v, got %v",
+			desc.Name, narg, len(raw.args))
+	}
+	return nil
+}
+
+// parseBatches transforms the raw events of the batches of a P into events.
+// On error, it records the error in first and returns the events before it.
+func parseBatches(ver int, rawEvents []rawEvent, segs []segment, strings map[uint64]string, first *firstError) (events []*Event) {
+	bp := &batchParser{ver: ver, strings: strings}
+	for _, seg := range segs {
+		bp.start(seg)
+		for _, raw := range rawEvents[seg.start:seg.end] {
+			e, err := bp.event(raw)
+			if err != nil {
+				first.set(raw.off, err)
+				return
 			}
-			gs[ev.G] = g
-		case EvGoBlock, EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond:
-			g := gs[ev.G]
-			if g.futile {
-				futile[ev] = true
-				for _, ev1 := range g.wakeup {
-					futile[ev1] = true
+			if e != nil {
+				events = append(events, e)
+			}
+		}
+	}
+	return
+}
+
+// batchParser transforms the raw events of the batches of a P into
+// events, one at a time.
+type batchParser struct {
+	ver     int
+	strings map[uint64]string
+	p       int
+	lastG   uint64 // last goroutine running on P
+	lastSeq int64
+	lastTs  int64
+}
+
+// start starts the batch of segment seg.
+func (bp *batchParser) start(seg segment) {
+	bp.p, bp.lastSeq, bp.lastTs = seg.p, seg.seq, seg.ts
+}
+
+// event transforms raw into an event. It returns nil for the raw events
+// that are not part of the batch.
+func (bp *batchParser) event(raw rawEvent) (*Event, error) {
+	ver := bp.ver
+	switch raw.typ {
+	case EvFrequency, EvTimerGoroutine, EvStack, EvString:
+		return nil, nil
+	}
+	if err := checkArgNum(raw, ver); err != nil {
+		return nil, err
+	}
+	desc := EventDescriptions[raw.typ]
+	narg := argNum(raw, ver)
+	e := &Event{Off: raw.off, Type: raw.typ, ver: uint16(ver), P: bp.p, G: bp.lastG}
+	var argOffset int
+	if ver < 1007 {
+		e.seq = bp.lastSeq + int64(raw.args[0])
+		e.Ts = bp.lastTs + int64(raw.args[1])
+		bp.lastSeq = e.seq
+		argOffset = 2
+	} else {
+		e.Ts = bp.lastTs + int64(raw.args[0])
+		argOffset = 1
+	}
+	bp.lastTs = e.Ts
+	for i := argOffset; i < narg; i++ {
+		if i == narg-1 && desc.Stack {
+			e.StkID = raw.args[i]
+		} else {
+			e.Args[i-argOffset] = raw.args[i]
+		}
+	}
+	switch raw.typ {
+	case EvGoStart, EvGoStartLocal, EvGoStartLabel:
+		bp.lastG = e.Args[0]
+		e.G = bp.lastG
+		if raw.typ == EvGoStartLabel {
+			e.SArgs = []string{bp.strings[e.Args[2]]}
+		}
+	case EvGCSTWStart:
+		e.G = 0
+		switch e.Args[0] {
+		case 0:
+			e.SArgs = []string{"mark termination"}
+		case 1:
+			e.SArgs = []string{"sweep termination"}
+		default:
+			return nil, wireErrorf(raw.off, raw.typ, "unknown STW kind %d", e.Args[0])
+		}
+	case EvGCStart, EvGCDone, EvGCSTWDone:
+		e.G = 0
+	case EvGoEnd, EvGoStop, EvGoSched, EvGoPreempt,
+		EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet,
+		EvGoSysBlock, EvGoBlockGC:
+		bp.lastG = 0
+	case EvGoSysExit, EvGoWaiting, EvGoInSyscall:
+		e.G = e.Args[0]
+	case EvUserTaskCreate:
+		// e.Args 0: taskID, 1: parentID, 2: nameID
+		e.SArgs = []string{bp.strings[e.Args[2]]}
+	case EvUserRegion:
+		// e.Args 0: taskID, 1: mode, 2: nameID
+		e.SArgs = []string{bp.strings[e.Args[2]]}
+	case EvUserLog:
+		// e.Args 0: taskID, 1: keyID
+		e.SArgs = []string{bp.strings[e.Args[1]], raw.sargs[0]}
+	}
+	return e, nil
+}
+
+// parseStacks adds the stacks of the EvStack raw events at the indices to stacks.
+func parseStacks(ver int, rawEvents []rawEvent, indices []int, strings map[uint64]string, stacks map[uint64][]*Frame, first *firstError) {
+	for _, i := range indices {
+		raw := rawEvents[i]
+		if len(raw.args) < 2 {
+			first.set(raw.off, wireErrorf(raw.off, raw.typ, "EvStack has wrong number of arguments: want at least 2, got %v",
+				len(raw.args)))
+			return
+		}
+		size := raw.args[1]
+		if size > 1000 {
+			first.set(raw.off, wireErrorf(raw.off, raw.typ, "EvStack has bad number of frames: %v", size))
+			return
+		}
+		want := 2 + 4*size
+		if ver < 1007 {
+			want = 2 + size
+		}
+		if uint64(len(raw.args)) != want {
+			first.set(raw.off, wireErrorf(raw.off, raw.typ, "EvStack has wrong number of arguments: want %v, got %v",
+				want, len(raw.args)))
+			return
+		}
+		id := raw.args[0]
+		if id != 0 && size > 0 {
+			stk := make([]*Frame, size)
+			for i := 0; i < int(size); i++ {
+				if ver < 1007 {
+					stk[i] = &Frame{PC: raw.args[2+i]}
+				} else {
+					pc := raw.args[2+i*4+0]
+					fn := raw.args[2+i*4+1]
+					file := raw.args[2+i*4+2]
+					line := raw.args[2+i*4+3]
+					stk[i] = &Frame{PC: pc, Fn: strings[fn], File: strings[file], Line: int(line)}
 				}
 			}
-			delete(gs, ev.G)
+			stacks[id] = stk
 		}
 	}
+}
 
-	// Phase 2: remove futile wakeup sequences.
+// removeFutile removes all constituents of futile wakeups (block, unblock, start).
+// For example, a goroutine was unblocked on a mutex, but another goroutine got
+// ahead and acquired the mutex before the first goroutine is scheduled,
+// so the first goroutine has to block again. Such wakeups happen on buffered
+// channels and sync.Mutex, but are generally not interesting for end user.
+func removeFutile(events []*Event) ([]*Event, error) {
+	futile := futileWakeups(events)
 	newEvents := events[:0] // overwrite the original slice
 	for _, ev := range events {
 		if !futile[ev] {
@@ -514,224 +863,385 @@ func removeFutile(events []*Event) ([]*Event, error) {
 	return newEvents, nil
 }
 
+// markFutile sets Futile on all constituents of futile wakeups
+// instead of removing them.
+func markFutile(events []*Event) {
+	for ev := range futileWakeups(events) {
+		ev.Futile = true
+	}
+}
+
+// futileWakeups returns the constituents of futile wakeups.
+func futileWakeups(events []*Event) map[*Event]bool {
+	var ft futileTracker
+	futile := make(map[*Event]bool)
+	for _, ev := range events {
+		for _, ev1 := range ft.add(ev) {
+			futile[ev1] = true
+		}
+	}
+	return futile
+}
+
+// futileTracker finds futile wakeups in the events of a trace, which it
+// is given one at a time.
+//
+// Two non-trivial aspects:
+//  1. A goroutine can be preempted during a futile wakeup and migrate to another P.
+//     We want to remove all of that.
+//  2. Tracing can start in the middle of a futile wakeup.
+//     That is, we can see a futile wakeup event w/o the actual wakeup before it.
+//
+// postProcessTrace runs after us and ensures that we leave the trace in a consistent state.
+type futileTracker struct {
+	gs map[uint64]futileG
+}
+
+type futileG struct {
+	futile bool
+	wakeup []*Event // wakeup sequence (subject for removal)
+}
+
+// add adds the next event, and returns the constituents of the futile
+// wakeup that ev ends, if any.
+func (ft *futileTracker) add(ev *Event) []*Event {
+	if ft.gs == nil {
+		ft.gs = make(map[uint64]futileG)
+	}
+	switch ev.Type {
+	case EvGoUnblock:
+		g := ft.gs[ev.Args[0]]
+		g.wakeup = []*Event{ev}
+		ft.gs[ev.Args[0]] = g
+	case EvGoStart, EvGoStartLabel, EvGoPreempt, EvFutileWakeup:
+		g := ft.gs[ev.G]
+		g.wakeup = append(g.wakeup, ev)
+		if ev.Type == EvFutileWakeup {
+			g.futile = true
+		}
+		ft.gs[ev.G] = g
+	case EvGoBlock, EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond:
+		g := ft.gs[ev.G]
+		delete(ft.gs, ev.G)
+		if g.futile {
+			return append(g.wakeup, ev)
+		}
+	}
+	return nil
+}
+
 // ErrTimeOrder is returned by Parse when the trace contains
 // time stamps that do not respect actual event ordering.
-var ErrTimeOrder = fmt.Errorf("time stamps out of order")
+// The error returned is a ParseError of KindTimeOrder that locates the
+// offending event and matches ErrTimeOrder with errors.Is.
+var ErrTimeOrder error = &ParseError{Kind: KindTimeOrder, Off: -1, P: -1, Msg: "time stamps out of order"}
 
 // postProcessTrace does inter-event verification and information restoration.
 // The resulting trace is guaranteed to be consistent
 // (for example, a P does not run two Gs at the same time, or a G is indeed
 // blocked before an unblock event).
-func postProcessTrace(ver int, events []*Event) error {
-	const (
-		gDead = iota
-		gRunnable
-		gRunning
-		gWaiting
-	)
-	type gdesc struct {
-		state    int
-		ev       *Event
-		evStart  *Event
-		evCreate *Event
-	}
-	type pdesc struct {
-		running bool
-		g       uint64
-		evScan  *Event
-		evSweep *Event
-	}
-
-	gs := make(map[uint64]gdesc)
-	ps := make(map[int]pdesc)
-	gs[0] = gdesc{state: gRunning}
-	var evGC *Event
-
-	checkRunning := func(p pdesc, g gdesc, ev *Event, allowG0 bool) error {
-		name := EventDescriptions[ev.Type].Name
-		if g.state != gRunning {
-			return fmt.Errorf("g %v is not running while %v (offset %v, time %v)", ev.G, name, ev.Off, ev.Ts)
-		}
-		if p.g != ev.G {
-			return fmt.Errorf("p %v is not running g %v while %v (offset %v, time %v)", ev.P, ev.G, name, ev.Off, ev.Ts)
-		}
-		if !allowG0 && ev.G == 0 {
-			return fmt.Errorf("g 0 did %v (offset %v, time %v)", EventDescriptions[ev.Type].Name, ev.Off, ev.Ts)
+func postProcessTrace(ver int, events []*Event, t *tracker) error {
+	pp := newPostProcessor(ver)
+	for _, ev := range events {
+		if err := pp.process(ev); err != nil {
+			return err
 		}
-		return nil
+		t.event(-1)
 	}
 
-	for _, ev := range events {
-		g := gs[ev.G]
-		p := ps[ev.P]
+	// TODO(dvyukov): restore stacks for EvGoStart events.
+	// TODO(dvyukov): test that all EvGoStart events has non-nil Link.
 
-		switch ev.Type {
-		case EvProcStart:
-			if p.running {
-				return fmt.Errorf("p %v is running before start (offset %v, time %v)", ev.P, ev.Off, ev.Ts)
-			}
-			p.running = true
-		case EvProcStop:
-			if !p.running {
-				return fmt.Errorf("p %v is not running before stop (offset %v, time %v)", ev.P, ev.Off, ev.Ts)
-			}
-			if p.g != 0 {
-				return fmt.Errorf("p %v is running a goroutine %v during stop (offset %v, time %v)", ev.P, p.g, ev.Off, ev.Ts)
-			}
-			p.running = false
-		case EvGCStart:
-			if evGC != nil {
-				return fmt.Errorf("previous GC is not ended before a new one (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			evGC = ev
-		case EvGCDone:
-			if evGC == nil {
-				return fmt.Errorf("bogus GC end (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			evGC.Link = ev
-			evGC = nil
-		case EvGCScanStart:
-			if p.evScan != nil {
-				return fmt.Errorf("previous scanning is not ended before a new one (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			p.evScan = ev
-		case EvGCScanDone:
-			if p.evScan == nil {
-				return fmt.Errorf("bogus scanning end (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			p.evScan.Link = ev
-			p.evScan = nil
-		case EvGCSweepStart:
-			if p.evSweep != nil {
-				return fmt.Errorf("previous sweeping is not ended before a new one (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			p.evSweep = ev
-		case EvGCSweepDone:
-			if p.evSweep == nil {
-				return fmt.Errorf("bogus sweeping end (offset %v, time %v)", ev.Off, ev.Ts)
-			}
-			p.evSweep.Link = ev
-			p.evSweep = nil
-		case EvGoWaiting:
-			if g.state != gRunnable {
-				return fmt.Errorf("g %v is not runnable before EvGoWaiting (offset %v, time %v)", ev.G, ev.Off, ev.Ts)
-			}
-			g.state = gWaiting
-		case EvGoInSyscall:
-			if g.state != gRunnable {
-				return fmt.Errorf("g %v is not runnable before EvGoInSyscall (offset %v, time %v)", ev.G, ev.Off, ev.Ts)
-			}
-			g.state = gWaiting
-		case EvGoCreate:
-			if err := checkRunning(p, g, ev, true); err != nil {
-				return err
-			}
-			if _, ok := gs[ev.Args[0]]; ok {
-				return fmt.Errorf("g %v already exists (offset %v, time %v)", ev.Args[0], ev.Off, ev.Ts)
-			}
-			gs[ev.Args[0]] = gdesc{state: gRunnable, ev: ev, evCreate: ev}
-		case EvGoStart:
-			if g.state != gRunnable {
-				return fmt.Errorf("g %v is not runnable before start (offset %v, time %v)", ev.G, ev.Off, ev.Ts)
-			}
-			if p.g != 0 {
-				return fmt.Errorf("p %v is already running g %v while start g %v (offset %v, time %v)", ev.P, p.g, ev.G, ev.Off, ev.Ts)
-			}
-			g.state = gRunning
-			g.evStart = ev
-			p.g = ev.G
-			if g.evCreate != nil {
-				if ver < 1007 {
-					// +1 because symbolizer expects return pc.
-					ev.Stk = []*Frame{{PC: g.evCreate.Args[1] + 1}}
-				} else {
-					ev.StkID = g.evCreate.Args[1]
-				}
-				g.evCreate = nil
-			}
+	return nil
+}
 
-			if g.ev != nil {
-				g.ev.Link = ev
-				g.ev = nil
-			}
-		case EvGoEnd, EvGoStop:
-			if err := checkRunning(p, g, ev, false); err != nil {
-				return err
-			}
-			g.evStart.Link = ev
-			g.evStart = nil
-			g.state = gDead
-			p.g = 0
-		case EvGoSched, EvGoPreempt:
-			if err := checkRunning(p, g, ev, false); err != nil {
-				return err
-			}
-			g.state = gRunnable
-			g.evStart.Link = ev
-			g.evStart = nil
-			p.g = 0
-			g.ev = ev
-		case EvGoUnblock:
-			if g.state != gRunning {
-				return fmt.Errorf("g %v is not running while unpark (offset %v, time %v)", ev.G, ev.Off, ev.Ts)
-			}
-			if ev.P != TimerP && p.g != ev.G {
-				return fmt.Errorf("p %v is not running g %v while unpark (offset %v, time %v)", ev.P, ev.G, ev.Off, ev.Ts)
-			}
-			g1 := gs[ev.Args[0]]
-			if g1.state != gWaiting {
-				return fmt.Errorf("g %v is not waiting before unpark (offset %v, time %v)", ev.Args[0], ev.Off, ev.Ts)
-			}
-			if g1.ev != nil && g1.ev.Type == EvGoBlockNet && ev.P != TimerP {
-				ev.P = NetpollP
-			}
-			if g1.ev != nil {
-				g1.ev.Link = ev
-			}
-			g1.state = gRunnable
-			g1.ev = ev
-			gs[ev.Args[0]] = g1
-		case EvGoSysCall:
-			if err := checkRunning(p, g, ev, false); err != nil {
-				return err
-			}
-			g.ev = ev
-		case EvGoSysBlock:
-			if err := checkRunning(p, g, ev, false); err != nil {
-				return err
-			}
-			g.state = gWaiting
-			g.evStart.Link = ev
-			g.evStart = nil
-			p.g = 0
-		case EvGoSysExit:
-			if g.state != gWaiting {
-				return fmt.Errorf("g %v is not waiting during syscall exit (offset %v, time %v)", ev.G, ev.Off, ev.Ts)
+// Goroutine states tracked by postProcessor.
+const (
+	ppDead = iota
+	ppRunnable
+	ppRunning
+	ppWaiting
+)
+
+// postProcessor does the work of postProcessTrace one event at a time,
+// so that events can be verified as they are read by Reader.
+// Link of an event is set when the event it links to is processed.
+type postProcessor struct {
+	ver           int
+	gs            map[uint64]ppGDesc
+	ps            map[int]ppPDesc
+	tasks         map[uint64]*Event   // task id to task creation events
+	activeRegions map[uint64][]*Event // goroutine id to stack of regions
+	evGC, evSTW   *Event
+}
+
+type ppGDesc struct {
+	state        int
+	ev           *Event
+	evStart      *Event
+	evCreate     *Event
+	evMarkAssist *Event
+}
+
+type ppPDesc struct {
+	running bool
+	g       uint64
+	evSTW   *Event
+	evSweep *Event
+}
+
+func newPostProcessor(ver int) *postProcessor {
+	pp := &postProcessor{
+		ver:           ver,
+		gs:            make(map[uint64]ppGDesc),
+		ps:            make(map[int]ppPDesc),
+		tasks:         make(map[uint64]*Event),
+		activeRegions: make(map[uint64][]*Event),
+	}
+	pp.gs[0] = ppGDesc{state: ppRunning}
+	return pp
+}
+
+func (pp *postProcessor) checkRunning(p ppPDesc, g ppGDesc, ev *Event, allowG0 bool) error {
+	name := EventDescriptions[ev.Type].Name
+	if g.state != ppRunning {
+		return ev.errorf("g %v is not running while %v", ev.G, name)
+	}
+	if p.g != ev.G {
+		return ev.errorf("p %v is not running g %v while %v", ev.P, ev.G, name)
+	}
+	if !allowG0 && ev.G == 0 {
+		return ev.errorf("g 0 did %v", EventDescriptions[ev.Type].Name)
+	}
+	return nil
+}
+
+// process verifies ev against the events processed before it.
+func (pp *postProcessor) process(ev *Event) error {
+	// ev.P may be changed to a fake P below; p is the state of the
+	// P the event happened on.
+	pid := ev.P
+	g := pp.gs[ev.G]
+	p := pp.ps[pid]
+
+	switch ev.Type {
+	case EvProcStart:
+		if p.running {
+			return ev.errorf("p %v is running before start", ev.P)
+		}
+		p.running = true
+	case EvProcStop:
+		if !p.running {
+			return ev.errorf("p %v is not running before stop", ev.P)
+		}
+		if p.g != 0 {
+			return ev.errorf("p %v is running a goroutine %v during stop", ev.P, p.g)
+		}
+		p.running = false
+	case EvGCStart:
+		if pp.evGC != nil {
+			return ev.errorf("previous GC is not ended before a new one")
+		}
+		pp.evGC = ev
+		// Attribute this to the global GC state.
+		ev.P = GCP
+	case EvGCDone:
+		if pp.evGC == nil {
+			return ev.errorf("bogus GC end")
+		}
+		pp.evGC.Link = ev
+		pp.evGC = nil
+	case EvGCSTWStart:
+		evp := &pp.evSTW
+		if pp.ver < 1010 {
+			// Before 1.10, EvGCSTWStart was per-P.
+			evp = &p.evSTW
+		}
+		if *evp != nil {
+			return ev.errorf("previous STW is not ended before a new one")
+		}
+		*evp = ev
+	case EvGCSTWDone:
+		evp := &pp.evSTW
+		if pp.ver < 1010 {
+			// Before 1.10, EvGCSTWDone was per-P.
+			evp = &p.evSTW
+		}
+		if *evp == nil {
+			return ev.errorf("bogus STW end")
+		}
+		(*evp).Link = ev
+		*evp = nil
+	case EvGCMarkAssistStart:
+		if g.evMarkAssist != nil {
+			return ev.errorf("previous mark assist is not ended before a new one")
+		}
+		g.evMarkAssist = ev
+	case EvGCMarkAssistDone:
+		// Unlike most events, mark assists can be in progress when a
+		// goroutine starts tracing, so we can't report an error here.
+		if g.evMarkAssist != nil {
+			g.evMarkAssist.Link = ev
+			g.evMarkAssist = nil
+		}
+	case EvGCSweepStart:
+		if p.evSweep != nil {
+			return ev.errorf("previous sweeping is not ended before a new one")
+		}
+		p.evSweep = ev
+	case EvGCSweepDone:
+		if p.evSweep == nil {
+			return ev.errorf("bogus sweeping end")
+		}
+		p.evSweep.Link = ev
+		p.evSweep = nil
+	case EvGoWaiting:
+		if g.state != ppRunnable {
+			return ev.errorf("g %v is not runnable before EvGoWaiting", ev.G)
+		}
+		g.state = ppWaiting
+	case EvGoInSyscall:
+		if g.state != ppRunnable {
+			return ev.errorf("g %v is not runnable before EvGoInSyscall", ev.G)
+		}
+		g.state = ppWaiting
+	case EvGoCreate:
+		if err := pp.checkRunning(p, g, ev, true); err != nil {
+			return err
+		}
+		if _, ok := pp.gs[ev.Args[0]]; ok {
+			return ev.errorf("g %v already exists", ev.Args[0])
+		}
+		pp.gs[ev.Args[0]] = ppGDesc{state: ppRunnable, ev: ev, evCreate: ev}
+	case EvGoStart, EvGoStartLabel:
+		if g.state != ppRunnable {
+			return ev.errorf("g %v is not runnable before start", ev.G)
+		}
+		if p.g != 0 {
+			return ev.errorf("p %v is already running g %v while start g %v", ev.P, p.g, ev.G)
+		}
+		g.state = ppRunning
+		g.evStart = ev
+		p.g = ev.G
+		if g.evCreate != nil {
+			if pp.ver < 1007 {
+				// +1 because symbolizer expects return pc.
+				ev.Stk = []*Frame{{PC: g.evCreate.Args[1] + 1}}
+			} else {
+				ev.StkID = g.evCreate.Args[1]
 			}
-			if g.ev != nil && g.ev.Type == EvGoSysCall {
-				g.ev.Link = ev
+			g.evCreate = nil
+		}
+
+		if g.ev != nil {
+			g.ev.Link = ev
+			g.ev = nil
+		}
+	case EvGoEnd, EvGoStop:
+		if err := pp.checkRunning(p, g, ev, false); err != nil {
+			return err
+		}
+		g.evStart.Link = ev
+		g.evStart = nil
+		g.state = ppDead
+		p.g = 0
+	case EvGoSched, EvGoPreempt:
+		if err := pp.checkRunning(p, g, ev, false); err != nil {
+			return err
+		}
+		g.state = ppRunnable
+		g.evStart.Link = ev
+		g.evStart = nil
+		p.g = 0
+		g.ev = ev
+	case EvGoUnblock:
+		if g.state != ppRunning {
+			return ev.errorf("g %v is not running while unpark", ev.G)
+		}
+		if ev.P != TimerP && p.g != ev.G {
+			return ev.errorf("p %v is not running g %v while unpark", ev.P, ev.G)
+		}
+		g1 := pp.gs[ev.Args[0]]
+		if g1.state != ppWaiting {
+			return ev.errorf("g %v is not waiting before unpark", ev.Args[0])
+		}
+		if g1.ev != nil && g1.ev.Type == EvGoBlockNet && ev.P != TimerP {
+			ev.P = NetpollP
+		}
+		if g1.ev != nil {
+			g1.ev.Link = ev
+		}
+		g1.state = ppRunnable
+		g1.ev = ev
+		pp.gs[ev.Args[0]] = g1
+	case EvGoSysCall:
+		if err := pp.checkRunning(p, g, ev, false); err != nil {
+			return err
+		}
+		g.ev = ev
+	case EvGoSysBlock:
+		if err := pp.checkRunning(p, g, ev, false); err != nil {
+			return err
+		}
+		g.state = ppWaiting
+		g.evStart.Link = ev
+		g.evStart = nil
+		p.g = 0
+	case EvGoSysExit:
+		if g.state != ppWaiting {
+			return ev.errorf("g %v is not waiting during syscall exit", ev.G)
+		}
+		if g.ev != nil && g.ev.Type == EvGoSysCall {
+			g.ev.Link = ev
+		}
+		g.state = ppRunnable
+		g.ev = ev
+	case EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoBlockGC:
+		if err := pp.checkRunning(p, g, ev, false); err != nil {
+			return err
+		}
+		g.state = ppWaiting
+		g.ev = ev
+		g.evStart.Link = ev
+		g.evStart = nil
+		p.g = 0
+	case EvUserTaskCreate:
+		taskid := ev.Args[0]
+		if prevEv, ok := pp.tasks[taskid]; ok {
+			return ev.errorf("task id %v conflicts with %v", taskid, prevEv)
+		}
+		pp.tasks[taskid] = ev
+	case EvUserTaskEnd:
+		taskid := ev.Args[0]
+		if taskCreateEv, ok := pp.tasks[taskid]; ok {
+			taskCreateEv.Link = ev
+			delete(pp.tasks, taskid)
+		}
+	case EvUserRegion:
+		regions := pp.activeRegions[ev.G]
+		switch mode := ev.Args[1]; mode {
+		case 0: // region start
+			pp.activeRegions[ev.G] = append(regions, ev)
+		case 1: // region end
+			n := len(regions)
+			if n == 0 {
+				// The matching region start happened before tracing started.
+				break
 			}
-			g.state = gRunnable
-			g.ev = ev
-		case EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
-			EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet:
-			if err := checkRunning(p, g, ev, false); err != nil {
-				return err
+			s := regions[n-1]
+			if s.Args[0] != ev.Args[0] || s.SArgs[0] != ev.SArgs[0] {
+				return ev.errorf("misuse of region in g %v: region end %v when the innermost active region start is %v", ev.G, ev, s)
 			}
-			g.state = gWaiting
-			g.ev = ev
-			g.evStart.Link = ev
-			g.evStart = nil
-			p.g = 0
+			s.Link = ev
+			pp.activeRegions[ev.G] = regions[:n-1]
+		default:
+			return ev.errorf("invalid user region mode %v", mode)
 		}
-
-		gs[ev.G] = g
-		ps[ev.P] = p
 	}
 
-	// TODO(dvyukov): restore stacks for EvGoStart events.
-	// TODO(dvyukov): test that all EvGoStart events has non-nil Link.
-
+	pp.gs[ev.G] = g
+	pp.ps[pid] = p
 	return nil
 }
 
@@ -814,6 +1324,26 @@ func symbolize(events []*Event, s Symbolizer) error {
 		pcsList = append(pcsList, pc)
 	}
 
+	if is, ok := s.(InlineSymbolizer); ok {
+		pcs, err := is.SymbolizeInline(pcsList)
+		if err != nil {
+			return err
+		}
+		// Replace frames in events array, with a frame for each
+		// inlined call.
+		for _, ev := range events {
+			if len(ev.Stk) == 0 {
+				continue
+			}
+			stk := make([]*Frame, 0, len(ev.Stk))
+			for _, f := range ev.Stk {
+				stk = append(stk, pcs[f.PC]...)
+			}
+			ev.Stk = stk
+		}
+		return nil
+	}
+
 	pcs, err := s.Symbolize(pcsList)
 	if err != nil {
 		return err
@@ -829,23 +1359,58 @@ func symbolize(events []*Event, s Symbolizer) error {
 	return nil
 }
 
-// readVal reads unsigned base-128 value from r.
-func readVal(r io.Reader, off0 int) (v uint64, off int, err error) {
+// readVal reads unsigned base-128 value from data at offset off0.
+func readVal(data []byte, off0 int) (v uint64, off int, err error) {
 	off = off0
 	for i := 0; i < 10; i++ {
-		var buf [1]byte
-		var n int
-		n, err = r.Read(buf[:])
-		if err != nil || n != 1 {
-			return 0, 0, fmt.Errorf("failed to read trace at offset %d: read %v, error %v", off0, n, err)
+		if off >= len(data) {
+			return 0, off0, io.ErrUnexpectedEOF
 		}
+		b := data[off]
 		off++
-		v |= uint64(buf[0]&0x7f) << (uint(i) * 7)
-		if buf[0]&0x80 == 0 {
+		v |= uint64(b&0x7f) << (uint(i) * 7)
+		if b&0x80 == 0 {
 			return
 		}
 	}
-	return 0, 0, fmt.Errorf("bad value at offset 0x%x", off0)
+	return 0, off0, errBadValue
+}
+
+var errBadValue = errors.New("bad value")
+
+// readVals reads len(vals) unsigned base-128 values from data at offset off0.
+func readVals(data []byte, off0 int, vals []uint64) (off int, err error) {
+	off = off0
+	for i := range vals {
+		// Fast path for single byte values, which most timestamp
+		// deltas, goroutine ids and stack ids are.
+		if off < len(data) && data[off] < 0x80 {
+			vals[i] = uint64(data[off])
+			off++
+			continue
+		}
+		vals[i], off, err = readVal(data, off)
+		if err != nil {
+			return off, err
+		}
+	}
+	return off, nil
+}
+
+// readStr reads a length-prefixed string from data at offset off0.
+func readStr(data []byte, off0 int) (s string, off int, err error) {
+	var sz uint64
+	sz, off, err = readVal(data, off0)
+	if err != nil || sz == 0 {
+		return "", off, err
+	}
+	if sz > 1e6 {
+		return "", off, fmt.Errorf("string has too large length %v", sz)
+	}
+	if uint64(len(data)-off) < sz {
+		return "", off, fmt.Errorf("read %v, want %v, error %v", len(data)-off, sz, io.ErrUnexpectedEOF)
+	}
+	return string(data[off : off+int(sz)]), off + int(sz), nil
 }
 
 // Print dumps events to stdout. For debugging.
@@ -871,15 +1436,24 @@ func argNum(raw rawEvent, ver int) int {
 		if ver < 1007 {
 			narg++ // there was an unused arg before 1.7
 		}
+		return narg
+	}
+	narg++ // timestamp
+	if ver < 1007 {
+		narg++ // sequence
+	}
+	switch raw.typ {
+	case EvGCSweepDone:
+		if ver < 1009 {
+			narg -= 2 // 1.9 added two arguments
+		}
 	case EvGCStart, EvGoStart, EvGoUnblock:
 		if ver < 1007 {
 			narg-- // 1.7 added an additional seq arg
 		}
-		fallthrough
-	default:
-		narg++ // timestamp
-		if ver < 1007 {
-			narg++ // sequence
+	case EvGCSTWStart:
+		if ver < 1010 {
+			narg-- // 1.10 added an argument
 		}
 	}
 	return narg
@@ -891,48 +1465,56 @@ var BreakTimestampsForTesting bool
 // Event types in the trace.
 // Verbatim copy from src/runtime/trace.go.
 const (
-	EvNone           = 0  // unused
-	EvBatch          = 1  // start of per-P batch of events [pid, timestamp]
-	EvFrequency      = 2  // contains tracer timer frequency [frequency (ticks per second)]
-	EvStack          = 3  // stack [stack id, number of PCs, array of {PC, func string ID, file string ID, line}]
-	EvGomaxprocs     = 4  // current value of GOMAXPROCS [timestamp, GOMAXPROCS, stack id]
-	EvProcStart      = 5  // start of P [timestamp, thread id]
-	EvProcStop       = 6  // stop of P [timestamp]
-	EvGCStart        = 7  // GC start [timestamp, seq, stack id]
-	EvGCDone         = 8  // GC done [timestamp]
-	EvGCScanStart    = 9  // GC scan start [timestamp]
-	EvGCScanDone     = 10 // GC scan done [timestamp]
-	EvGCSweepStart   = 11 // GC sweep start [timestamp, stack id]
-	EvGCSweepDone    = 12 // GC sweep done [timestamp]
-	EvGoCreate       = 13 // goroutine creation [timestamp, new goroutine id, new stack id, stack id]
-	EvGoStart        = 14 // goroutine starts running [timestamp, goroutine id, seq]
-	EvGoEnd          = 15 // goroutine ends [timestamp]
-	EvGoStop         = 16 // goroutine stops (like in select{}) [timestamp, stack]
-	EvGoSched        = 17 // goroutine calls Gosched [timestamp, stack]
-	EvGoPreempt      = 18 // goroutine is preempted [timestamp, stack]
-	EvGoSleep        = 19 // goroutine calls Sleep [timestamp, stack]
-	EvGoBlock        = 20 // goroutine blocks [timestamp, stack]
-	EvGoUnblock      = 21 // goroutine is unblocked [timestamp, goroutine id, seq, stack]
-	EvGoBlockSend    = 22 // goroutine blocks on chan send [timestamp, stack]
-	EvGoBlockRecv    = 23 // goroutine blocks on chan recv [timestamp, stack]
-	EvGoBlockSelect  = 24 // goroutine blocks on select [timestamp, stack]
-	EvGoBlockSync    = 25 // goroutine blocks on Mutex/RWMutex [timestamp, stack]
-	EvGoBlockCond    = 26 // goroutine blocks on Cond [timestamp, stack]
-	EvGoBlockNet     = 27 // goroutine blocks on network [timestamp, stack]
-	EvGoSysCall      = 28 // syscall enter [timestamp, stack]
-	EvGoSysExit      = 29 // syscall exit [timestamp, goroutine id, seq, real timestamp]
-	EvGoSysBlock     = 30 // syscall blocks [timestamp]
-	EvGoWaiting      = 31 // denotes that goroutine is blocked when tracing starts [timestamp, goroutine id]
-	EvGoInSyscall    = 32 // denotes that goroutine is in syscall when tracing starts [timestamp, goroutine id]
-	EvHeapAlloc      = 33 // memstats.heap_live change [timestamp, heap_alloc]
-	EvNextGC         = 34 // memstats.next_gc change [timestamp, next_gc]
-	EvTimerGoroutine = 35 // denotes timer goroutine [timer goroutine id]
-	EvFutileWakeup   = 36 // denotes that the previous wakeup of this goroutine was futile [timestamp]
-	EvString         = 37 // string dictionary entry [ID, length, string]
-	EvGoStartLocal   = 38 // goroutine starts running on the same P as the last event [timestamp, goroutine id]
-	EvGoUnblockLocal = 39 // goroutine is unblocked on the same P as the last event [timestamp, goroutine id, stack]
-	EvGoSysExitLocal = 40 // syscall exit on the same P as the last event [timestamp, goroutine id, real timestamp]
-	EvCount          = 41
+	EvNone              = 0  // unused
+	EvBatch             = 1  // start of per-P batch of events [pid, timestamp]
+	EvFrequency         = 2  // contains tracer timer frequency [frequency (ticks per second)]
+	EvStack             = 3  // stack [stack id, number of PCs, array of {PC, func string ID, file string ID, line}]
+	EvGomaxprocs        = 4  // current value of GOMAXPROCS [timestamp, GOMAXPROCS, stack id]
+	EvProcStart         = 5  // start of P [timestamp, thread id]
+	EvProcStop          = 6  // stop of P [timestamp]
+	EvGCStart           = 7  // GC start [timestamp, seq, stack id]
+	EvGCDone            = 8  // GC done [timestamp]
+	EvGCSTWStart        = 9  // GC STW start [timestamp, kind]
+	EvGCSTWDone         = 10 // GC STW done [timestamp]
+	EvGCSweepStart      = 11 // GC sweep start [timestamp, stack id]
+	EvGCSweepDone       = 12 // GC sweep done [timestamp, swept, reclaimed]
+	EvGoCreate          = 13 // goroutine creation [timestamp, new goroutine id, new stack id, stack id]
+	EvGoStart           = 14 // goroutine starts running [timestamp, goroutine id, seq]
+	EvGoEnd             = 15 // goroutine ends [timestamp]
+	EvGoStop            = 16 // goroutine stops (like in select{}) [timestamp, stack]
+	EvGoSched           = 17 // goroutine calls Gosched [timestamp, stack]
+	EvGoPreempt         = 18 // goroutine is preempted [timestamp, stack]
+	EvGoSleep           = 19 // goroutine calls Sleep [timestamp, stack]
+	EvGoBlock           = 20 // goroutine blocks [timestamp, stack]
+	EvGoUnblock         = 21 // goroutine is unblocked [timestamp, goroutine id, seq, stack]
+	EvGoBlockSend       = 22 // goroutine blocks on chan send [timestamp, stack]
+	EvGoBlockRecv       = 23 // goroutine blocks on chan recv [timestamp, stack]
+	EvGoBlockSelect     = 24 // goroutine blocks on select [timestamp, stack]
+	EvGoBlockSync       = 25 // goroutine blocks on Mutex/RWMutex [timestamp, stack]
+	EvGoBlockCond       = 26 // goroutine blocks on Cond [timestamp, stack]
+	EvGoBlockNet        = 27 // goroutine blocks on network [timestamp, stack]
+	EvGoSysCall         = 28 // syscall enter [timestamp, stack]
+	EvGoSysExit         = 29 // syscall exit [timestamp, goroutine id, seq, real timestamp]
+	EvGoSysBlock        = 30 // syscall blocks [timestamp]
+	EvGoWaiting         = 31 // denotes that goroutine is blocked when tracing starts [timestamp, goroutine id]
+	EvGoInSyscall       = 32 // denotes that goroutine is in syscall when tracing starts [timestamp, goroutine id]
+	EvHeapAlloc         = 33 // memstats.heap_live change [timestamp, heap_alloc]
+	EvNextGC            = 34 // memstats.next_gc change [timestamp, next_gc]
+	EvTimerGoroutine    = 35 // denotes timer goroutine [timer goroutine id]
+	EvFutileWakeup      = 36 // denotes that the previous wakeup of this goroutine was futile [timestamp]
+	EvString            = 37 // string dictionary entry [ID, length, string]
+	EvGoStartLocal      = 38 // goroutine starts running on the same P as the last event [timestamp, goroutine id]
+	EvGoUnblockLocal    = 39 // goroutine is unblocked on the same P as the last event [timestamp, goroutine id, stack]
+	EvGoSysExitLocal    = 40 // syscall exit on the same P as the last event [timestamp, goroutine id, real timestamp]
+	EvGoStartLabel      = 41 // goroutine starts running with label [timestamp, goroutine id, seq, label string id]
+	EvGoBlockGC         = 42 // goroutine blocks on GC assist [timestamp, stack]
+	EvGCMarkAssistStart = 43 // GC mark assist start [timestamp, stack]
+	EvGCMarkAssistDone  = 44 // GC mark assist done [timestamp]
+	EvUserTaskCreate    = 45 // trace.NewTask [timestamp, internal task id, internal parent task id, name string id, stack]
+	EvUserTaskEnd       = 46 // end of a task [timestamp, internal task id, stack]
+	EvUserRegion        = 47 // trace.WithRegion [timestamp, internal task id, mode(0:start, 1:end), name string id, stack]
+	EvUserLog           = 48 // trace.Log [timestamp, internal task id, key string id, stack, value string]
+	EvCount             = 49
 )
 
 var EventDescriptions = [EvCount]struct {
@@ -940,46 +1522,55 @@ var EventDescriptions = [EvCount]struct {
 	minVersion int
 	Stack      bool
 	Args       []string
+	SArgs      []string // string arguments
 }{
-	EvNone:           {"None", 1005, false, []string{}},
-	EvBatch:          {"Batch", 1005, false, []string{"p", "ticks"}}, // in 1.5 format it was {"p", "seq", "ticks"}
-	EvFrequency:      {"Frequency", 1005, false, []string{"freq"}},   // in 1.5 format it was {"freq", "unused"}
-	EvStack:          {"Stack", 1005, false, []string{"id", "siz"}},
-	EvGomaxprocs:     {"Gomaxprocs", 1005, true, []string{"procs"}},
-	EvProcStart:      {"ProcStart", 1005, false, []string{"thread"}},
-	EvProcStop:       {"ProcStop", 1005, false, []string{}},
-	EvGCStart:        {"GCStart", 1005, true, []string{"seq"}}, // in 1.5 format it was {}
-	EvGCDone:         {"GCDone", 1005, false, []string{}},
-	EvGCScanStart:    {"GCScanStart", 1005, false, []string{}},
-	EvGCScanDone:     {"GCScanDone", 1005, false, []string{}},
-	EvGCSweepStart:   {"GCSweepStart", 1005, true, []string{}},
-	EvGCSweepDone:    {"GCSweepDone", 1005, false, []string{}},
-	EvGoCreate:       {"GoCreate", 1005, true, []string{"g", "stack"}},
-	EvGoStart:        {"GoStart", 1005, false, []string{"g", "seq"}}, // in 1.5 format it was {"g"}
-	EvGoEnd:          {"GoEnd", 1005, false, []string{}},
-	EvGoStop:         {"GoStop", 1005, true, []string{}},
-	EvGoSched:        {"GoSched", 1005, true, []string{}},
-	EvGoPreempt:      {"GoPreempt", 1005, true, []string{}},
-	EvGoSleep:        {"GoSleep", 1005, true, []string{}},
-	EvGoBlock:        {"GoBlock", 1005, true, []string{}},
-	EvGoUnblock:      {"GoUnblock", 1005, true, []string{"g", "seq"}}, // in 1.5 format it was {"g"}
-	EvGoBlockSend:    {"GoBlockSend", 1005, true, []string{}},
-	EvGoBlockRecv:    {"GoBlockRecv", 1005, true, []string{}},
-	EvGoBlockSelect:  {"GoBlockSelect", 1005, true, []string{}},
-	EvGoBlockSync:    {"GoBlockSync", 1005, true, []string{}},
-	EvGoBlockCond:    {"GoBlockCond", 1005, true, []string{}},
-	EvGoBlockNet:     {"GoBlockNet", 1005, true, []string{}},
-	EvGoSysCall:      {"GoSysCall", 1005, true, []string{}},
-	EvGoSysExit:      {"GoSysExit", 1005, false, []string{"g", "seq", "ts"}},
-	EvGoSysBlock:     {"GoSysBlock", 1005, false, []string{}},
-	EvGoWaiting:      {"GoWaiting", 1005, false, []string{"g"}},
-	EvGoInSyscall:    {"GoInSyscall", 1005, false, []string{"g"}},
-	EvHeapAlloc:      {"HeapAlloc", 1005, false, []string{"mem"}},
-	EvNextGC:         {"NextGC", 1005, false, []string{"mem"}},
-	EvTimerGoroutine: {"TimerGoroutine", 1005, false, []string{"g"}}, // in 1.5 format it was {"g", "unused"}
-	EvFutileWakeup:   {"FutileWakeup", 1005, false, []string{}},
-	EvString:         {"String", 1007, false, []string{}},
-	EvGoStartLocal:   {"GoStartLocal", 1007, false, []string{"g"}},
-	EvGoUnblockLocal: {"GoUnblockLocal", 1007, true, []string{"g"}},
-	EvGoSysExitLocal: {"GoSysExitLocal", 1007, false, []string{"g", "ts"}},
+	EvNone:              {"None", 1005, false, []string{}, nil},
+	EvBatch:             {"Batch", 1005, false, []string{"p", "ticks"}, nil}, // in 1.5 format it was {"p", "seq", "ticks"}
+	EvFrequency:         {"Frequency", 1005, false, []string{"freq"}, nil},   // in 1.5 format it was {"freq", "unused"}
+	EvStack:             {"Stack", 1005, false, []string{"id", "siz"}, nil},
+	EvGomaxprocs:        {"Gomaxprocs", 1005, true, []string{"procs"}, nil},
+	EvProcStart:         {"ProcStart", 1005, false, []string{"thread"}, nil},
+	EvProcStop:          {"ProcStop", 1005, false, []string{}, nil},
+	EvGCStart:           {"GCStart", 1005, true, []string{"seq"}, nil}, // in 1.5 format it was {}
+	EvGCDone:            {"GCDone", 1005, false, []string{}, nil},
+	EvGCSTWStart:        {"GCSTWStart", 1005, false, []string{"kindid"}, []string{"kind"}}, // <= 1.9, args was {} (implicitly {0})
+	EvGCSTWDone:         {"GCSTWDone", 1005, false, []string{}, nil},
+	EvGCSweepStart:      {"GCSweepStart", 1005, true, []string{}, nil},
+	EvGCSweepDone:       {"GCSweepDone", 1005, false, []string{"swept", "reclaimed"}, nil}, // before 1.9, format was {}
+	EvGoCreate:          {"GoCreate", 1005, true, []string{"g", "stack"}, nil},
+	EvGoStart:           {"GoStart", 1005, false, []string{"g", "seq"}, nil}, // in 1.5 format it was {"g"}
+	EvGoEnd:             {"GoEnd", 1005, false, []string{}, nil},
+	EvGoStop:            {"GoStop", 1005, true, []string{}, nil},
+	EvGoSched:           {"GoSched", 1005, true, []string{}, nil},
+	EvGoPreempt:         {"GoPreempt", 1005, true, []string{}, nil},
+	EvGoSleep:           {"GoSleep", 1005, true, []string{}, nil},
+	EvGoBlock:           {"GoBlock", 1005, true, []string{}, nil},
+	EvGoUnblock:         {"GoUnblock", 1005, true, []string{"g", "seq"}, nil}, // in 1.5 format it was {"g"}
+	EvGoBlockSend:       {"GoBlockSend", 1005, true, []string{}, nil},
+	EvGoBlockRecv:       {"GoBlockRecv", 1005, true, []string{}, nil},
+	EvGoBlockSelect:     {"GoBlockSelect", 1005, true, []string{}, nil},
+	EvGoBlockSync:       {"GoBlockSync", 1005, true, []string{}, nil},
+	EvGoBlockCond:       {"GoBlockCond", 1005, true, []string{}, nil},
+	EvGoBlockNet:        {"GoBlockNet", 1005, true, []string{}, nil},
+	EvGoSysCall:         {"GoSysCall", 1005, true, []string{}, nil},
+	EvGoSysExit:         {"GoSysExit", 1005, false, []string{"g", "seq", "ts"}, nil},
+	EvGoSysBlock:        {"GoSysBlock", 1005, false, []string{}, nil},
+	EvGoWaiting:         {"GoWaiting", 1005, false, []string{"g"}, nil},
+	EvGoInSyscall:       {"GoInSyscall", 1005, false, []string{"g"}, nil},
+	EvHeapAlloc:         {"HeapAlloc", 1005, false, []string{"mem"}, nil},
+	EvNextGC:            {"NextGC", 1005, false, []string{"mem"}, nil},
+	EvTimerGoroutine:    {"TimerGoroutine", 1005, false, []string{"g"}, nil}, // in 1.5 format it was {"g", "unused"}
+	EvFutileWakeup:      {"FutileWakeup", 1005, false, []string{}, nil},
+	EvString:            {"String", 1007, false, []string{}, nil},
+	EvGoStartLocal:      {"GoStartLocal", 1007, false, []string{"g"}, nil},
+	EvGoUnblockLocal:    {"GoUnblockLocal", 1007, true, []string{"g"}, nil},
+	EvGoSysExitLocal:    {"GoSysExitLocal", 1007, false, []string{"g", "ts"}, nil},
+	EvGoStartLabel:      {"GoStartLabel", 1008, false, []string{"g", "seq", "labelid"}, []string{"label"}},
+	EvGoBlockGC:         {"GoBlockGC", 1008, true, []string{}, nil},
+	EvGCMarkAssistStart: {"GCMarkAssistStart", 1009, true, []string{}, nil},
+	EvGCMarkAssistDone:  {"GCMarkAssistDone", 1009, false, []string{}, nil},
+	EvUserTaskCreate:    {"UserTaskCreate", 1011, true, []string{"taskid", "pid", "nameid"}, []string{"name"}},
+	EvUserTaskEnd:       {"UserTaskEnd", 1011, true, []string{"taskid"}, nil},
+	EvUserRegion:        {"UserRegion", 1011, true, []string{"taskid", "mode", "nameid"}, []string{"name"}},
+	EvUserLog:           {"UserLog", 1011, true, []string{"id", "keyid"}, []string{"category", "message"}},
 }
@@ -6,12 +6,28 @@ package trace
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
 
+// parse parses, post-processes and verifies the trace in r. It returns the
+// trace version and the list of events.
+func parse(r io.Reader, symbolizer Symbolizer) (int, []*Event, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read trace: %v", err)
+	}
+	return parseBytes(data, &ParseOptions{Symbolizer: symbolizer}, nil)
+}
+
 func TestCorruptedInputs(t *testing.T) {
 	// These inputs crashed parser previously.
 	tests := []string{
@@ -42,14 +58,23 @@ func TestParseCanned(t *testing.T) {
 		if err != nil {
 			t.Fatalf("failed to read input file: %v", err)
 		}
+		name := f.Name()
+		if strings.HasSuffix(name, ".zst") {
+			// A zstd-compressed copy of a trace.
+			name = strings.TrimSuffix(name, ".zst")
+			if data, err = decompress(data, 0); err != nil {
+				t.Errorf("failed to decompress %v: %v", f.Name(), err)
+				continue
+			}
+		}
 		_, _, err = parse(bytes.NewReader(data), nil)
 		switch {
-		case strings.HasSuffix(f.Name(), "_good"):
+		case strings.HasSuffix(name, "_good"):
 			if err != nil {
 				t.Errorf("failed to parse good trace %v: %v", f.Name(), err)
 			}
-		case strings.HasSuffix(f.Name(), "_unordered"):
-			if err != ErrTimeOrder {
+		case strings.HasSuffix(name, "_unordered"):
+			if !errors.Is(err, ErrTimeOrder) {
 				t.Errorf("unordered trace is not detected %v: %v", f.Name(), err)
 			}
 		default:
@@ -58,6 +83,142 @@ func TestParseCanned(t *testing.T) {
 	}
 }
 
+func TestParseFile(t *testing.T) {
+	const name = "testdata/annotations_1_26_good"
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	want, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	events, err := ParseFile(name, nil)
+	if err != nil {
+		t.Fatalf("failed to parse file: %v", err)
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %v events, want %v", len(events), len(want))
+	}
+	for i, ev := range events {
+		if ev.Type != want[i].Type || ev.Ts != want[i].Ts || ev.Args != want[i].Args {
+			t.Fatalf("event %v: got %v, want %v", i, ev, want[i])
+		}
+	}
+	if _, err := ParseFile("testdata/nonexistent", nil); err == nil {
+		t.Errorf("no error on nonexistent file")
+	}
+}
+
+func TestParseDeterministic(t *testing.T) {
+	// Batches are decoded concurrently; the result must not depend on it.
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		procs := runtime.GOMAXPROCS(1)
+		_, want, err := parse(bytes.NewReader(data), nil)
+		runtime.GOMAXPROCS(procs)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		for i := 0; i < 3; i++ {
+			_, events, err := parse(bytes.NewReader(data), nil)
+			if err != nil {
+				t.Fatalf("failed to parse %v: %v", f, err)
+			}
+			if len(events) != len(want) {
+				t.Fatalf("%v: got %v events, want %v", f, len(events), len(want))
+			}
+			for j, ev := range events {
+				if ev.Off != want[j].Off || ev.Ts != want[j].Ts || ev.P != want[j].P || ev.G != want[j].G || len(ev.Stk) != len(want[j].Stk) {
+					t.Fatalf("%v: event %v: got %v, want %v", f, j, ev, want[j])
+				}
+			}
+		}
+	}
+}
+
+func TestParseFirstError(t *testing.T) {
+	// The error of the first bad event in the trace is reported,
+	// regardless of the P it belongs to.
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvBatch, 1, 0)
+	w.emit(EvGCSTWStart, 1, 2)
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvGCSTWStart, 1, 3)
+	_, err := Parse(w, nil)
+	if err == nil || !strings.Contains(err.Error(), "unknown STW kind 2") {
+		t.Fatalf("got error %v, want unknown STW kind 2", err)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		name string
+		emit func(w *writer)
+		kind ErrorKind
+		typ  byte
+		p    int
+	}{
+		{"wire-format", func(w *writer) {
+			w.emit(EvBatch, 0, 0)
+			w.emit(EvFrequency, 1e9)
+			w.emit(EvGCSTWStart, 1, 2)
+		}, KindWireFormat, EvGCSTWStart, -1},
+		{"state-machine", func(w *writer) {
+			w.emit(EvBatch, 0, 0)
+			w.emit(EvFrequency, 1e9)
+			w.emit(EvGoEnd, 1)
+		}, KindStateMachine, EvGoEnd, 0},
+		{"ordering", func(w *writer) {
+			w.emit(EvBatch, 0, 0)
+			w.emit(EvFrequency, 1e9)
+			w.emit(EvGoCreate, 1, 2, 0, 0)
+			w.emit(EvBatch, 1, 0)
+			w.emit(EvGoStart, 1, 2, 5)
+		}, KindOrdering, EvGoStart, 1},
+		{"time-order", func(w *writer) {
+			w.emit(EvBatch, 0, 1000)
+			w.emit(EvFrequency, 1e9)
+			w.emit(EvGoCreate, 1, 2, 0, 0)
+			w.emit(EvBatch, 1, 0)
+			w.emit(EvGoStart, 1, 2, 1)
+		}, KindTimeOrder, EvGoStart, 1},
+		{"time-order frequency", func(w *writer) {
+			w.emit(EvBatch, 0, 0)
+			w.emit(EvFrequency, 0)
+		}, KindTimeOrder, EvFrequency, -1},
+	}
+	for _, tt := range tests {
+		w := newWriterVersion("1.10")
+		tt.emit(w)
+		data := append([]byte(nil), w.Bytes()...)
+		_, err := Parse(w, nil)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Errorf("%v: got error %v, want a ParseError", tt.name, err)
+			continue
+		}
+		if perr.Kind != tt.kind || perr.Type != tt.typ || perr.P != tt.p {
+			t.Errorf("%v: got kind %v, type %v, p %v, want %v, %v, %v", tt.name, perr.Kind, perr.Type, perr.P, tt.kind, tt.typ, tt.p)
+		}
+		if perr.Off <= 0 || perr.Off >= len(data) || data[perr.Off]&0x3f != tt.typ {
+			t.Errorf("%v: offset %v does not point to the event", tt.name, perr.Off)
+		}
+		if errors.Is(err, ErrTimeOrder) != (tt.kind == KindTimeOrder) {
+			t.Errorf("%v: errors.Is(%v, ErrTimeOrder) = %v", tt.name, err, !(tt.kind == KindTimeOrder))
+		}
+	}
+}
+
 func TestParseVersion(t *testing.T) {
 	tests := map[string]int{
 		"go 1.5 trace\x00\x00\x00\x00": 1005,
@@ -99,13 +260,131 @@ func TestTimestampOverflow(t *testing.T) {
 	}
 }
 
+func TestParseGo110(t *testing.T) {
+	// Test that parser understands events added in 1.8-1.10.
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emitString(1, "GC (dedicated)")
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStartLabel, 1, 1, 1, 1)
+	w.emit(EvGCMarkAssistStart, 1, 0)
+	w.emit(EvGCMarkAssistDone, 1)
+	w.emit(EvGoBlockGC, 1, 0)
+	w.emit(EvGCStart, 1, 0, 0)
+	w.emit(EvGCSTWStart, 1, 1)
+	w.emit(EvGCSTWDone, 1)
+	w.emit(EvGCSweepStart, 1, 0)
+	w.emit(EvGCSweepDone, 1, 100, 50)
+	w.emit(EvGCDone, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	for _, ev := range events {
+		switch ev.Type {
+		case EvGoStartLabel:
+			if len(ev.SArgs) != 1 || ev.SArgs[0] != "GC (dedicated)" {
+				t.Errorf("bad label for %v: %q", ev, ev.SArgs)
+			}
+			if ev.Link == nil || ev.Link.Type != EvGoBlockGC {
+				t.Errorf("%v is not linked to GoBlockGC: %v", ev, ev.Link)
+			}
+		case EvGCSTWStart:
+			if len(ev.SArgs) != 1 || ev.SArgs[0] != "sweep termination" {
+				t.Errorf("bad STW kind for %v: %q", ev, ev.SArgs)
+			}
+			if ev.Link == nil || ev.Link.Type != EvGCSTWDone {
+				t.Errorf("%v is not linked to GCSTWDone: %v", ev, ev.Link)
+			}
+		case EvGCMarkAssistStart:
+			if ev.Link == nil || ev.Link.Type != EvGCMarkAssistDone {
+				t.Errorf("%v is not linked to GCMarkAssistDone: %v", ev, ev.Link)
+			}
+		case EvGCStart:
+			if ev.P != GCP {
+				t.Errorf("%v is not attributed to GCP", ev)
+			}
+		case EvGCSweepDone:
+			if ev.Args[0] != 100 || ev.Args[1] != 50 {
+				t.Errorf("bad swept/reclaimed for %v", ev)
+			}
+		}
+	}
+}
+
+func TestParseGo108(t *testing.T) {
+	// Before 1.9 GCSweepDone had no arguments and
+	// before 1.10 GCSTWStart had no kind and was per-P.
+	w := newWriterVersion("1.8")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGCSTWStart, 1)
+	w.emit(EvGCSTWDone, 1)
+	w.emit(EvGCSweepStart, 1, 0)
+	w.emit(EvGCSweepDone, 1)
+	w.emit(EvBatch, 1, 0)
+	w.emit(EvGCSTWStart, 1)
+	w.emit(EvGCSTWDone, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	for _, ev := range events {
+		if ev.Type == EvGCSTWStart && (len(ev.SArgs) != 1 || ev.SArgs[0] != "mark termination") {
+			t.Errorf("bad STW kind for %v: %q", ev, ev.SArgs)
+		}
+	}
+}
+
+func TestParseGo108BadMarkAssist(t *testing.T) {
+	// Mark assist events were added in 1.9.
+	w := newWriterVersion("1.8")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGCMarkAssistStart, 1, 0)
+	if _, err := Parse(w, nil); err == nil {
+		t.Fatalf("no error on GCMarkAssistStart in 1.8 trace")
+	}
+}
+
+func TestParseGo122SyscallSteal(t *testing.T) {
+	// A P stolen from a goroutine blocked in a syscall is translated
+	// to the syscall events of the old format.
+	data, err := ioutil.ReadFile("testdata/syscall_steal_1_22_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	_, events, err := parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	var got []string
+	for _, ev := range events {
+		switch {
+		case ev.Type == EvGoCreate && ev.Args[0] != 1:
+		case ev.G == 1, ev.P == 0:
+			got = append(got, EventDescriptions[ev.Type].Name)
+		}
+	}
+	want := []string{"GoCreate", "ProcStart", "GoStart", "GoSysCall", "GoSysBlock", "ProcStop", "GoSysExit"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got events %v, want %v", got, want)
+	}
+}
+
 type writer struct {
 	bytes.Buffer
 }
 
 func newWriter() *writer {
+	return newWriterVersion("1.7")
+}
+
+func newWriterVersion(ver string) *writer {
 	w := new(writer)
-	w.Write([]byte("go 1.7 trace\x00\x00\x00\x00"))
+	header := []byte("go " + ver + " trace\x00\x00\x00\x00")
+	w.Write(header[:16])
 	return w
 }
 
@@ -130,10 +409,53 @@ func (w *writer) emit(typ byte, args ...uint64) {
 	}
 }
 
-func appendVarint(buf []byte, v uint64) []byte {
-	for ; v >= 0x80; v >>= 7 {
-		buf = append(buf, 0x80|byte(v))
+func (w *writer) emitString(id uint64, str string) {
+	buf := []byte{EvString}
+	buf = appendVarint(buf, id)
+	buf = appendString(buf, str)
+	n, err := w.Write(buf)
+	if n != len(buf) || err != nil {
+		panic("failed to write")
+	}
+}
+
+func BenchmarkReadTrace(b *testing.B) {
+	benchmarkTestdata(b, func(data []byte) error {
+		_, _, _, _, err := readTraceBytes(data, nil)
+		return err
+	}, true)
+}
+
+func BenchmarkParse(b *testing.B) {
+	benchmarkTestdata(b, func(data []byte) error {
+		_, _, err := parseBytes(data, nil, nil)
+		return err
+	}, false)
+}
+
+func benchmarkTestdata(b *testing.B, parse func(data []byte) error, oldOnly bool) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		b.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			b.Fatalf("failed to read input file: %v", err)
+		}
+		if ver, _ := parseHeader(data[:16]); oldOnly && ver >= 1022 {
+			continue
+		}
+		b.Run(filepath.Base(f), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := parse(data); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
 	}
-	buf = append(buf, byte(v))
-	return buf
 }
@@ -0,0 +1,219 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// A Reader reads the events of a trace one at a time, in order.
+//
+// Traces produced by Go 1.22 and later are self-contained generations
+// of about a second each, so a Reader holds at most one generation in
+// memory regardless of the size of the trace.
+//
+// Traces produced by Go 1.7 to 1.21 are made of per-P batches written in
+// no particular order, and the frequency of the trace clock and the
+// stack table are written only when tracing stops. A Reader of such a
+// trace first decodes it once to index the batches of each P, keeping
+// only the stack table and the strings, and then decodes the batches
+// again as their events are merged, holding only the next event of each
+// P. Since the trace is read twice, it must be held in memory or, with
+// OpenReader, mapped into memory. The exit of a syscall is ordered at
+// the time the syscall returned, as by Parse, so the events after the
+// start of a syscall are held back until it exits, but at most 65536 of
+// them: a syscall exit that is ordered before events already returned
+// gets the time of the last of these instead. Futile wakeups are kept
+// and marked as with ParseOptions.KeepFutile; Futile of an event may be
+// set after the event is returned, when the block that ends the futile
+// wakeup is returned.
+//
+// Traces produced by Go 1.5 and 1.6, which are ordered by a global
+// sequence number and symbolized as a whole, and traces read with
+// ParseOptions.RepairClockSkew, whose timestamps are repaired from the
+// whole trace, are parsed as a whole as by Parse, and the Reader holds
+// all their events in memory.
+//
+// Events are verified as by Parse and have their stacks attached.
+// Link of an event may be not yet set when the event is returned by Next;
+// it is set at the latest when the event it links to is returned.
+type Reader struct {
+	ver    int
+	events []*Event // ordered events that were not returned yet
+	gr     *genReader
+	o      *ordering2
+	pp     *postProcessor
+	bs     *batchStream
+	close  func() error
+	err    error
+}
+
+// NewReader returns a Reader of the trace in r.
+// The symbolizer is required for traces produced by go 1.6 or below.
+// A gzip- or zstd-compressed trace is decompressed as it is read.
+// Traces produced by Go 1.21 and earlier are read into memory, but not
+// decoded as a whole, see Reader; OpenReader maps them instead.
+// ParseOptions gives more control over reading.
+func NewReader(r io.Reader, symbolizer Symbolizer) (*Reader, error) {
+	return (&ParseOptions{Symbolizer: symbolizer}).NewReader(r)
+}
+
+// OpenReader is like NewReader but reads the trace in the named file,
+// or at the URL name if it is an http or https URL. The file is mapped
+// into memory where possible instead of being read, unless the trace is
+// compressed. Close must be called when the Reader is no longer used.
+func OpenReader(name string, symbolizer Symbolizer) (*Reader, error) {
+	return (&ParseOptions{Symbolizer: symbolizer}).OpenReader(name)
+}
+
+// NewReader is like the package function NewReader but with the options.
+// The limits count the whole trace as with Parse, even though the Reader
+// holds only part of it in memory.
+func (opts *ParseOptions) NewReader(r io.Reader) (*Reader, error) {
+	r, err := Decompress(r)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	hdr, err := br.Peek(16)
+	if err != nil {
+		return nil, wireErrorf(0, EvNone, "failed to read header: read %v, err %v", len(hdr), err)
+	}
+	ver, err := parseHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if ver < 1022 {
+		data, err := opts.readAll(br)
+		if err != nil {
+			return nil, err
+		}
+		return opts.readerBytes(ver, data)
+	}
+	gr, err := newGenReader(ver, br)
+	if err != nil {
+		return nil, err
+	}
+	gr.lim = newLimiter(opts.Limits)
+	br.Discard(16)
+	return &Reader{ver: ver, gr: gr, o: newOrdering2(ver), pp: newPostProcessor(ver)}, nil
+}
+
+// OpenReader is like the package function OpenReader but with the options.
+func (opts *ParseOptions) OpenReader(name string) (*Reader, error) {
+	if isURL(name) {
+		rc, err := Open(name)
+		if err != nil {
+			return nil, err
+		}
+		r, err := opts.NewReader(rc)
+		if err != nil {
+			rc.Close()
+			return nil, err
+		}
+		r.close = rc.Close
+		return r, nil
+	}
+	data, unmap, err := mapFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var r *Reader
+	var ver int
+	if len(data) >= 16 {
+		ver, _ = parseHeader(data[:16])
+	}
+	if ver != 0 && ver < 1022 {
+		r, err = opts.readerBytes(ver, data)
+	} else {
+		r, err = opts.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	r.close = unmap
+	return r, nil
+}
+
+// readerBytes returns a Reader of the trace of version ver, before
+// Go 1.22, in data.
+func (opts *ParseOptions) readerBytes(ver int, data []byte) (*Reader, error) {
+	if ver < 1007 || opts.RepairClockSkew {
+		events, err := opts.ParseBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		return &Reader{ver: ver, events: events, err: io.EOF}, nil
+	}
+	bs, err := newBatchStream(ver, data, newLimiter(opts.Limits))
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{ver: ver, bs: bs}, nil
+}
+
+// Version returns the version of the trace, for example 1022 for Go 1.22.
+func (r *Reader) Version() int {
+	return r.ver
+}
+
+// Next returns the next event of the trace.
+// It returns io.EOF when there are no more events.
+func (r *Reader) Next() (*Event, error) {
+	if r.bs != nil {
+		if r.err == nil {
+			var ev *Event
+			if ev, r.err = r.bs.next(); r.err == nil {
+				return ev, nil
+			}
+		}
+		return nil, r.err
+	}
+	for len(r.events) == 0 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		r.err = r.readGeneration()
+	}
+	ev := r.events[0]
+	r.events[0] = nil
+	r.events = r.events[1:]
+	return ev, nil
+}
+
+// Close releases the file mapped by OpenReader, after which Next must
+// not be called. The events that were returned remain valid.
+func (r *Reader) Close() error {
+	c := r.close
+	r.close = nil
+	if c == nil {
+		return nil
+	}
+	return c()
+}
+
+// readGeneration orders and verifies the events of the next generation.
+func (r *Reader) readGeneration() error {
+	g, err := r.gr.next()
+	if err != nil {
+		return err
+	}
+	if g == nil {
+		return io.EOF
+	}
+	if err := r.o.addGeneration(g); err != nil {
+		return err
+	}
+	events := r.o.flush()
+	for _, ev := range events {
+		if err := r.pp.process(ev); err != nil {
+			return err
+		}
+		if ev.StkID != 0 {
+			ev.Stk = r.o.stacks[ev.StkID]
+		}
+	}
+	r.events = events
+	return nil
+}
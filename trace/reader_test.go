@@ -0,0 +1,187 @@
+package trace
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/annotations_1_26_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	want, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	r, err := NewReader(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	if r.Version() != 1026 {
+		t.Errorf("got version %v, want 1026", r.Version())
+	}
+	var got []*Event
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read event %v: %v", len(got), err)
+		}
+		got = append(got, ev)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v events, want %v", len(got), len(want))
+	}
+	for i := range got {
+		ev, wev := got[i], want[i]
+		if ev.Type != wev.Type || ev.Ts != wev.Ts || ev.P != wev.P || ev.G != wev.G ||
+			ev.Args != wev.Args || !reflect.DeepEqual(ev.Stk, wev.Stk) || (ev.Link == nil) != (wev.Link == nil) {
+			t.Fatalf("event %v: got %v, want %v", i, ev, wev)
+		}
+	}
+
+	r, err = NewReader(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to create reader: %v", err)
+	}
+	gs, err := ReadGoroutineStats(r)
+	if err != nil {
+		t.Fatalf("failed to read goroutine stats: %v", err)
+	}
+	if wgs := GoroutineStats(want); !reflect.DeepEqual(gs, wgs) {
+		t.Errorf("got goroutine stats %v, want %v", gs, wgs)
+	}
+}
+
+func TestReaderBatches(t *testing.T) {
+	// Traces of Go 1.7 to 1.21 are merged from their batches as they
+	// are read, into the events returned by Parse.
+	for _, file := range []string{"testdata/stress_1_11_good", "testdata/stress_start_stop_1_11_good", "testdata/user_task_region_1_11_good"} {
+		want, err := ParseFile(file, nil)
+		if err != nil {
+			t.Fatalf("%v: failed to parse: %v", file, err)
+		}
+		r, err := OpenReader(file, nil)
+		if err != nil {
+			t.Fatalf("%v: failed to create reader: %v", file, err)
+		}
+		if r.bs == nil {
+			t.Fatalf("%v: trace is not read from its batches", file)
+		}
+		got, err := readAllEvents(r)
+		if err != nil {
+			t.Fatalf("%v: failed to read event %v: %v", file, len(got), err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatalf("%v: failed to close reader: %v", file, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%v: got %v events, want %v", file, len(got), len(want))
+		}
+		for i := range got {
+			ev, wev := got[i], want[i]
+			if ev.Off != wev.Off || ev.Type != wev.Type || ev.Ts != wev.Ts || ev.P != wev.P || ev.G != wev.G ||
+				ev.Args != wev.Args || !reflect.DeepEqual(ev.SArgs, wev.SArgs) || !reflect.DeepEqual(ev.Stk, wev.Stk) ||
+				(ev.Link == nil) != (wev.Link == nil) || (ev.Link != nil && ev.Link.Off != wev.Link.Off) {
+				t.Fatalf("%v: event %v: got %v, want %v", file, i, ev, wev)
+			}
+		}
+	}
+}
+
+func TestReaderLookahead(t *testing.T) {
+	// g 1 is in a syscall that returned at tick 10 while n events of
+	// g 2 happen, and the syscall exit is emitted after them.
+	trace := func(n int) []byte {
+		w := newWriterVersion("1.10")
+		w.emit(EvBatch, 0, 0)
+		w.emit(EvFrequency, 1e9)
+		w.emit(EvGoCreate, 1, 1, 0, 0)
+		w.emit(EvGoStart, 1, 1, 1)
+		w.emit(EvGoSysCall, 1, 0)
+		w.emit(EvGoSysBlock, 1)
+		w.emit(EvGoCreate, 1, 2, 0, 0)
+		w.emit(EvGoStart, 1, 2, 1)
+		for i := 0; i < n; i++ {
+			w.emit(EvHeapAlloc, 1, uint64(i))
+		}
+		w.emit(EvGoEnd, 1)
+		w.emit(EvGoSysExit, 1, 1, 2, 10)
+		w.emit(EvGoStart, 1, 1, 3)
+		w.emit(EvGoEnd, 1)
+		return w.Bytes()
+	}
+	for _, n := range []int{100, maxLookahead + 100} {
+		data := trace(n)
+		want, err := ParseBytes(data, nil)
+		if err != nil {
+			t.Fatalf("n=%v: failed to parse: %v", n, err)
+		}
+		r, err := NewReader(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("n=%v: failed to create reader: %v", n, err)
+		}
+		var got []*Event
+		for {
+			ev, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("n=%v: failed to read event %v: %v", n, len(got), err)
+			}
+			if len(r.bs.pending) > maxLookahead {
+				t.Fatalf("n=%v: reader holds %v events", n, len(r.bs.pending))
+			}
+			got = append(got, ev)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("n=%v: got %v events, want %v", n, len(got), len(want))
+		}
+		exits := 0
+		for i := range got {
+			ev, wev := got[i], want[i]
+			if i > 0 && ev.Ts < got[i-1].Ts {
+				t.Fatalf("n=%v: event %v is before the preceding one: %v", n, i, ev)
+			}
+			if n > maxLookahead {
+				// The syscall exit is ordered after the events already
+				// returned, at the time of the last of them.
+				if ev.Type == EvGoSysExit {
+					exits++
+					if ev.Ts != got[i-1].Ts {
+						t.Errorf("n=%v: got syscall exit %v, want it at the preceding %v", n, ev, got[i-1])
+					}
+				}
+				continue
+			}
+			if ev.Off != wev.Off || ev.Ts != wev.Ts {
+				t.Fatalf("n=%v: event %v: got %v, want %v", n, i, ev, wev)
+			}
+		}
+		if n > maxLookahead && exits != 1 {
+			t.Errorf("n=%v: got %v syscall exits, want 1", n, exits)
+		}
+	}
+}
+
+// readAllEvents returns the events read from r.
+func readAllEvents(r *Reader) ([]*Event, error) {
+	var events []*Event
+	for {
+		ev, err := r.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
@@ -0,0 +1,302 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Recovery describes the damage ParseLenient found in a trace
+// and what it did to recover from it.
+type Recovery struct {
+	// Err is the error that ended decoding of the trace.
+	// It is nil if the whole trace was decoded.
+	Err error
+	// Offset is the offset in the trace of the first byte that
+	// was dropped, and DroppedBytes is the number of bytes from
+	// there on. Both are 0 if Err is nil.
+	Offset       int
+	DroppedBytes int
+	// DroppedEvents is the number of events that were decoded but could
+	// not be ordered or verified because the events they depend on are
+	// missing.
+	DroppedEvents int
+	// NoFrequency reports that the frequency of the trace clock was lost,
+	// so timestamps are in clock ticks rather than nanoseconds.
+	NoFrequency bool
+	// Synthesized is the events added to the end of the trace to end
+	// the goroutines, GC phases and sweeps that were still in progress.
+	// Their Off is the size of the trace.
+	Synthesized []*Event
+	// Violations is every consistency violation that was found,
+	// in the order it was found.
+	Violations []*Violation
+}
+
+// Damaged reports whether any damage was found in the trace.
+func (rec *Recovery) Damaged() bool {
+	return rec.Err != nil || rec.DroppedEvents != 0 || len(rec.Synthesized) != 0 || rec.NoFrequency
+}
+
+func (rec *Recovery) String() string {
+	if !rec.Damaged() {
+		return "trace is intact"
+	}
+	var s []string
+	if rec.Err != nil {
+		s = append(s, fmt.Sprintf("dropped %v bytes from offset %v (%v)", rec.DroppedBytes, rec.Offset, rec.Err))
+	}
+	s = append(s, fmt.Sprintf("dropped %v events", rec.DroppedEvents), fmt.Sprintf("synthesized %v events", len(rec.Synthesized)))
+	if rec.NoFrequency {
+		s = append(s, "timestamps are in clock ticks")
+	}
+	return strings.Join(s, ", ")
+}
+
+// fail records that the trace is unusable from offset off on because of err.
+func (rec *Recovery) fail(off int, err error) {
+	rec.violate(err, "", "")
+	if rec.Err == nil || off < rec.Offset {
+		rec.Err, rec.Offset = err, off
+	}
+}
+
+// ParseLenient is like Parse but recovers what it can from a truncated or
+// corrupted trace, for example the trace of a process that was killed while
+// tracing. It returns the events up to the first damage, drops the events
+// that are inconsistent with the rest of the trace, and ends the goroutines
+// and GC phases that are still in progress at the end of a damaged trace.
+// What was dropped and added is described by the returned Recovery.
+//
+// An error is returned only if no events can be recovered.
+func ParseLenient(r io.Reader, symbolizer Symbolizer) ([]*Event, *Recovery, error) {
+	return (&ParseOptions{Symbolizer: symbolizer}).ParseLenient(r)
+}
+
+// ParseLenient is like the package function ParseLenient but with the options.
+func (opts *ParseOptions) ParseLenient(r io.Reader) ([]*Event, *Recovery, error) {
+	data, err := opts.readAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec := new(Recovery)
+	ver, events, err := parseBytes(data, opts, rec)
+	if err == nil && len(events) == 0 && rec.Err != nil {
+		err = rec.Err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if ver < 1007 && opts.Symbolizer == nil {
+		return nil, nil, fmt.Errorf("for traces produced by go 1.6 or below, the binary argument must be provided")
+	}
+	if rec.Err != nil {
+		rec.DroppedBytes = len(data) - rec.Offset
+	}
+	return events, rec, nil
+}
+
+// postProcessLenient is postProcessTrace that repairs the state of
+// goroutines, Ps and GC phases with synthesized events where an event does
+// not verify, and drops the event if that fails. If the trace is damaged,
+// it then synthesizes the events that end the goroutines, GC phases and
+// sweeps in progress at the end of the trace. size is the size of the trace.
+// The progress of verification is reported to t.
+func postProcessLenient(ver int, events []*Event, size int, rec *Recovery, t *tracker) []*Event {
+	pp := newPostProcessor(ver)
+	kept := make([]*Event, 0, len(events))
+	for _, ev := range events {
+		t.event(-1)
+		if err := pp.process(ev); err != nil {
+			expected, actual := pp.describe(ev)
+			rec.violate(err, expected, actual)
+			fixed, ok := pp.repair(ev)
+			rec.Synthesized = append(rec.Synthesized, fixed...)
+			kept = append(kept, fixed...)
+			if !ok || pp.process(ev) != nil {
+				rec.DroppedEvents++
+				continue
+			}
+		}
+		kept = append(kept, ev)
+	}
+	events = kept
+	if !rec.Damaged() || len(events) == 0 {
+		return events
+	}
+	for _, ev := range pp.finish(events[len(events)-1].Ts, size) {
+		if err := pp.process(ev); err != nil {
+			continue
+		}
+		rec.Synthesized = append(rec.Synthesized, ev)
+		events = append(events, ev)
+	}
+	return events
+}
+
+// repair brings the goroutines, Ps and GC phases into the state ev
+// expects, which is lost with the events dropped before ev. It returns
+// the events it synthesized to do so, and false if ev cannot be repaired.
+func (pp *postProcessor) repair(ev *Event) (events []*Event, ok bool) {
+	emit := func(typ byte, p int, g uint64) bool {
+		e := &Event{Off: ev.Off, Type: typ, ver: ev.ver, Ts: ev.Ts, P: p, G: g}
+		if typ == EvGoStart {
+			e.Args[0] = g
+		}
+		if err := pp.process(e); err != nil {
+			return false
+		}
+		events = append(events, e)
+		return true
+	}
+	// force sets the state of g without an event.
+	force := func(g uint64, state int) {
+		d := pp.gs[g]
+		d.state = state
+		d.ev = nil
+		pp.gs[g] = d
+	}
+	// stop stops g if it is running.
+	stop := func(g uint64) bool {
+		if g == 0 || pp.gs[g].state != ppRunning {
+			return true
+		}
+		for p, d := range pp.ps {
+			if d.g == g {
+				return emit(EvGoStop, p, g)
+			}
+		}
+		force(g, ppDead)
+		return true
+	}
+	// run starts g on p if it is not running there.
+	run := func(g uint64, p int) bool {
+		if pp.gs[g].state == ppRunning && pp.ps[p].g == g {
+			return true
+		}
+		if g == 0 || !stop(pp.ps[p].g) || !stop(g) {
+			return false
+		}
+		force(g, ppRunnable)
+		return emit(EvGoStart, p, g)
+	}
+
+	switch ev.Type {
+	case EvProcStart:
+		return events, stop(pp.ps[ev.P].g) && emit(EvProcStop, ev.P, 0)
+	case EvProcStop:
+		if !stop(pp.ps[ev.P].g) {
+			return events, false
+		}
+		if !pp.ps[ev.P].running {
+			return events, emit(EvProcStart, ev.P, 0)
+		}
+		return events, true
+	case EvGCStart:
+		return events, pp.evGC != nil && emit(EvGCDone, GCP, 0)
+	case EvGCSTWStart:
+		return events, emit(EvGCSTWDone, ev.P, 0)
+	case EvGCMarkAssistStart:
+		return events, emit(EvGCMarkAssistDone, ev.P, ev.G)
+	case EvGCSweepStart:
+		return events, emit(EvGCSweepDone, ev.P, 0)
+	case EvGoWaiting, EvGoInSyscall:
+		if !stop(ev.G) {
+			return events, false
+		}
+		force(ev.G, ppRunnable)
+		return events, true
+	case EvGoCreate:
+		if ev.G == 0 {
+			if !stop(pp.ps[ev.P].g) {
+				return events, false
+			}
+		} else if !run(ev.G, ev.P) {
+			return events, false
+		}
+		if _, ok := pp.gs[ev.Args[0]]; ok {
+			if !stop(ev.Args[0]) {
+				return events, false
+			}
+			delete(pp.gs, ev.Args[0])
+		}
+		return events, true
+	case EvGoStart, EvGoStartLabel:
+		if !stop(ev.G) || !stop(pp.ps[ev.P].g) {
+			return events, false
+		}
+		force(ev.G, ppRunnable)
+		return events, true
+	case EvGoEnd, EvGoStop, EvGoSched, EvGoPreempt, EvGoSysCall, EvGoSysBlock,
+		EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoBlockGC:
+		return events, run(ev.G, ev.P)
+	case EvGoUnblock:
+		if ev.G != 0 && !run(ev.G, ev.P) {
+			return events, false
+		}
+		if ev.G == 0 && ev.P != TimerP && !stop(pp.ps[ev.P].g) {
+			return events, false
+		}
+		if g := ev.Args[0]; pp.gs[g].state != ppWaiting {
+			if !stop(g) {
+				return events, false
+			}
+			force(g, ppWaiting)
+		}
+		return events, true
+	case EvGoSysExit:
+		if !stop(ev.G) {
+			return events, false
+		}
+		force(ev.G, ppWaiting)
+		return events, true
+	}
+	return events, false
+}
+
+// finish returns the events that end the goroutines, GC phases and
+// sweeps in progress, at time ts and offset off.
+func (pp *postProcessor) finish(ts int64, off int) []*Event {
+	var events []*Event
+	emit := func(typ byte, p int, g uint64) {
+		events = append(events, &Event{Off: off, Type: typ, ver: uint16(pp.ver), Ts: ts, P: p, G: g})
+	}
+	var ps []int
+	for p := range pp.ps {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	var gs []uint64
+	for g := range pp.gs {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i] < gs[j] })
+
+	for _, p := range ps {
+		if g := pp.ps[p].g; g != 0 {
+			emit(EvGoStop, p, g)
+		}
+	}
+	for _, g := range gs {
+		if ev := pp.gs[g].evMarkAssist; ev != nil {
+			emit(EvGCMarkAssistDone, ev.P, g)
+		}
+	}
+	for _, p := range ps {
+		if pp.ps[p].evSweep != nil {
+			emit(EvGCSweepDone, p, 0)
+		}
+		if pp.ver < 1010 && pp.ps[p].evSTW != nil {
+			emit(EvGCSTWDone, p, 0)
+		}
+	}
+	if pp.evSTW != nil {
+		emit(EvGCSTWDone, pp.evSTW.P, 0)
+	}
+	if pp.evGC != nil {
+		emit(EvGCDone, GCP, 0)
+	}
+	return events
+}
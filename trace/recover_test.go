@@ -0,0 +1,102 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLenientIntact(t *testing.T) {
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		if ver, _ := parseHeader(data[:16]); ver < 1007 {
+			continue
+		}
+		want, err := Parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		events, rec, err := ParseLenient(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v leniently: %v", f, err)
+		}
+		if rec.Damaged() {
+			t.Errorf("%v: intact trace is damaged: %v", f, rec)
+		}
+		if len(events) != len(want) {
+			t.Errorf("%v: got %v events, want %v", f, len(events), len(want))
+		}
+	}
+}
+
+func TestParseLenientTruncated(t *testing.T) {
+	for _, f := range []string{"testdata/stress_1_11_good", "testdata/annotations_1_26_good"} {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		data = data[:len(data)/2]
+		if _, err := Parse(bytes.NewReader(data), nil); err == nil {
+			t.Fatalf("%v: no error on truncated trace", f)
+		}
+		events, rec, err := ParseLenient(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v leniently: %v", f, err)
+		}
+		if rec.Err == nil || rec.Offset+rec.DroppedBytes != len(data) {
+			t.Errorf("%v: truncation is not reported: %v", f, rec)
+		}
+		if len(events) == 0 || len(rec.Synthesized) == 0 {
+			t.Errorf("%v: got %v events, %v", f, len(events), rec)
+		}
+		for _, ev := range events {
+			switch ev.Type {
+			case EvGoStart, EvGoStartLabel, EvGCStart, EvGCSTWStart, EvGCSweepStart, EvGCMarkAssistStart:
+				if ev.Link == nil {
+					t.Errorf("%v: %v is not ended", f, ev)
+				}
+			}
+		}
+	}
+}
+
+func TestParseLenientMutated(t *testing.T) {
+	// Damage anywhere in the trace must not make the recovery panic.
+	data, err := ioutil.ReadFile("testdata/stress_1_11_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 40; i++ {
+		damaged := append([]byte(nil), data...)
+		for n := 1 + rnd.Intn(4); n > 0; n-- {
+			damaged[16+rnd.Intn(len(damaged)-16)] = byte(rnd.Intn(256))
+		}
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					t.Fatalf("mutation %v: ParseLenient panicked: %v", i, err)
+				}
+			}()
+			(&ParseOptions{Quiet: true}).ParseLenient(bytes.NewReader(damaged))
+		}()
+	}
+}
+
+func TestParseLenientEmpty(t *testing.T) {
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	data := w.Bytes()
+	if _, _, err := ParseLenient(bytes.NewReader(data[:len(data)-1]), nil); err == nil {
+		t.Fatalf("no error on trace without events")
+	}
+}
@@ -0,0 +1,64 @@
+package trace
+
+// A clockEdge is a dependency of an event on an event of another P,
+// for example the start of a goroutine on the unblock that made it
+// runnable. With synchronized clocks, to is not earlier than from.
+type clockEdge struct {
+	from, to *Event
+}
+
+// repairClockSkew repairs the timestamps of the ordered events, which are
+// in clock ticks, so that they do not go backwards, and sets ClockAdjusted
+// on the events whose timestamps it changes. It returns the offsets added
+// to the clocks of the Ps.
+//
+// The offsets are estimated so that as many of the edges as possible
+// are satisfied: the clock of a P is advanced when an event on it is
+// earlier than an event on another P it depends on. Clocks that drift
+// apart can not be repaired by offsets, so the timestamps that still go
+// backwards are then raised to the preceding one.
+func repairClockSkew(events []*Event, edges []clockEdge) map[int]int64 {
+	offsets := make(map[int]int64)
+	for _, ev := range events {
+		offsets[ev.P] = 0
+	}
+	// The offsets are the longest paths of the constraint graph, found
+	// by Bellman-Ford. Cycles of unsatisfiable constraints stop it after
+	// as many rounds as there are Ps.
+	for round := 0; round <= len(offsets); round++ {
+		changed := false
+		for _, e := range edges {
+			if d := e.from.Ts + offsets[e.from.P] - e.to.Ts; d > offsets[e.to.P] {
+				offsets[e.to.P] = d
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	min := int64(0)
+	first := true
+	for _, off := range offsets {
+		if first || off < min {
+			min, first = off, false
+		}
+	}
+	for p := range offsets {
+		offsets[p] -= min
+	}
+
+	var last int64
+	for i, ev := range events {
+		ts := ev.Ts + offsets[ev.P]
+		if i > 0 && ts < last {
+			ts = last
+		}
+		if ts != ev.Ts {
+			ev.Ts = ts
+			ev.ClockAdjusted = true
+		}
+		last = ts
+	}
+	return offsets
+}
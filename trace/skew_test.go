@@ -0,0 +1,77 @@
+package trace
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"sort"
+	"testing"
+)
+
+func TestRepairClockSkew(t *testing.T) {
+	// The clock of P 1 is 670 ticks behind that of P 0.
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 1000)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 0, 2, 0, 0)
+	w.emit(EvGoCreate, 100, 3, 0, 0)
+	w.emit(EvBatch, 1, 400)
+	w.emit(EvGoStart, 10, 2, 1)
+	w.emit(EvGoEnd, 10)
+	w.emit(EvGoStart, 10, 3, 1)
+	w.emit(EvGoEnd, 10)
+	data := w.Bytes()
+	if _, err := Parse(bytes.NewReader(data), nil); !errors.Is(err, ErrTimeOrder) {
+		t.Fatalf("got error %v, want ErrTimeOrder", err)
+	}
+
+	events, err := (&ParseOptions{Quiet: true, RepairClockSkew: true}).ParseBytes(data)
+	if err != nil {
+		t.Fatalf("failed to parse trace: %v", err)
+	}
+	type event struct {
+		typ      byte
+		g        uint64
+		ts       int64
+		adjusted bool
+	}
+	want := []event{
+		{EvGoCreate, 0, 0, false},
+		{EvGoStart, 2, 80, true},
+		{EvGoEnd, 2, 90, true},
+		{EvGoCreate, 0, 100, false},
+		{EvGoStart, 3, 100, true},
+		{EvGoEnd, 3, 110, true},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %v events, want %v", len(events), len(want))
+	}
+	for i, ev := range events {
+		if got := (event{ev.Type, ev.G, ev.Ts, ev.ClockAdjusted}); got != want[i] {
+			t.Errorf("event %v: got %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+func TestRepairClockSkewUnordered(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/stress_1_5_unordered")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	_, events, err := parseBytes(data, &ParseOptions{Quiet: true, RepairClockSkew: true}, nil)
+	if err != nil {
+		t.Fatalf("failed to parse unordered trace: %v", err)
+	}
+	if !sort.IsSorted(eventList(events)) {
+		t.Errorf("timestamps are not repaired")
+	}
+	adjusted := 0
+	for _, ev := range events {
+		if ev.ClockAdjusted {
+			adjusted++
+		}
+	}
+	if adjusted == 0 {
+		t.Errorf("no events are flagged as adjusted")
+	}
+}
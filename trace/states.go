@@ -0,0 +1,174 @@
+package trace
+
+import "sort"
+
+// GState is the state of a goroutine during a GInterval.
+type GState int
+
+const (
+	GRunnable GState = iota // ready to run, waiting for a P
+	GRunning                // running on a P
+	GBlocked                // blocked for the reason of the interval
+	GSyscall                // blocked in a syscall, without a P
+	GSleeping               // sleeping in time.Sleep
+)
+
+var gStateNames = [...]string{
+	GRunnable: "runnable",
+	GRunning:  "running",
+	GBlocked:  "blocked",
+	GSyscall:  "syscall",
+	GSleeping: "sleeping",
+}
+
+func (s GState) String() string {
+	if s < 0 || int(s) >= len(gStateNames) {
+		return "unknown"
+	}
+	return gStateNames[s]
+}
+
+// GInterval is an interval of time a goroutine spent in a single state.
+type GInterval struct {
+	G     uint64
+	State GState
+	// Reason is why the goroutine is blocked, like "chan receive" or
+	// "network", for GBlocked. It is empty if the goroutine was
+	// already blocked when tracing started.
+	Reason string
+	P      int      // P the goroutine runs on, for GRunning
+	Stack  []*Frame // stack where the goroutine blocked or entered the syscall
+
+	// Start is the event that put the goroutine in the state, like
+	// EvGoStart for GRunning, EvGoUnblock for GRunnable or EvGoBlockRecv
+	// for GBlocked. It is EvGoCreate, EvGoWaiting or EvGoInSyscall for
+	// goroutines that existed when tracing started.
+	Start *Event
+	// End is the event that took the goroutine out of the state,
+	// or nil if the interval did not end before tracing stopped.
+	End *Event
+
+	// StartTime and EndTime are the interval boundaries.
+	// A missing end is set to the last timestamp in the trace.
+	StartTime int64
+	EndTime   int64
+}
+
+// Duration returns the length of the interval in nanoseconds.
+func (iv *GInterval) Duration() int64 {
+	return iv.EndTime - iv.StartTime
+}
+
+// BlockReason returns why the goroutine of a blocking event blocks.
+// It is "forever" for GoStop, which never returns, and "other" for
+// GoBlock, which is used for the reasons without an event of their own.
+func (ev *Event) BlockReason() (string, bool) {
+	switch ev.Type {
+	case EvGoBlockSend:
+		return "chan send", true
+	case EvGoBlockRecv:
+		return "chan receive", true
+	case EvGoBlockSelect:
+		return "select", true
+	case EvGoBlockSync:
+		return "sync", true
+	case EvGoBlockCond:
+		return "sync.(*Cond).Wait", true
+	case EvGoBlockNet:
+		return "network", true
+	case EvGoBlockGC:
+		return "GC", true
+	case EvGoStop:
+		return "forever", true
+	case EvGoBlock:
+		return "other", true
+	}
+	return "", false
+}
+
+// GoroutineStates splits the life of every goroutine into the intervals
+// it spent in each state, in the order of time. The events must be
+// post-processed by Parse. A goroutine has no interval before its first
+// event and after it ends with GoEnd; one stopped with GoStop stays
+// blocked "forever".
+func GoroutineStates(events []*Event) map[uint64][]*GInterval {
+	states := make(map[uint64][]*GInterval)
+	if len(events) == 0 {
+		return states
+	}
+	lastTs := events[len(events)-1].Ts
+
+	current := make(map[uint64]*GInterval) // open interval by goroutine
+	syscall := make(map[uint64]*Event)     // last EvGoSysCall by goroutine
+	end := func(g uint64, ev *Event) *GInterval {
+		iv := current[g]
+		if iv != nil {
+			iv.End = ev
+			iv.EndTime = ev.Ts
+			delete(current, g)
+		}
+		return iv
+	}
+	begin := func(g uint64, state GState, ev *Event) *GInterval {
+		end(g, ev)
+		iv := &GInterval{G: g, State: state, P: -1, Start: ev, StartTime: ev.Ts, EndTime: lastTs}
+		states[g] = append(states[g], iv)
+		current[g] = iv
+		return iv
+	}
+
+	for _, ev := range events {
+		switch ev.Type {
+		case EvGoCreate:
+			g, _ := ev.TargetG()
+			begin(g, GRunnable, ev)
+		case EvGoWaiting:
+			g, _ := ev.TargetG()
+			begin(g, GBlocked, ev)
+		case EvGoInSyscall:
+			g, _ := ev.TargetG()
+			begin(g, GSyscall, ev)
+		case EvGoStart, EvGoStartLabel:
+			begin(ev.G, GRunning, ev).P = ev.P
+		case EvGoEnd:
+			end(ev.G, ev)
+			delete(syscall, ev.G)
+		case EvGoSched, EvGoPreempt:
+			begin(ev.G, GRunnable, ev).Stack = ev.Stk
+		case EvGoSleep:
+			begin(ev.G, GSleeping, ev).Stack = ev.Stk
+		case EvGoStop, EvGoBlock, EvGoBlockSend, EvGoBlockRecv, EvGoBlockSelect,
+			EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoBlockGC:
+			iv := begin(ev.G, GBlocked, ev)
+			iv.Reason, _ = ev.BlockReason()
+			iv.Stack = ev.Stk
+		case EvGoUnblock:
+			g, _ := ev.TargetG()
+			if iv := current[g]; iv != nil && (iv.State == GBlocked || iv.State == GSleeping) {
+				begin(g, GRunnable, ev)
+			}
+		case EvGoSysCall:
+			syscall[ev.G] = ev
+		case EvGoSysBlock:
+			iv := begin(ev.G, GSyscall, ev)
+			if sc := syscall[ev.G]; sc != nil {
+				iv.Stack = sc.Stk
+			}
+		case EvGoSysExit:
+			g, _ := ev.TargetG()
+			begin(g, GRunnable, ev)
+			delete(syscall, g)
+		}
+	}
+	return states
+}
+
+// StateAt returns the interval that contains time ts among the intervals
+// of a goroutine returned by GoroutineStates.
+func StateAt(intervals []*GInterval, ts int64) (*GInterval, bool) {
+	i := sort.Search(len(intervals), func(i int) bool { return intervals[i].StartTime > ts })
+	if i == 0 || ts > intervals[i-1].EndTime {
+		return nil, false
+	}
+	return intervals[i-1], true
+}
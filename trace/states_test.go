@@ -0,0 +1,84 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGoroutineStates(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/stress_1_11_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	events, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	states := GoroutineStates(events)
+	gs := GoroutineStats(events)
+	for g, ivs := range states {
+		var exec int64
+		for i, iv := range ivs {
+			if iv.G != g || iv.EndTime < iv.StartTime {
+				t.Errorf("g %v: bad interval %+v", g, iv)
+			}
+			if i > 0 && (ivs[i-1].End != iv.Start || ivs[i-1].EndTime != iv.StartTime) {
+				t.Errorf("g %v: interval %v does not follow the previous one", g, i)
+			}
+			if iv.State == GRunning {
+				exec += iv.Duration()
+			}
+			if iv.State == GBlocked && iv.Start.Type != EvGoWaiting && iv.Reason == "" {
+				t.Errorf("g %v: no reason for blocking at %v", g, iv.Start)
+			}
+		}
+		if gd := gs[g]; gd != nil && gd.ExecTime != exec {
+			t.Errorf("g %v: running for %v, want exec time %v", g, exec, gd.ExecTime)
+		}
+		last := ivs[len(ivs)-1]
+		if iv, ok := StateAt(ivs, last.StartTime); !ok || iv != last {
+			t.Errorf("g %v: got state %v at %v, want %v", g, iv, last.StartTime, last)
+		}
+	}
+}
+
+func TestGoroutineStatesReasons(t *testing.T) {
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoCreate, 1, 2, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoBlockRecv, 1, 0)
+	w.emit(EvGoStart, 1, 2, 1)
+	w.emit(EvGoUnblock, 1, 1, 2, 0)
+	w.emit(EvGoSleep, 1, 0)
+	w.emit(EvGoStart, 1, 1, 3)
+	w.emit(EvGoEnd, 1)
+	events, err := Parse(w, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	states := GoroutineStates(events)
+	got := func(g uint64) []string {
+		var s []string
+		for _, iv := range states[g] {
+			s = append(s, strings.TrimSpace(iv.State.String()+" "+iv.Reason))
+		}
+		return s
+	}
+	if g1, want := got(1), []string{"runnable", "running", "blocked chan receive", "runnable", "running"}; strings.Join(g1, " ") != strings.Join(want, " ") {
+		t.Errorf("got states %v of g 1, want %v", g1, want)
+	}
+	if g2, want := got(2), []string{"runnable", "running", "sleeping"}; strings.Join(g2, " ") != strings.Join(want, " ") {
+		t.Errorf("got states %v of g 2, want %v", g2, want)
+	}
+	if iv := states[1][len(states[1])-1]; iv.End == nil || iv.End.Type != EvGoEnd {
+		t.Errorf("last interval of g 1 ends with %v, want GoEnd", iv.End)
+	}
+	if iv := states[2][len(states[2])-1]; iv.End != nil {
+		t.Errorf("sleep of g 2 ends with %v before tracing stopped", iv.End)
+	}
+}
@@ -0,0 +1,191 @@
+package trace
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CachedSymbolizer returns a Symbolizer that caches the frames returned
+// by s for the binary bin in files in dir, so that the PCs of the binary
+// are symbolized by s only once. The files are keyed by the build ID of
+// the binary, or by the hash of its contents if it has none. If s is an
+// InlineSymbolizer, so is the returned Symbolizer.
+//
+// Failures to read or write the cache are ignored; the PCs are then
+// symbolized by s.
+func CachedSymbolizer(s Symbolizer, bin, dir string) Symbolizer {
+	c := &cachedSymbolizer{s: s, bin: bin, dir: dir}
+	if is, ok := s.(InlineSymbolizer); ok {
+		c.inline = is
+		return &cachedInlineSymbolizer{c}
+	}
+	return c
+}
+
+type cachedSymbolizer struct {
+	s      Symbolizer
+	inline InlineSymbolizer // s, if it is an InlineSymbolizer
+	bin    string
+	dir    string
+}
+
+type cachedInlineSymbolizer struct {
+	*cachedSymbolizer
+}
+
+func (c *cachedInlineSymbolizer) SymbolizeInline(pcs []uint64) (map[uint64][]*Frame, error) {
+	return c.symbolize(pcs)
+}
+
+func (c *cachedSymbolizer) Symbolize(pcs []uint64) (map[uint64]*Frame, error) {
+	frames, err := c.symbolize(pcs)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[uint64]*Frame, len(frames))
+	for pc, fs := range frames {
+		ret[pc] = fs[len(fs)-1]
+	}
+	return ret, nil
+}
+
+// symbolize returns the frames of the PCs, innermost first, from the
+// cache or from the underlying Symbolizer.
+func (c *cachedSymbolizer) symbolize(pcs []uint64) (map[uint64][]*Frame, error) {
+	file := c.file()
+	cache := make(map[uint64][]*Frame)
+	if file != "" {
+		readSymbolCache(file, cache)
+	}
+	var missing []uint64
+	for _, pc := range pcs {
+		if _, ok := cache[pc]; !ok {
+			missing = append(missing, pc)
+		}
+	}
+	if len(missing) > 0 {
+		if c.inline != nil {
+			frames, err := c.inline.SymbolizeInline(missing)
+			if err != nil {
+				return nil, err
+			}
+			for pc, fs := range frames {
+				cache[pc] = fs
+			}
+		} else {
+			frames, err := c.s.Symbolize(missing)
+			if err != nil {
+				return nil, err
+			}
+			for pc, f := range frames {
+				cache[pc] = []*Frame{f}
+			}
+		}
+		if file != "" {
+			writeSymbolCache(file, cache)
+		}
+	}
+	ret := make(map[uint64][]*Frame, len(pcs))
+	for _, pc := range pcs {
+		if fs := cache[pc]; len(fs) > 0 {
+			ret[pc] = fs
+		}
+	}
+	return ret, nil
+}
+
+// file returns the name of the cache file of the binary, or "" if the
+// binary cannot be read.
+func (c *cachedSymbolizer) file() string {
+	id, err := buildID(c.bin)
+	if err != nil {
+		return ""
+	}
+	// Frames of a plain Symbolizer lack the inlined calls, so they are
+	// cached apart.
+	kind := "plain"
+	if c.inline != nil {
+		kind = "inline"
+	}
+	h := sha256.Sum256([]byte(id))
+	return filepath.Join(c.dir, fmt.Sprintf("%x.%s", h[:16], kind))
+}
+
+func readSymbolCache(file string, cache map[uint64][]*Frame) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	gob.NewDecoder(f).Decode(&cache)
+}
+
+// writeSymbolCache writes the cache to file. The file is replaced
+// atomically, so that concurrent runs read a complete cache.
+func writeSymbolCache(file string, cache map[uint64][]*Frame) {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return
+	}
+	err = gob.NewEncoder(f).Encode(cache)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+}
+
+// buildID returns the Go or GNU build ID of the ELF binary bin, or the
+// hash of its contents if it has none.
+func buildID(bin string) (string, error) {
+	if f, err := elf.Open(bin); err == nil {
+		defer f.Close()
+		for _, name := range []string{".note.go.buildid", ".note.gnu.build-id"} {
+			if s := f.Section(name); s != nil {
+				if data, err := s.Data(); err == nil {
+					if desc := elfNoteDesc(data, f.ByteOrder); len(desc) > 0 {
+						return name + ":" + string(desc), nil
+					}
+				}
+			}
+		}
+	}
+	f, err := os.Open(bin)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("sha256:%x", h.Sum(nil)), nil
+}
+
+// elfNoteDesc returns the descriptor of the first note in data.
+func elfNoteDesc(data []byte, order binary.ByteOrder) []byte {
+	if len(data) < 12 {
+		return nil
+	}
+	namesz, descsz := order.Uint32(data), order.Uint32(data[4:])
+	off := 12 + (uint64(namesz)+3)&^3
+	if off+uint64(descsz) > uint64(len(data)) {
+		return nil
+	}
+	return bytes.TrimRight(data[off:off+uint64(descsz)], "\x00")
+}
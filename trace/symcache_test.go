@@ -0,0 +1,105 @@
+package trace
+
+import (
+	"debug/elf"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// countingSymbolizer symbolizes PCs as functions named after them and
+// records the PCs it was asked for.
+type countingSymbolizer struct {
+	asked []uint64
+}
+
+func (s *countingSymbolizer) Symbolize(pcs []uint64) (map[uint64]*Frame, error) {
+	s.asked = append(s.asked, pcs...)
+	ret := make(map[uint64]*Frame)
+	for _, pc := range pcs {
+		ret[pc] = &Frame{PC: pc, Fn: fmt.Sprintf("fn%d", pc), File: "file.go", Line: int(pc)}
+	}
+	return ret, nil
+}
+
+func TestCachedSymbolizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	bin := filepath.Join(dir, "bin")
+	if err := ioutil.WriteFile(bin, []byte("not a binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+
+	s1 := new(countingSymbolizer)
+	frames1, err := CachedSymbolizer(s1, bin, cacheDir).Symbolize([]uint64{1, 2})
+	if err != nil {
+		t.Fatalf("failed to symbolize: %v", err)
+	}
+	if len(s1.asked) != 2 {
+		t.Errorf("symbolized %v, want 1 and 2", s1.asked)
+	}
+
+	// A new run symbolizes only the PCs that are not cached.
+	s2 := new(countingSymbolizer)
+	cs := CachedSymbolizer(s2, bin, cacheDir)
+	if _, ok := cs.(InlineSymbolizer); ok {
+		t.Errorf("cached plain Symbolizer is an InlineSymbolizer")
+	}
+	frames2, err := cs.Symbolize([]uint64{1, 2, 3})
+	if err != nil {
+		t.Fatalf("failed to symbolize: %v", err)
+	}
+	if len(s2.asked) != 1 || s2.asked[0] != 3 {
+		t.Errorf("symbolized %v, want 3", s2.asked)
+	}
+	for _, pc := range []uint64{1, 2} {
+		if *frames1[pc] != *frames2[pc] {
+			t.Errorf("pc %v: got cached frame %+v, want %+v", pc, frames2[pc], frames1[pc])
+		}
+	}
+	if f := frames2[3]; f == nil || f.Fn != "fn3" {
+		t.Errorf("pc 3: got frame %+v", f)
+	}
+
+	// Another binary has a cache of its own.
+	if err := ioutil.WriteFile(bin, []byte("another binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s3 := new(countingSymbolizer)
+	if _, err := CachedSymbolizer(s3, bin, cacheDir).Symbolize([]uint64{1}); err != nil {
+		t.Fatalf("failed to symbolize: %v", err)
+	}
+	if len(s3.asked) != 1 {
+		t.Errorf("symbolized %v for another binary, want 1", s3.asked)
+	}
+
+	if _, ok := CachedSymbolizer(ELFSymbolizer(bin), bin, cacheDir).(InlineSymbolizer); !ok {
+		t.Errorf("cached InlineSymbolizer is not an InlineSymbolizer")
+	}
+}
+
+func TestBuildID(t *testing.T) {
+	bin, err := os.Executable()
+	if err != nil {
+		t.Skipf("no executable: %v", err)
+	}
+	id, err := buildID(bin)
+	if err != nil {
+		t.Fatalf("failed to get build ID: %v", err)
+	}
+	if f, err := elf.Open(bin); err == nil {
+		f.Close()
+		if !strings.HasPrefix(id, ".note.go.buildid:") {
+			t.Errorf("got build ID %q, want the Go build ID of the test binary", id)
+		}
+	} else if !strings.HasPrefix(id, "sha256:") {
+		t.Errorf("got build ID %q, want the hash of the test binary", id)
+	}
+}
@@ -0,0 +1,187 @@
+package trace
+
+import "sort"
+
+// Thread is an OS thread (an M of the runtime) seen in a trace.
+type Thread struct {
+	Process int    // process of the thread in a merged trace, 0 otherwise
+	ID      uint64 // thread id recorded by EvProcStart
+	Spans   []*ThreadSpan
+}
+
+// ThreadSpan is an interval during which a thread either held a P or
+// was blocked in a syscall without a P.
+type ThreadSpan struct {
+	Thread *Thread
+	P      int    // P held by the thread, -1 in a syscall
+	G      uint64 // goroutine blocked in the syscall, 0 while holding a P
+
+	// Start is the EvProcStart event, or EvGoSysBlock for syscalls.
+	Start *Event
+	// End is the EvProcStop event, EvGoSysBlock if the thread lost its
+	// P in a syscall, EvProcStart or EvGoSysExit for syscalls, or nil if
+	// the span did not end before tracing stopped.
+	End *Event
+
+	// StartTime and EndTime are the span boundaries. A missing end
+	// is set to the last timestamp in the trace.
+	StartTime int64
+	EndTime   int64
+}
+
+// InSyscall reports whether the thread was blocked in a syscall.
+func (s *ThreadSpan) InSyscall() bool {
+	return s.P < 0
+}
+
+// ThreadCount is the number of threads at some point of the trace.
+type ThreadCount struct {
+	Ts        int64
+	Total     int // threads seen so far
+	Running   int // threads holding a P
+	InSyscall int // threads blocked in a syscall without a P
+}
+
+// ThreadInfo holds the timelines of the threads found in a trace.
+type ThreadInfo struct {
+	Threads []*Thread     // all threads, by process and id
+	Counts  []ThreadCount // thread counts at every change, in the order of time
+
+	held map[procP][]*ThreadSpan // spans holding each P, in the order of start
+}
+
+type procP struct {
+	process, p int
+}
+
+type threadKey struct {
+	process int
+	id      uint64
+}
+
+type procG struct {
+	process int
+	g       uint64
+}
+
+// Threads reconstructs the thread timelines from the EvProcStart events,
+// which record the thread that started a P, and the syscall events that
+// take the P away from it. The events must be post-processed by Parse.
+// Threads in syscalls at the start of the trace are not known and the
+// threads that never held a P are not seen.
+func Threads(events []*Event) *ThreadInfo {
+	info := &ThreadInfo{held: make(map[procP][]*ThreadSpan)}
+	if len(events) == 0 {
+		return info
+	}
+	lastTs := events[len(events)-1].Ts
+
+	threads := make(map[threadKey]*Thread)
+	holders := make(map[procP]*ThreadSpan)  // open spans holding a P
+	syscalls := make(map[procG]*ThreadSpan) // open syscall spans by goroutine
+	var running, insyscall int
+	count := func(ts int64) {
+		info.Counts = append(info.Counts, ThreadCount{Ts: ts, Total: len(threads), Running: running, InSyscall: insyscall})
+	}
+	end := func(s *ThreadSpan, ev *Event) {
+		s.End = ev
+		s.EndTime = ev.Ts
+	}
+
+	for _, ev := range events {
+		pp := procP{ev.Process, ev.P}
+		switch ev.Type {
+		case EvProcStart:
+			id, _ := ev.ThreadID()
+			key := threadKey{ev.Process, id}
+			t := threads[key]
+			if t == nil {
+				t = &Thread{Process: ev.Process, ID: id}
+				threads[key] = t
+				info.Threads = append(info.Threads, t)
+			}
+			if n := len(t.Spans); n > 0 && t.Spans[n-1].InSyscall() && t.Spans[n-1].End == nil {
+				// The thread left the syscall to acquire a P. The
+				// GoSysExit of the goroutine comes when it runs again.
+				sys := t.Spans[n-1]
+				end(sys, ev)
+				delete(syscalls, procG{ev.Process, sys.G})
+				insyscall--
+			}
+			if prev := holders[pp]; prev != nil {
+				// The stop of the previous holder is missing.
+				end(prev, ev)
+				running--
+			}
+			s := &ThreadSpan{Thread: t, P: ev.P, Start: ev, StartTime: ev.Ts, EndTime: lastTs}
+			t.Spans = append(t.Spans, s)
+			holders[pp] = s
+			info.held[pp] = append(info.held[pp], s)
+			running++
+			count(ev.Ts)
+		case EvProcStop:
+			if s := holders[pp]; s != nil {
+				end(s, ev)
+				delete(holders, pp)
+				running--
+				count(ev.Ts)
+			}
+		case EvGoSysBlock:
+			// The thread stays in the syscall with the goroutine,
+			// and the P is handed off to another thread.
+			s := holders[pp]
+			if s == nil {
+				continue
+			}
+			end(s, ev)
+			delete(holders, pp)
+			running--
+			sys := &ThreadSpan{Thread: s.Thread, P: -1, G: ev.G, Start: ev, StartTime: ev.Ts, EndTime: lastTs}
+			s.Thread.Spans = append(s.Thread.Spans, sys)
+			syscalls[procG{ev.Process, ev.G}] = sys
+			insyscall++
+			count(ev.Ts)
+		case EvGoSysExit:
+			g, _ := ev.TargetG()
+			key := procG{ev.Process, g}
+			if sys := syscalls[key]; sys != nil {
+				end(sys, ev)
+				delete(syscalls, key)
+				insyscall--
+				count(ev.Ts)
+			}
+		}
+	}
+
+	sort.Slice(info.Threads, func(i, j int) bool {
+		a, b := info.Threads[i], info.Threads[j]
+		if a.Process != b.Process {
+			return a.Process < b.Process
+		}
+		return a.ID < b.ID
+	})
+	return info
+}
+
+// ThreadOf returns the thread that held the P of ev when ev happened.
+// It returns false for events of the fake Ps and for events outside of
+// any span of a thread.
+func (info *ThreadInfo) ThreadOf(ev *Event) (*Thread, bool) {
+	if ev.P < 0 || ev.P >= FakeP {
+		return nil, false
+	}
+	spans := info.held[procP{ev.Process, ev.P}]
+	i := sort.Search(len(spans), func(i int) bool { return spans[i].StartTime > ev.Ts })
+	if i == 0 {
+		return nil, false
+	}
+	s := spans[i-1]
+	if i > 1 && spans[i-2].End == ev {
+		// The P was stopped and started again at the same time.
+		s = spans[i-2]
+	}
+	if ev.Ts > s.EndTime {
+		return nil, false
+	}
+	return s.Thread, true
+}
@@ -0,0 +1,83 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestThreads(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/stress_1_11_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	events, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	info := Threads(events)
+	if len(info.Threads) == 0 {
+		t.Fatalf("no threads found")
+	}
+	for _, th := range info.Threads {
+		var last int64
+		for _, s := range th.Spans {
+			if s.Thread != th {
+				t.Errorf("span of thread %v belongs to thread %v", th.ID, s.Thread.ID)
+			}
+			if s.StartTime < last || s.EndTime < s.StartTime {
+				t.Errorf("thread %v: span %v-%v overlaps or is reversed", th.ID, s.StartTime, s.EndTime)
+			}
+			last = s.EndTime
+		}
+	}
+	for _, ev := range events {
+		if ev.Type != EvProcStart {
+			continue
+		}
+		id, _ := ev.ThreadID()
+		if th, ok := info.ThreadOf(ev); !ok || th.ID != id {
+			t.Errorf("%v: got thread %v, want %v", ev, th, id)
+		}
+	}
+	for _, c := range info.Counts {
+		if c.Running < 0 || c.InSyscall < 0 || c.Running+c.InSyscall > c.Total {
+			t.Errorf("bad thread count %+v", c)
+		}
+	}
+	if last := info.Counts[len(info.Counts)-1]; last.Total != len(info.Threads) {
+		t.Errorf("got %v threads in the last count, want %v", last.Total, len(info.Threads))
+	}
+}
+
+func TestThreadsSyscall(t *testing.T) {
+	// The thread that blocks in a syscall keeps the goroutine
+	// until it exits the syscall, while the P is stopped.
+	data, err := ioutil.ReadFile("testdata/syscall_steal_1_22_good")
+	if err != nil {
+		t.Fatalf("failed to read input file: %v", err)
+	}
+	events, err := Parse(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	info := Threads(events)
+	var sys *ThreadSpan
+	for _, th := range info.Threads {
+		for _, s := range th.Spans {
+			if s.InSyscall() {
+				sys = s
+			}
+		}
+	}
+	if sys == nil {
+		t.Fatalf("no thread in a syscall")
+	}
+	if sys.Start.Type != EvGoSysBlock || sys.End == nil || sys.End.Type != EvGoSysExit || sys.G != sys.Start.G {
+		t.Errorf("bad syscall span: start %v, end %v, g %v", sys.Start, sys.End, sys.G)
+	}
+	spans := sys.Thread.Spans
+	if len(spans) < 2 || spans[len(spans)-2].End != sys.Start {
+		t.Errorf("syscall span does not follow the P of the thread: %v", spans)
+	}
+}
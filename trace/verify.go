@@ -0,0 +1,250 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// A Violation is a broken invariant of a trace found while parsing it
+// leniently.
+type Violation struct {
+	*ParseError
+	// Expected and Actual describe the state of the goroutines, Ps or
+	// GC the event expects and the state it found, if known.
+	Expected string
+	Actual   string
+
+	ev *Event // event whose timestamp is not yet in nanoseconds
+}
+
+func (v *Violation) String() string {
+	s := fmt.Sprintf("%v: %v", v.Kind, v.ParseError)
+	if v.Expected != "" || v.Actual != "" {
+		s += fmt.Sprintf("\n\texpected: %v\n\tactual:   %v", v.Expected, v.Actual)
+	}
+	return s
+}
+
+// MarshalJSON encodes the violation with the name of its kind and its
+// event type.
+func (v *Violation) MarshalJSON() ([]byte, error) {
+	type violation struct {
+		Kind     string `json:"kind"`
+		Off      int    `json:"offset"`
+		Event    string `json:"event,omitempty"`
+		G        uint64 `json:"g"`
+		P        int    `json:"p"`
+		Ts       int64  `json:"ts"`
+		Msg      string `json:"message"`
+		Expected string `json:"expected,omitempty"`
+		Actual   string `json:"actual,omitempty"`
+	}
+	jv := violation{Kind: v.Kind.String(), Off: v.Off, G: v.G, P: v.P, Ts: v.Ts, Msg: v.Msg, Expected: v.Expected, Actual: v.Actual}
+	if v.Type != EvNone && v.Type < EvCount {
+		jv.Event = EventDescriptions[v.Type].Name
+	}
+	return json.Marshal(jv)
+}
+
+// violate records a violation in rec.
+func (rec *Recovery) violate(err error, expected, actual string) *Violation {
+	pe, ok := err.(*ParseError)
+	if !ok {
+		pe = &ParseError{Kind: KindWireFormat, Off: -1, P: -1, Msg: err.Error()}
+	}
+	v := &Violation{ParseError: pe, Expected: expected, Actual: actual}
+	rec.Violations = append(rec.Violations, v)
+	return v
+}
+
+// A Report is the result of Verify.
+type Report struct {
+	Version int // version of the trace, for example 1011 for Go 1.11
+	Size    int // size of the trace in bytes
+	Events  int // number of events that were verified
+	*Recovery
+}
+
+// Verify parses the trace leniently like ParseLenient and reports every
+// consistency violation it finds, rather than stopping at the first one.
+// An error is returned only if the trace cannot be read or has no
+// events. Traces produced by Go 1.6 and below are verified without
+// symbolizing them.
+func Verify(r io.Reader) (*Report, error) {
+	return (&ParseOptions{Quiet: true}).Verify(r)
+}
+
+// Verify is like the package function Verify but with the options.
+func (opts *ParseOptions) Verify(r io.Reader) (*Report, error) {
+	data, err := opts.readAll(r)
+	if err != nil {
+		return nil, err
+	}
+	opts1 := *opts
+	opts1.Symbolizer = nil
+	rec := new(Recovery)
+	ver, events, err := parseBytes(data, &opts1, rec)
+	if err == nil && len(events) == 0 && rec.Err != nil {
+		err = rec.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rec.Err != nil {
+		rec.DroppedBytes = len(data) - rec.Offset
+	}
+	return &Report{Version: ver, Size: len(data), Events: len(events), Recovery: rec}, nil
+}
+
+// Valid reports whether the trace has no violations.
+func (r *Report) Valid() bool {
+	return len(r.Violations) == 0 && !r.Damaged()
+}
+
+// WriteText writes the report as text to w.
+func (r *Report) WriteText(w io.Writer) error {
+	fmt.Fprintf(w, "go 1.%d trace, %d bytes, %d events\n", r.Version%1000, r.Size, r.Events)
+	for _, v := range r.Violations {
+		fmt.Fprintf(w, "%v\n", v)
+	}
+	_, err := fmt.Fprintf(w, "%d violations; %v\n", len(r.Violations), r.Recovery)
+	return err
+}
+
+// WriteJSON writes the report as JSON to w.
+func (r *Report) WriteJSON(w io.Writer) error {
+	type report struct {
+		Version       string       `json:"version"`
+		Size          int          `json:"size"`
+		Events        int          `json:"events"`
+		Valid         bool         `json:"valid"`
+		DroppedBytes  int          `json:"droppedBytes"`
+		DroppedEvents int          `json:"droppedEvents"`
+		Synthesized   int          `json:"synthesizedEvents"`
+		NoFrequency   bool         `json:"noFrequency,omitempty"`
+		Violations    []*Violation `json:"violations"`
+	}
+	jr := report{
+		Version:       fmt.Sprintf("go1.%d", r.Version%1000),
+		Size:          r.Size,
+		Events:        r.Events,
+		Valid:         r.Valid(),
+		DroppedBytes:  r.DroppedBytes,
+		DroppedEvents: r.DroppedEvents,
+		Synthesized:   len(r.Synthesized),
+		NoFrequency:   r.NoFrequency,
+		Violations:    r.Violations,
+	}
+	if jr.Violations == nil {
+		jr.Violations = []*Violation{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(jr)
+}
+
+// describe returns the state of the goroutines, Ps and GC ev expects,
+// and the state it finds, for a violation by ev.
+func (pp *postProcessor) describe(ev *Event) (expected, actual string) {
+	switch ev.Type {
+	case EvProcStart:
+		return fmt.Sprintf("p %v stopped", ev.P), pp.pState(ev.P)
+	case EvProcStop:
+		return fmt.Sprintf("p %v running no goroutine", ev.P), pp.pState(ev.P)
+	case EvGCStart:
+		return "no GC in progress", pp.phase("GC", pp.evGC)
+	case EvGCDone:
+		return "GC in progress", pp.phase("GC", pp.evGC)
+	case EvGCSTWStart, EvGCSTWDone:
+		evSTW := pp.evSTW
+		if pp.ver < 1010 {
+			evSTW = pp.ps[ev.P].evSTW
+		}
+		if ev.Type == EvGCSTWStart {
+			return "no STW in progress", pp.phase("STW", evSTW)
+		}
+		return "STW in progress", pp.phase("STW", evSTW)
+	case EvGCMarkAssistStart:
+		return fmt.Sprintf("no mark assist of g %v in progress", ev.G), pp.phase("mark assist", pp.gs[ev.G].evMarkAssist)
+	case EvGCSweepStart:
+		return fmt.Sprintf("no sweep on p %v in progress", ev.P), pp.phase("sweep", pp.ps[ev.P].evSweep)
+	case EvGCSweepDone:
+		return fmt.Sprintf("sweep on p %v in progress", ev.P), pp.phase("sweep", pp.ps[ev.P].evSweep)
+	case EvGoWaiting, EvGoInSyscall:
+		return fmt.Sprintf("g %v runnable", ev.G), pp.gState(ev.G)
+	case EvGoCreate:
+		return fmt.Sprintf("g %v running on p %v; g %v not created", ev.G, ev.P, ev.Args[0]),
+			pp.gState(ev.G) + "; " + pp.gState(ev.Args[0])
+	case EvGoStart, EvGoStartLabel:
+		return fmt.Sprintf("g %v runnable; p %v running no goroutine", ev.G, ev.P),
+			pp.gState(ev.G) + "; " + pp.pState(ev.P)
+	case EvGoEnd, EvGoStop, EvGoSched, EvGoPreempt, EvGoSysCall, EvGoSysBlock,
+		EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet, EvGoBlockGC:
+		return fmt.Sprintf("g %v running on p %v", ev.G, ev.P), pp.gState(ev.G) + "; " + pp.pState(ev.P)
+	case EvGoUnblock:
+		return fmt.Sprintf("g %v running on p %v; g %v waiting", ev.G, ev.P, ev.Args[0]),
+			pp.gState(ev.G) + "; " + pp.gState(ev.Args[0])
+	case EvGoSysExit:
+		return fmt.Sprintf("g %v waiting", ev.G), pp.gState(ev.G)
+	case EvUserTaskCreate:
+		return fmt.Sprintf("task %v not created", ev.Args[0]), fmt.Sprintf("task %v created by %v", ev.Args[0], pp.tasks[ev.Args[0]])
+	case EvUserRegion:
+		regions := pp.activeRegions[ev.G]
+		if len(regions) == 0 {
+			return "", ""
+		}
+		return fmt.Sprintf("innermost region of g %v is %q", ev.G, ev.SArgs[0]),
+			fmt.Sprintf("innermost region of g %v is %q", ev.G, regions[len(regions)-1].SArgs[0])
+	}
+	return "", ""
+}
+
+// gState describes the state of goroutine g.
+func (pp *postProcessor) gState(g uint64) string {
+	d, ok := pp.gs[g]
+	if !ok {
+		return fmt.Sprintf("g %v not created", g)
+	}
+	switch d.state {
+	case ppRunnable:
+		return fmt.Sprintf("g %v runnable", g)
+	case ppRunning:
+		var ps []int
+		for p, d := range pp.ps {
+			if d.g == g {
+				ps = append(ps, p)
+			}
+		}
+		sort.Ints(ps)
+		if len(ps) == 0 {
+			return fmt.Sprintf("g %v running", g)
+		}
+		return fmt.Sprintf("g %v running on p %v", g, ps[0])
+	case ppWaiting:
+		return fmt.Sprintf("g %v waiting", g)
+	}
+	return fmt.Sprintf("g %v dead", g)
+}
+
+// pState describes the state of P p.
+func (pp *postProcessor) pState(p int) string {
+	d := pp.ps[p]
+	switch {
+	case !d.running:
+		return fmt.Sprintf("p %v stopped", p)
+	case d.g != 0:
+		return fmt.Sprintf("p %v running g %v", p, d.g)
+	}
+	return fmt.Sprintf("p %v running no goroutine", p)
+}
+
+// phase describes whether the phase started by ev is in progress.
+func (pp *postProcessor) phase(name string, ev *Event) string {
+	if ev == nil {
+		return fmt.Sprintf("no %v in progress", name)
+	}
+	return fmt.Sprintf("%v in progress since %v (offset %v)", name, ev.Ts, ev.Off)
+}
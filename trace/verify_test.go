@@ -0,0 +1,89 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyIntact(t *testing.T) {
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		r, err := Verify(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to verify %v: %v", f, err)
+		}
+		if !r.Valid() {
+			var buf bytes.Buffer
+			r.WriteText(&buf)
+			t.Errorf("%v: intact trace is not valid:\n%s", f, buf.Bytes())
+		}
+	}
+}
+
+func TestVerifyViolations(t *testing.T) {
+	w := newWriterVersion("1.10")
+	w.emit(EvBatch, 0, 0)
+	w.emit(EvFrequency, 1e9)
+	w.emit(EvGoCreate, 1, 1, 0, 0)
+	w.emit(EvGoStart, 1, 1, 1)
+	w.emit(EvGoEnd, 1)
+	w.emit(EvProcStop, 1)      // p 0 was not started
+	w.emit(EvGoStart, 1, 2, 1) // g 2 was not created
+	w.emit(EvGoEnd, 1)
+	data := w.Bytes()
+	if _, err := Parse(bytes.NewReader(data), nil); err == nil {
+		t.Fatalf("no error on broken trace")
+	}
+
+	r, err := Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to verify: %v", err)
+	}
+	kinds := make(map[ErrorKind]int)
+	for _, v := range r.Violations {
+		kinds[v.Kind]++
+		if v.Type == EvProcStop && (v.Expected != "p 0 running no goroutine" || v.Actual != "p 0 stopped") {
+			t.Errorf("%v: got expected %q, actual %q", v, v.Expected, v.Actual)
+		}
+	}
+	if kinds[KindOrdering] != 1 || kinds[KindStateMachine] < 2 {
+		t.Errorf("got violations %v, want an ordering one and state-machine ones", r.Violations)
+	}
+
+	var text bytes.Buffer
+	if err := r.WriteText(&text); err != nil {
+		t.Fatalf("failed to write text report: %v", err)
+	}
+	if !strings.Contains(text.String(), "p 0 is not running before stop") {
+		t.Errorf("text report does not contain the violations:\n%s", text.Bytes())
+	}
+	var buf bytes.Buffer
+	if err := r.WriteJSON(&buf); err != nil {
+		t.Fatalf("failed to write JSON report: %v", err)
+	}
+	var jr struct {
+		Valid      bool
+		Violations []struct {
+			Kind     string
+			Event    string
+			Expected string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &jr); err != nil {
+		t.Fatalf("failed to decode JSON report: %v\n%s", err, buf.Bytes())
+	}
+	if jr.Valid || len(jr.Violations) != len(r.Violations) || jr.Violations[0].Kind != "ordering" || jr.Violations[0].Event != "GoStart" {
+		t.Errorf("bad JSON report:\n%s", buf.Bytes())
+	}
+}
@@ -0,0 +1,357 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A Writer encodes events in the trace format of Go 1.11, or of an
+// earlier version, which Parse and go tool trace accept. The events
+// must be written in order and be consistent, like the events returned
+// by Parse; a subset of them is consistent if, for example, every
+// goroutine that is started was created before.
+//
+// Parsing the written trace returns the same events, except that
+// timestamps start at 0, sequence numbers are renumbered, the G of
+// GC events is 0 and the real timestamp of syscall exits is not
+// recorded. Stack traces are written under their StkID. Events of
+// traces produced before Go 1.7 lose the stack of goroutine starts.
+//
+// There is no Writer of the format of Go 1.22 and later. Traces produced
+// by these versions are lowered to the format of Go 1.11: stop-the-world
+// phases other than sweep termination are written as mark termination,
+// and events that have no stack in the earlier formats lose theirs.
+type Writer struct {
+	w       io.Writer
+	ver     int
+	err     error
+	started bool
+	base    int64 // timestamp of the first event
+	lastTs  int64
+
+	batches map[int]*writerBatch
+	lastG   map[int]uint64 // goroutine the parser will infer on each P
+	running map[uint64]int // P each goroutine runs on
+	seq     map[uint64]uint64
+	gcSeq   uint64
+	timerG  uint64
+
+	strings  map[string]uint64
+	stacks   map[uint64][]*Frame
+	stackIDs []uint64
+}
+
+// writerBatch is the pending batch of events of a P.
+type writerBatch struct {
+	buf    []byte
+	ts     int64 // timestamp of the batch
+	lastTs int64 // timestamp of the last event in the batch
+}
+
+// maxWriterBatch is the size at which the batch of a P is written out,
+// the size of the trace buffers of the runtime.
+const maxWriterBatch = 64 << 10
+
+// NewWriter returns a Writer that writes a trace to w.
+// Close must be called to complete the trace.
+func NewWriter(w io.Writer) *Writer {
+	tw, _ := NewWriterVersion(w, 1011)
+	return tw
+}
+
+// NewWriterVersion is like NewWriter but writes the trace format of
+// the given version, between 1007 (Go 1.7) and 1011 (Go 1.11). Other
+// versions are rejected. Events of traces produced before Go 1.10 can
+// only be written in a format before 1010, because their GC
+// stop-the-world phases may overlap.
+func NewWriterVersion(w io.Writer, ver int) (*Writer, error) {
+	if ver < 1007 || ver > 1011 {
+		return nil, fmt.Errorf("cannot write trace format version %v", ver)
+	}
+	return &Writer{
+		w:       w,
+		ver:     ver,
+		batches: make(map[int]*writerBatch),
+		lastG:   make(map[int]uint64),
+		running: make(map[uint64]int),
+		seq:     make(map[uint64]uint64),
+		strings: make(map[string]uint64),
+		stacks:  make(map[uint64][]*Frame),
+	}, nil
+}
+
+// WriterVersion returns the trace format version, suitable for
+// NewWriterVersion, in which the events of a trace of version ver are
+// best written. It is 1011 for traces of Go 1.22 and later, which are
+// written lossily as described for Writer.
+func WriterVersion(ver int) int {
+	switch {
+	case ver < 1007:
+		return 1007
+	case ver > 1011:
+		return 1011
+	}
+	return ver
+}
+
+// WriteTrace writes the events as a complete trace to w.
+func WriteTrace(w io.Writer, events []*Event) error {
+	tw := NewWriter(w)
+	for _, ev := range events {
+		if err := tw.WriteEvent(ev); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// WriteEvent writes the next event of the trace.
+// The event is not modified.
+func (w *Writer) WriteEvent(ev *Event) error {
+	if w.err != nil {
+		return w.err
+	}
+	if ev.Type == EvNone || ev.Type >= EvCount || ev.Type == EvBatch || ev.Type == EvFrequency ||
+		ev.Type == EvStack || ev.Type == EvTimerGoroutine || ev.Type == EvString ||
+		EventDescriptions[ev.Type].minVersion > w.ver {
+		return fmt.Errorf("cannot write event of type %v in trace format version %v", ev.Type, w.ver)
+	}
+	if !w.started {
+		w.start(ev.Ts)
+	}
+	if ev.Ts < w.lastTs {
+		return fmt.Errorf("event %v is out of order: previous event at time %v", ev, w.lastTs)
+	}
+	w.lastTs = ev.Ts
+
+	p := ev.P
+	if !explicitG(ev.Type) && w.lastG[p] != ev.G {
+		// The parser moves the events of timer and netpoll unblocks
+		// from the P running their goroutine to a fake P.
+		rp, ok := w.running[ev.G]
+		if p < FakeP || !ok {
+			return fmt.Errorf("cannot write event %v: g %v is not running on p %v", ev, ev.G, p)
+		}
+		if p == TimerP {
+			w.timerG = ev.G
+		}
+		p = rp
+	}
+
+	b := w.batches[p]
+	if b == nil {
+		b = &writerBatch{ts: ev.Ts - w.base}
+		b.lastTs = b.ts
+		w.batches[p] = b
+	}
+	desc := EventDescriptions[ev.Type]
+	args := make([]uint64, 0, 1+len(desc.Args)+1)
+	args = append(args, 0) // timestamp, set by the batch
+	args = append(args, ev.Args[:len(desc.Args)]...)
+	switch ev.Type {
+	case EvGoCreate:
+		w.seq[ev.Args[0]] = 1
+	case EvGoWaiting, EvGoInSyscall:
+		w.seq[ev.Args[0]] = 2
+	case EvGoStart, EvGoStartLabel, EvGoUnblock, EvGoSysExit:
+		args[2] = w.seq[ev.Args[0]]
+		w.seq[ev.Args[0]]++
+	case EvGCStart:
+		args[1] = w.gcSeq
+		w.gcSeq++
+	}
+	var sarg string
+	switch ev.Type {
+	case EvGoSysExit:
+		args[3] = 0 // the timestamp of the event is the exit time
+	case EvGoStartLabel, EvUserTaskCreate, EvUserRegion:
+		args[3] = w.stringID(b, ev.SArgs[0])
+	case EvUserLog:
+		args[2] = w.stringID(b, ev.SArgs[0])
+		sarg = ev.SArgs[1]
+	case EvGCSTWStart:
+		// The format has kind 0 for mark termination and 1 for sweep
+		// termination. Traces of Go 1.22 and later name the kind.
+		if kind, ok := ev.STWKind(); ok {
+			args[1] = 0
+			if strings.HasSuffix(kind, "sweep termination") {
+				args[1] = 1
+			}
+		}
+	}
+	if desc.Stack {
+		args = append(args, ev.StkID)
+	}
+	// GoStart events have the stack of the GoCreate of their goroutine.
+	if ev.StkID != 0 && len(ev.Stk) != 0 {
+		if _, ok := w.stacks[ev.StkID]; !ok {
+			w.stacks[ev.StkID] = ev.Stk
+			w.stackIDs = append(w.stackIDs, ev.StkID)
+		}
+	}
+	if n := argNum(rawEvent{typ: ev.Type}, w.ver); n < len(args) {
+		// Drop the arguments added in later versions of the format,
+		// which precede the stack.
+		stk := args[len(args)-1]
+		args = args[:n]
+		if desc.Stack {
+			args[n-1] = stk
+		}
+	}
+
+	switch ev.Type {
+	case EvGoStart, EvGoStartLabel:
+		w.lastG[p] = ev.Args[0]
+		w.running[ev.Args[0]] = p
+	case EvGoEnd, EvGoStop, EvGoSched, EvGoPreempt,
+		EvGoSleep, EvGoBlock, EvGoBlockSend, EvGoBlockRecv,
+		EvGoBlockSelect, EvGoBlockSync, EvGoBlockCond, EvGoBlockNet,
+		EvGoSysBlock, EvGoBlockGC:
+		delete(w.running, w.lastG[p])
+		w.lastG[p] = 0
+	}
+
+	ts := ev.Ts - w.base
+	args[0] = uint64(ts - b.lastTs)
+	b.lastTs = ts
+	b.buf = appendEvent(b.buf, ev.Type, args...)
+	if ev.Type == EvUserLog {
+		b.buf = appendString(b.buf, sarg)
+	}
+	if len(b.buf) >= maxWriterBatch {
+		w.flushBatch(p)
+	}
+	return w.err
+}
+
+// Close writes the pending batches and the stack traces, and completes
+// the trace. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.started {
+		w.start(0)
+	}
+	ps := make([]int, 0, len(w.batches))
+	for p := range w.batches {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	for _, p := range ps {
+		w.flushBatch(p)
+	}
+	// Like the runtime, write the stack traces and the frequency
+	// in a batch of their own.
+	b := &writerBatch{ts: w.lastTs - w.base}
+	for _, id := range w.stackIDs {
+		stk := w.stacks[id]
+		args := []uint64{id, uint64(len(stk))}
+		for _, f := range stk {
+			args = append(args, f.PC, w.stringID(b, f.Fn), w.stringID(b, f.File), uint64(f.Line))
+		}
+		b.buf = appendEvent(b.buf, EvStack, args...)
+	}
+	if w.timerG != 0 {
+		b.buf = appendEvent(b.buf, EvTimerGoroutine, w.timerG)
+	}
+	// Timestamps are in nanoseconds.
+	b.buf = appendEvent(b.buf, EvFrequency, 1e9)
+	w.batches[0] = b
+	w.flushBatch(0)
+	return w.err
+}
+
+// start writes the trace header. Timestamps are written relative to base.
+func (w *Writer) start(base int64) {
+	w.started = true
+	w.base, w.lastTs = base, base
+	header := fmt.Sprintf("go 1.%d trace\x00\x00\x00\x00", w.ver%1000)
+	w.write([]byte(header[:16]))
+}
+
+func (w *Writer) flushBatch(p int) {
+	b := w.batches[p]
+	if b == nil || len(b.buf) == 0 {
+		return
+	}
+	w.write(appendEvent(nil, EvBatch, uint64(p), uint64(b.ts)))
+	w.write(b.buf)
+	// The next batch of the P starts at its last event.
+	b.buf, b.ts = b.buf[:0], b.lastTs
+}
+
+// stringID returns the id of s in the string dictionary. The first time,
+// it adds s to the dictionary in batch b, where go tool trace expects it.
+func (w *Writer) stringID(b *writerBatch, s string) uint64 {
+	if s == "" {
+		return 0
+	}
+	if id, ok := w.strings[s]; ok {
+		return id
+	}
+	id := uint64(len(w.strings) + 1)
+	w.strings[s] = id
+	b.buf = append(b.buf, EvString)
+	b.buf = appendVarint(b.buf, id)
+	b.buf = appendString(b.buf, s)
+	return id
+}
+
+func (w *Writer) write(buf []byte) {
+	if w.err != nil {
+		return
+	}
+	if _, err := w.w.Write(buf); err != nil {
+		w.err = fmt.Errorf("failed to write trace: %v", err)
+	}
+}
+
+// explicitG reports whether the parser takes the G of events of type typ
+// from their arguments rather than from the goroutine running on the P.
+func explicitG(typ byte) bool {
+	switch typ {
+	case EvGoStart, EvGoStartLabel, EvGoSysExit, EvGoWaiting, EvGoInSyscall,
+		EvGCStart, EvGCDone, EvGCSTWStart, EvGCSTWDone:
+		return true
+	}
+	return false
+}
+
+// appendEvent appends the encoding of an event of type typ with the
+// arguments to buf. Up to 3 arguments are inlined, more are preceded
+// by their length in bytes.
+func appendEvent(buf []byte, typ byte, args ...uint64) []byte {
+	nargs := byte(len(args)) - 1
+	if nargs > 3 {
+		nargs = 3
+	}
+	buf = append(buf, typ|nargs<<6)
+	if nargs < 3 {
+		for _, a := range args {
+			buf = appendVarint(buf, a)
+		}
+		return buf
+	}
+	var data []byte
+	for _, a := range args {
+		data = appendVarint(data, a)
+	}
+	buf = appendVarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+// appendString appends the length-prefixed s to buf.
+func appendString(buf []byte, s string) []byte {
+	buf = appendVarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	for ; v >= 0x80; v >>= 7 {
+		buf = append(buf, 0x80|byte(v))
+	}
+	return append(buf, byte(v))
+}
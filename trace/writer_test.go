@@ -0,0 +1,229 @@
+package trace
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteTraceRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("testdata/*_good")
+	if err != nil {
+		t.Fatalf("failed to read ./testdata: %v", err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		ver, want, err := parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		ver0 := ver
+		ver = WriterVersion(ver)
+		var buf bytes.Buffer
+		w, err := NewWriterVersion(&buf, ver)
+		if err != nil {
+			t.Fatalf("failed to create writer: %v", err)
+		}
+		for _, ev := range want {
+			if err = w.WriteEvent(ev); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			t.Errorf("failed to write %v: %v", f, err)
+			continue
+		}
+		_, events, err := parse(&buf, nil)
+		if err != nil {
+			t.Errorf("failed to parse written %v: %v", f, err)
+			continue
+		}
+		if len(events) != len(want) {
+			t.Errorf("%v: got %v events, want %v", f, len(events), len(want))
+			continue
+		}
+		// Events of different Ps at the same time may be reordered.
+		byTsP := func(events []*Event) func(i, j int) bool {
+			return func(i, j int) bool {
+				return events[i].Ts < events[j].Ts || events[i].Ts == events[j].Ts && events[i].P < events[j].P
+			}
+		}
+		sort.SliceStable(want, byTsP(want))
+		sort.SliceStable(events, byTsP(events))
+		base := want[0].Ts
+		for i, ev := range events {
+			w := want[i]
+			if ev.Type != w.Type || ev.Ts != w.Ts-base || ev.P != w.P || ev.G != w.G && !explicitG(ev.Type) ||
+				!sameArgs(ev, w) || len(ev.SArgs) != len(w.SArgs) {
+				t.Errorf("%v: event %v: got %v, want %v", f, i, ev, w)
+				break
+			}
+			lost := ev.Type == EvGoStart && ver0 < 1007 ||
+				!EventDescriptions[ev.Type].Stack && ev.Type != EvGoStart && ev.Type != EvGoStartLabel && ver0 >= 1022
+			if !lost && !sameStack(ev.Stk, w.Stk) {
+				t.Errorf("%v: event %v: got stack %v, want %v", f, i, ev.Stk, w.Stk)
+				break
+			}
+			if ev.Type == EvGCSTWStart && ver0 >= 1022 {
+				continue
+			}
+			for j := range ev.SArgs {
+				if ev.SArgs[j] != w.SArgs[j] {
+					t.Errorf("%v: event %v: got string args %q, want %q", f, i, ev.SArgs, w.SArgs)
+					break
+				}
+			}
+		}
+	}
+}
+
+// sameArgs reports whether the arguments of the events are the same,
+// except for sequence numbers and string ids.
+func sameArgs(ev, w *Event) bool {
+	a, b := ev.Args, w.Args
+	switch ev.Type {
+	case EvGoStart, EvGoUnblock:
+		a[1], b[1] = 0, 0
+	case EvGoStartLabel, EvUserTaskCreate, EvUserRegion:
+		a[1], b[1] = 0, 0
+		a[2], b[2] = 0, 0
+	case EvGoSysExit:
+		a[1], b[1] = 0, 0
+		a[2], b[2] = 0, 0
+	case EvGCStart:
+		a[0], b[0] = 0, 0
+	case EvUserLog:
+		a[1], b[1] = 0, 0
+	case EvGCSTWStart:
+		if w.Version() >= 1022 {
+			// The kind is named instead, see STWKind.
+			a[0], b[0] = 0, 0
+		}
+	}
+	return a == b
+}
+
+func sameStack(a, b []*Frame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if *a[i] != *b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestWriteTraceLowering(t *testing.T) {
+	// Traces of Go 1.22 and later are written in the format of Go 1.11,
+	// and lose only what Writer documents.
+	for _, f := range []string{"testdata/annotations_1_26_good", "testdata/syscall_steal_1_22_good"} {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatalf("failed to read input file: %v", err)
+		}
+		want, err := Parse(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf("failed to parse %v: %v", f, err)
+		}
+		var buf bytes.Buffer
+		if err := WriteTrace(&buf, want); err != nil {
+			t.Fatalf("failed to write %v: %v", f, err)
+		}
+		if ver, err := parseHeader(buf.Bytes()[:16]); err != nil || ver != 1011 {
+			t.Errorf("%v: written in version %v, %v, want 1011", f, ver, err)
+		}
+		got, err := Parse(&buf, nil)
+		if err != nil {
+			t.Fatalf("failed to parse written %v: %v", f, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%v: got %v events, want %v", f, len(got), len(want))
+		}
+		byTsP := func(events []*Event) func(i, j int) bool {
+			return func(i, j int) bool {
+				return events[i].Ts < events[j].Ts || events[i].Ts == events[j].Ts && events[i].P < events[j].P
+			}
+		}
+		sort.SliceStable(want, byTsP(want))
+		sort.SliceStable(got, byTsP(got))
+		base := want[0].Ts
+		for i, ev := range got {
+			w := want[i]
+			if ev.Type != w.Type || ev.Ts != w.Ts-base || ev.P != w.P || ev.G != w.G && !explicitG(ev.Type) || !sameArgs(ev, w) {
+				t.Fatalf("%v: event %v: got %v, want %v", f, i, ev, w)
+			}
+			if ev.Version() != 1011 {
+				t.Errorf("%v: event %v: got version %v, want 1011", f, i, ev.Version())
+			}
+			// Events without a stack in the format of Go 1.11 lose theirs.
+			if EventDescriptions[ev.Type].Stack || ev.Type == EvGoStart || ev.Type == EvGoStartLabel {
+				if !sameStack(ev.Stk, w.Stk) {
+					t.Errorf("%v: event %v: got stack %v, want %v", f, i, ev.Stk, w.Stk)
+				}
+			} else if len(ev.Stk) != 0 {
+				t.Errorf("%v: event %v: got stack %v, want none", f, i, ev.Stk)
+			}
+			switch ev.Type {
+			case EvGCSTWStart:
+				// Only sweep termination is kept apart from mark termination.
+				kind, _ := ev.STWKind()
+				wkind, _ := w.STWKind()
+				wantKind := "mark termination"
+				if strings.HasSuffix(wkind, "sweep termination") {
+					wantKind = "sweep termination"
+				}
+				if kind != wantKind {
+					t.Errorf("%v: event %v: got stop-the-world kind %q for %q, want %q", f, i, kind, wkind, wantKind)
+				}
+			default:
+				if !reflect.DeepEqual(ev.SArgs, w.SArgs) {
+					t.Errorf("%v: event %v: got string args %q, want %q", f, i, ev.SArgs, w.SArgs)
+				}
+			}
+		}
+	}
+}
+
+func TestWriterEncoding(t *testing.T) {
+	events := []*Event{
+		{Type: EvGoCreate, Ts: 10, P: 0, Args: [3]uint64{1, 0}},
+		{Type: EvGoStart, Ts: 20, P: 0, G: 1, Args: [3]uint64{1, 7}},
+		{Type: EvUserLog, Ts: 30, P: 0, G: 1, Args: [3]uint64{0, 5}, SArgs: []string{"key", "value"}},
+		{Type: EvGoBlock, Ts: 40, P: 0, G: 1, StkID: 3, Stk: []*Frame{{PC: 1, Fn: "main.f", File: "f.go", Line: 2}}},
+	}
+	var buf bytes.Buffer
+	if err := WriteTrace(&buf, events); err != nil {
+		t.Fatalf("failed to write: %v", err)
+	}
+	got, err := Parse(&buf, nil)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("got %v events, want %v", len(got), len(events))
+	}
+	if ev := got[2]; ev.Ts != 20 || ev.G != 1 || ev.SArgs[0] != "key" || ev.SArgs[1] != "value" {
+		t.Errorf("got %v %q, want user log of g 1 at 20", ev, ev.SArgs)
+	}
+	if ev := got[3]; ev.StkID != 3 || len(ev.Stk) != 1 || ev.Stk[0].Fn != "main.f" || ev.Stk[0].Line != 2 {
+		t.Errorf("got %v with stack %v, want the stack of main.f", ev, ev.Stk)
+	}
+
+	// The G of the block cannot be encoded.
+	bad := []*Event{events[0], events[1], {Type: EvGoBlock, Ts: 40, P: 1, G: 1}}
+	if err := WriteTrace(ioutil.Discard, bad); err == nil {
+		t.Errorf("no error writing an event of a goroutine that does not run on the P")
+	}
+}
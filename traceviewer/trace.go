@@ -11,6 +11,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -24,12 +25,29 @@ var (
 	traceEvents []*trace.Event
 	gs          map[uint64]*trace.GDesc
 	ranges      []Range
+	processes   []string
+
+	threadsOnce  sync.Once
+	threads      *trace.ThreadInfo
+	threadRanges []Range
 )
 
+// unknownThread is the track of the events on Ps whose thread is
+// not known in the thread view.
+const unknownThread = trace.FakeP - 1
+
 func Init(events []*trace.Event, goroutines map[uint64]*trace.GDesc) []Range {
+	return InitMerged(events, goroutines, nil)
+}
+
+// InitMerged is like Init for a trace merged by trace.Merge. The Ps of
+// each process are shown in a group of their own, named after the
+// process; names are indexed by the Process field of the events.
+func InitMerged(events []*trace.Event, goroutines map[uint64]*trace.GDesc, names []string) []Range {
 	initOnce.Do(func() {
 		traceEvents = events
 		gs = goroutines
+		processes = names
 
 		log.Printf("Serializing trace...")
 		data := generateTrace(&traceParams{
@@ -49,6 +67,28 @@ func Init(events []*trace.Event, goroutines map[uint64]*trace.GDesc) []Range {
 	return r
 }
 
+// ThreadRanges is like the ranges returned by Init for the trace laid out
+// by OS thread, served by /trace?view=thread. They are computed on the
+// first call.
+func ThreadRanges() []Range {
+	initThreads()
+	r := make([]Range, len(threadRanges))
+	copy(r, threadRanges)
+	return r
+}
+
+func initThreads() {
+	threadsOnce.Do(func() {
+		log.Printf("Reconstructing threads...")
+		threads = trace.Threads(traceEvents)
+		threadRanges = splitTrace(generateTrace(&traceParams{
+			events:  traceEvents,
+			endTime: int64(1<<63 - 1),
+			threads: threads,
+		}))
+	})
+}
+
 // httpTrace serves either whole trace (goid==0) or trace for goid goroutine.
 func httpTrace(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
@@ -168,12 +208,35 @@ func httpJsonTrace(w http.ResponseWriter, r *http.Request) {
 			log.Printf("failed to parse goid parameter '%v': %v", goids, err)
 			return
 		}
+		// The depth and edges arguments select the related goroutines
+		// shown with it, by default those that unblocked it up to depth 2.
+		depth := 2
+		if depthStr := r.FormValue("depth"); depthStr != "" {
+			d, err := strconv.ParseUint(depthStr, 10, 31)
+			if err != nil {
+				log.Printf("failed to parse depth parameter '%v': %v", depthStr, err)
+				return
+			}
+			depth = int(d)
+		}
+		edges := trace.EdgeUnblockedBy
+		if edgesStr := r.FormValue("edges"); edgesStr != "" {
+			edges, err = trace.ParseGEdges(edgesStr)
+			if err != nil {
+				log.Printf("failed to parse edges parameter '%v': %v", edgesStr, err)
+				return
+			}
+		}
 		g := gs[goid]
 		params.gtrace = true
 		params.startTime = g.StartTime
 		params.endTime = g.EndTime
 		params.maing = goid
-		params.gs = trace.RelatedGoroutines(traceEvents, goid)
+		params.gs = trace.RelatedGoroutinesEdges(traceEvents, goid, depth, edges)
+	} else if r.FormValue("view") == "thread" {
+		// Lay out the tracks by OS thread instead of by P.
+		initThreads()
+		params.threads = threads
 	}
 
 	data := generateTrace(params)
@@ -257,6 +320,7 @@ type traceParams struct {
 	endTime   int64
 	maing     uint64
 	gs        map[uint64]bool
+	threads   *trace.ThreadInfo // lay out by thread if not nil
 }
 
 type traceContext struct {
@@ -265,6 +329,12 @@ type traceContext struct {
 	frameTree frameNode
 	frameSeq  int
 	arrowSeq  uint64
+	stats     map[int]*processCounters // by process
+	tids      map[*trace.Thread]uint64
+}
+
+// processCounters are the counters of a process shown in its STATS group.
+type processCounters struct {
 	heapAlloc uint64
 	nextGC    uint64
 	gcount    uint64
@@ -322,17 +392,23 @@ type SortIndexArg struct {
 // If gtrace=true, generate trace for goroutine goid, otherwise whole trace.
 // startTime, endTime determine part of the trace that we are interested in.
 // gset restricts goroutines that are included in the resulting trace.
+// If threads is set, the events of Ps are shown on the tracks of the
+// threads that held the Ps.
 func generateTrace(params *traceParams) ViewerData {
-	ctx := &traceContext{traceParams: params}
+	ctx := &traceContext{traceParams: params, stats: make(map[int]*processCounters)}
 	ctx.frameTree.children = make(map[uint64]frameNode)
 	ctx.data.Frames = make(map[string]ViewerFrame)
 	ctx.data.TimeUnit = "ns"
-	maxProc := 0
+	maxProc := make(map[int]int) // by process
 	gnames := make(map[uint64]string)
+	tasks, regions := ctx.annotationAnchors()
+	var emittedTasks []*trace.Task
+	regionGs := make(map[uint64]bool)
+	spans := ctx.threadSpans()
 	for _, ev := range ctx.events {
 		// Handle trace.EvGoStart separately, because we need the goroutine name
 		// even if ignore the event otherwise.
-		if ev.Type == trace.EvGoStart {
+		if ev.Type == trace.EvGoStart || ev.Type == trace.EvGoStartLabel {
 			if _, ok := gnames[ev.G]; !ok {
 				if len(ev.Stk) > 0 {
 					gnames[ev.G] = fmt.Sprintf("G%v %s", ev.G, ev.Stk[0].Fn)
@@ -342,6 +418,22 @@ func generateTrace(params *traceParams) ViewerData {
 			}
 		}
 
+		// Emit user tasks and regions at the first event that belongs to them,
+		// so that the resulting slices stay ordered by time.
+		for _, t := range tasks[ev] {
+			if ctx.emitTask(t) {
+				emittedTasks = append(emittedTasks, t)
+			}
+		}
+		for _, r := range regions[ev] {
+			if ctx.emitRegion(r) {
+				regionGs[r.G] = true
+			}
+		}
+		for _, s := range spans[ev] {
+			ctx.emitThreadSpan(s)
+		}
+
 		// Ignore events that are from uninteresting goroutines
 		// or outside of the interesting timeframe.
 		if ctx.gs != nil && ev.P < trace.FakeP && !ctx.gs[ev.G] {
@@ -351,112 +443,191 @@ func generateTrace(params *traceParams) ViewerData {
 			continue
 		}
 
-		if ev.P < trace.FakeP && ev.P > maxProc {
-			maxProc = ev.P
+		if ev.P < trace.FakeP && ev.P > maxProc[ev.Process] {
+			maxProc[ev.Process] = ev.P
+		} else if _, ok := maxProc[ev.Process]; !ok {
+			maxProc[ev.Process] = 0
 		}
 
+		c := ctx.counters(ev)
 		switch ev.Type {
 		case trace.EvProcStart:
 			if ctx.gtrace {
 				continue
 			}
-			ctx.prunning++
+			c.prunning++
 			ctx.emitThreadCounters(ev)
 			ctx.emitInstant(ev, "proc start")
 		case trace.EvProcStop:
 			if ctx.gtrace {
 				continue
 			}
-			ctx.prunning--
+			c.prunning--
 			ctx.emitThreadCounters(ev)
 			ctx.emitInstant(ev, "proc stop")
 		case trace.EvGCStart:
 			ctx.emitSlice(ev, "GC")
 		case trace.EvGCDone:
-		case trace.EvGCScanStart:
+		case trace.EvGCSTWStart:
 			if ctx.gtrace {
 				continue
 			}
-			ctx.emitSlice(ev, "MARK")
-		case trace.EvGCScanDone:
+			ctx.emitSlice(ev, fmt.Sprintf("STW (%s)", ev.SArgs[0]))
+		case trace.EvGCSTWDone:
 		case trace.EvGCSweepStart:
 			ctx.emitSlice(ev, "SWEEP")
 		case trace.EvGCSweepDone:
-		case trace.EvGoStart:
-			ctx.grunnable--
-			ctx.grunning++
+		case trace.EvGCMarkAssistStart:
+			if ev.Link == nil {
+				// The assist did not finish before the trace ended.
+				continue
+			}
+			ctx.emitSlice(ev, "MARK ASSIST")
+		case trace.EvGCMarkAssistDone:
+		case trace.EvGoStart, trace.EvGoStartLabel:
+			c.grunnable--
+			c.grunning++
 			ctx.emitGoroutineCounters(ev)
-			ctx.emitSlice(ev, gnames[ev.G])
+			name := gnames[ev.G]
+			if label, ok := ev.Label(); ok {
+				name = label
+			}
+			ctx.emitSlice(ev, name)
 		case trace.EvGoCreate:
-			ctx.gcount++
-			ctx.grunnable++
+			c.gcount++
+			c.grunnable++
 			ctx.emitGoroutineCounters(ev)
 			ctx.emitArrow(ev, "go")
 		case trace.EvGoEnd:
-			ctx.gcount--
-			ctx.grunning--
+			c.gcount--
+			c.grunning--
 			ctx.emitGoroutineCounters(ev)
 		case trace.EvGoUnblock:
-			ctx.grunnable++
+			c.grunnable++
 			ctx.emitGoroutineCounters(ev)
 			ctx.emitArrow(ev, "unblock")
 		case trace.EvGoSysCall:
 			ctx.emitInstant(ev, "syscall")
 		case trace.EvGoSysExit:
-			ctx.grunnable++
+			c.grunnable++
 			ctx.emitGoroutineCounters(ev)
-			ctx.insyscall--
+			c.insyscall--
 			ctx.emitThreadCounters(ev)
 			ctx.emitArrow(ev, "sysexit")
 		case trace.EvGoSysBlock:
-			ctx.grunning--
+			c.grunning--
 			ctx.emitGoroutineCounters(ev)
-			ctx.insyscall++
+			c.insyscall++
 			ctx.emitThreadCounters(ev)
 		case trace.EvGoSched, trace.EvGoPreempt:
-			ctx.grunnable++
-			ctx.grunning--
+			c.grunnable++
+			c.grunning--
 			ctx.emitGoroutineCounters(ev)
 		case trace.EvGoStop,
 			trace.EvGoSleep, trace.EvGoBlock, trace.EvGoBlockSend, trace.EvGoBlockRecv,
-			trace.EvGoBlockSelect, trace.EvGoBlockSync, trace.EvGoBlockCond, trace.EvGoBlockNet:
-			ctx.grunning--
+			trace.EvGoBlockSelect, trace.EvGoBlockSync, trace.EvGoBlockCond, trace.EvGoBlockNet,
+			trace.EvGoBlockGC:
+			c.grunning--
 			ctx.emitGoroutineCounters(ev)
 		case trace.EvGoWaiting:
-			ctx.grunnable--
+			c.grunnable--
 			ctx.emitGoroutineCounters(ev)
 		case trace.EvGoInSyscall:
-			ctx.insyscall++
+			c.insyscall++
 			ctx.emitThreadCounters(ev)
 		case trace.EvHeapAlloc:
-			ctx.heapAlloc = ev.Args[0]
+			c.heapAlloc, _ = ev.HeapBytes()
 			ctx.emitHeapCounters(ev)
 		case trace.EvNextGC:
-			ctx.nextGC = ev.Args[0]
+			c.nextGC, _ = ev.NextGCBytes()
 			ctx.emitHeapCounters(ev)
+		case trace.EvUserLog:
+			ctx.emitInstant(ev, formatUserLog(ev))
 		}
 	}
 
 	ctx.data.footer = len(ctx.data.Events)
-	ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: 0, Arg: &NameArg{"PROCS"}})
-	ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: 0, Arg: &SortIndexArg{1}})
+	var procs []int
+	for k := range maxProc {
+		procs = append(procs, k)
+	}
+	if len(procs) == 0 || ctx.gtrace {
+		procs = []int{0}
+	}
+	sort.Ints(procs)
+	for _, k := range procs {
+		pid := ctx.procsPid(k)
+		name := "PROCS"
+		if k < len(processes) && processes[k] != "" {
+			name = fmt.Sprintf("PROCS (%s)", processes[k])
+		}
+		ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: pid, Arg: &NameArg{name}})
+		sortIndex := 1
+		if pid != 0 {
+			sortIndex = int(pid)
+		}
+		ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: pid, Arg: &SortIndexArg{sortIndex}})
+
+		statsName := "STATS"
+		if k < len(processes) && processes[k] != "" {
+			statsName = fmt.Sprintf("STATS (%s)", processes[k])
+		}
+		statsPid := ctx.statsPid(k)
+		ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: statsPid, Arg: &NameArg{statsName}})
+		sortIndex = 0
+		if statsPid != 1 {
+			sortIndex = int(statsPid)
+		}
+		ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: statsPid, Arg: &SortIndexArg{sortIndex}})
 
-	ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: 1, Arg: &NameArg{"STATS"}})
-	ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: 1, Arg: &SortIndexArg{0}})
+		ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: trace.GCP, Arg: &NameArg{"GC"}})
+		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: trace.GCP, Arg: &SortIndexArg{-6}})
 
-	ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 0, Tid: trace.NetpollP, Arg: &NameArg{"Network"}})
-	ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: trace.NetpollP, Arg: &SortIndexArg{-5}})
+		ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: trace.NetpollP, Arg: &NameArg{"Network"}})
+		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: trace.NetpollP, Arg: &SortIndexArg{-5}})
 
-	ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 0, Tid: trace.TimerP, Arg: &NameArg{"Timers"}})
-	ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: trace.TimerP, Arg: &SortIndexArg{-4}})
+		ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: trace.TimerP, Arg: &NameArg{"Timers"}})
+		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: trace.TimerP, Arg: &SortIndexArg{-4}})
 
-	ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 0, Tid: trace.SyscallP, Arg: &NameArg{"Syscalls"}})
-	ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: trace.SyscallP, Arg: &SortIndexArg{-3}})
+		ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: trace.SyscallP, Arg: &NameArg{"Syscalls"}})
+		ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: trace.SyscallP, Arg: &SortIndexArg{-3}})
 
-	if !ctx.gtrace {
-		for i := 0; i <= maxProc; i++ {
-			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 0, Tid: uint64(i), Arg: &NameArg{fmt.Sprintf("Proc %v", i)}})
-			ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: 0, Tid: uint64(i), Arg: &SortIndexArg{i}})
+		if ctx.threads != nil {
+			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: unknownThread, Arg: &NameArg{"Unknown thread"}})
+			ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: unknownThread, Arg: &SortIndexArg{-2}})
+			for _, t := range ctx.threads.Threads {
+				if t.Process != k {
+					continue
+				}
+				tid := ctx.tids[t]
+				ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: tid, Arg: &NameArg{fmt.Sprintf("Thread %v", t.ID)}})
+				ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: tid, Arg: &SortIndexArg{int(tid)}})
+			}
+		} else if !ctx.gtrace {
+			for i := 0; i <= maxProc[k]; i++ {
+				ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: pid, Tid: uint64(i), Arg: &NameArg{fmt.Sprintf("Proc %v", i)}})
+				ctx.emit(&ViewerEvent{Name: "thread_sort_index", Phase: "M", Pid: pid, Tid: uint64(i), Arg: &SortIndexArg{i}})
+			}
+		}
+	}
+
+	if !ctx.gtrace && len(emittedTasks) > 0 {
+		ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: 2, Arg: &NameArg{"TASKS"}})
+		ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: 2, Arg: &SortIndexArg{2}})
+		for _, t := range emittedTasks {
+			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 2, Tid: t.ID, Arg: &NameArg{fmt.Sprintf("Task %v %s", t.ID, t.Name)}})
+		}
+	}
+
+	if !ctx.gtrace && len(regionGs) > 0 {
+		ctx.emit(&ViewerEvent{Name: "process_name", Phase: "M", Pid: 3, Arg: &NameArg{"REGIONS"}})
+		ctx.emit(&ViewerEvent{Name: "process_sort_index", Phase: "M", Pid: 3, Arg: &SortIndexArg{3}})
+		for g := range regionGs {
+			name, ok := gnames[g]
+			if !ok {
+				name = fmt.Sprintf("G%v", g)
+			}
+			ctx.emit(&ViewerEvent{Name: "thread_name", Phase: "M", Pid: 3, Tid: g, Arg: &NameArg{name}})
 		}
 	}
 
@@ -479,24 +650,113 @@ func (ctx *traceContext) emit(e *ViewerEvent) {
 }
 
 func (ctx *traceContext) time(ev *trace.Event) float64 {
+	return ctx.ts(ev.Ts)
+}
+
+func (ctx *traceContext) ts(t int64) float64 {
 	// Trace viewer wants timestamps in microseconds.
-	return float64(ev.Ts-ctx.startTime) / 1000
+	return float64(t-ctx.startTime) / 1000
+}
+
+// procsPid returns the viewer process that shows the Ps of the k-th
+// process of a merged trace. The first process uses the PROCS group;
+// the others come after the TASKS and REGIONS groups, each after the
+// STATS group of the process.
+func (ctx *traceContext) procsPid(k int) uint64 {
+	if ctx.gtrace || k == 0 {
+		return 0
+	}
+	return uint64(3 + 2*k)
+}
+
+// statsPid returns the viewer process that shows the counters of the
+// k-th process of a merged trace.
+func (ctx *traceContext) statsPid(k int) uint64 {
+	if ctx.gtrace || k == 0 {
+		return 1
+	}
+	return uint64(2 + 2*k)
+}
+
+// counters returns the counters of the process of ev.
+func (ctx *traceContext) counters(ev *trace.Event) *processCounters {
+	c := ctx.stats[ev.Process]
+	if c == nil {
+		c = new(processCounters)
+		ctx.stats[ev.Process] = c
+	}
+	return c
+}
+
+func (ctx *traceContext) pid(ev *trace.Event) uint64 {
+	return ctx.procsPid(ev.Process)
 }
 
 func (ctx *traceContext) proc(ev *trace.Event) uint64 {
 	if ctx.gtrace && ev.P < trace.FakeP {
 		return ev.G
+	} else if ctx.threads != nil && ev.P < trace.FakeP {
+		if t, ok := ctx.threads.ThreadOf(ev); ok {
+			return ctx.tids[t]
+		}
+		return unknownThread
 	} else {
 		return uint64(ev.P)
 	}
 }
 
+// threadSpans numbers the threads of the thread view and returns their
+// spans by the events that start them.
+func (ctx *traceContext) threadSpans() map[*trace.Event][]*trace.ThreadSpan {
+	if ctx.threads == nil {
+		return nil
+	}
+	ctx.tids = make(map[*trace.Thread]uint64)
+	spans := make(map[*trace.Event][]*trace.ThreadSpan)
+	for i, t := range ctx.threads.Threads {
+		ctx.tids[t] = uint64(i)
+		for _, s := range t.Spans {
+			spans[s.Start] = append(spans[s.Start], s)
+		}
+	}
+	return spans
+}
+
+// emitThreadSpan emits a slice for the time a thread held a P or
+// was blocked in a syscall.
+func (ctx *traceContext) emitThreadSpan(s *trace.ThreadSpan) {
+	name := fmt.Sprintf("Proc %v", s.P)
+	var arg interface{}
+	if s.InSyscall() {
+		type Arg struct {
+			G uint64
+		}
+		name = "syscall"
+		arg = &Arg{s.G}
+	}
+	ctx.emit(&ViewerEvent{
+		Name:  name,
+		Phase: "X",
+		Time:  ctx.ts(s.StartTime),
+		Dur:   ctx.ts(s.EndTime) - ctx.ts(s.StartTime),
+		Pid:   ctx.pid(s.Start),
+		Tid:   ctx.tids[s.Thread],
+		Arg:   arg,
+	})
+}
+
 func (ctx *traceContext) emitSlice(ev *trace.Event, name string) {
+	if ev.Link == nil {
+		// The slice did not end before trace stop. Traces of Go 1.22 and
+		// later do not stop running goroutines, GC or sweeping at the end.
+		return
+	}
 	ctx.emit(&ViewerEvent{
 		Name:     name,
 		Phase:    "X",
 		Time:     ctx.time(ev),
 		Dur:      ctx.time(ev.Link) - ctx.time(ev),
+		Pid:      ctx.pid(ev),
 		Tid:      ctx.proc(ev),
 		Stack:    ctx.stack(ev.Stk),
 		EndStack: ctx.stack(ev.Link.Stk),
@@ -511,11 +771,12 @@ func (ctx *traceContext) emitHeapCounters(ev *trace.Event) {
 	if ctx.gtrace {
 		return
 	}
+	c := ctx.counters(ev)
 	diff := uint64(0)
-	if ctx.nextGC > ctx.heapAlloc {
-		diff = ctx.nextGC - ctx.heapAlloc
+	if c.nextGC > c.heapAlloc {
+		diff = c.nextGC - c.heapAlloc
 	}
-	ctx.emit(&ViewerEvent{Name: "Heap", Phase: "C", Time: ctx.time(ev), Pid: 1, Arg: &Arg{ctx.heapAlloc, diff}})
+	ctx.emit(&ViewerEvent{Name: "Heap", Phase: "C", Time: ctx.time(ev), Pid: ctx.statsPid(ev.Process), Arg: &Arg{c.heapAlloc, diff}})
 }
 
 func (ctx *traceContext) emitGoroutineCounters(ev *trace.Event) {
@@ -526,7 +787,8 @@ func (ctx *traceContext) emitGoroutineCounters(ev *trace.Event) {
 	if ctx.gtrace {
 		return
 	}
-	ctx.emit(&ViewerEvent{Name: "Goroutines", Phase: "C", Time: ctx.time(ev), Pid: 1, Arg: &Arg{ctx.grunning, ctx.grunnable}})
+	c := ctx.counters(ev)
+	ctx.emit(&ViewerEvent{Name: "Goroutines", Phase: "C", Time: ctx.time(ev), Pid: ctx.statsPid(ev.Process), Arg: &Arg{c.grunning, c.grunnable}})
 }
 
 func (ctx *traceContext) emitThreadCounters(ev *trace.Event) {
@@ -537,7 +799,8 @@ func (ctx *traceContext) emitThreadCounters(ev *trace.Event) {
 	if ctx.gtrace {
 		return
 	}
-	ctx.emit(&ViewerEvent{Name: "Threads", Phase: "C", Time: ctx.time(ev), Pid: 1, Arg: &Arg{ctx.prunning, ctx.insyscall}})
+	c := ctx.counters(ev)
+	ctx.emit(&ViewerEvent{Name: "Threads", Phase: "C", Time: ctx.time(ev), Pid: ctx.statsPid(ev.Process), Arg: &Arg{c.prunning, c.insyscall}})
 }
 
 func (ctx *traceContext) emitInstant(ev *trace.Event, name string) {
@@ -546,9 +809,17 @@ func (ctx *traceContext) emitInstant(ev *trace.Event, name string) {
 		type Arg struct {
 			ThreadID uint64
 		}
-		arg = &Arg{ev.Args[0]}
+		id, _ := ev.ThreadID()
+		arg = &Arg{id}
+	}
+	if category, message, ok := ev.Log(); ok {
+		type Arg struct {
+			Category string
+			Message  string
+		}
+		arg = &Arg{category, message}
 	}
-	ctx.emit(&ViewerEvent{Name: name, Phase: "I", Scope: "t", Time: ctx.time(ev), Tid: ctx.proc(ev), Stack: ctx.stack(ev.Stk), Arg: arg})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "I", Scope: "t", Time: ctx.time(ev), Pid: ctx.pid(ev), Tid: ctx.proc(ev), Stack: ctx.stack(ev.Stk), Arg: arg})
 }
 
 func (ctx *traceContext) emitArrow(ev *trace.Event, name string) {
@@ -564,12 +835,12 @@ func (ctx *traceContext) emitArrow(ev *trace.Event, name string) {
 	if ev.P == trace.NetpollP || ev.P == trace.TimerP || ev.P == trace.SyscallP {
 		// Trace-viewer discards arrows if they don't start/end inside of a slice or instant.
 		// So emit a fake instant at the start of the arrow.
-		ctx.emitInstant(&trace.Event{P: ev.P, Ts: ev.Ts}, "unblock")
+		ctx.emitInstant(&trace.Event{P: ev.P, Ts: ev.Ts, Process: ev.Process}, "unblock")
 	}
 
 	ctx.arrowSeq++
-	ctx.emit(&ViewerEvent{Name: name, Phase: "s", Tid: ctx.proc(ev), ID: ctx.arrowSeq, Time: ctx.time(ev), Stack: ctx.stack(ev.Stk)})
-	ctx.emit(&ViewerEvent{Name: name, Phase: "t", Tid: ctx.proc(ev.Link), ID: ctx.arrowSeq, Time: ctx.time(ev.Link)})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "s", Pid: ctx.pid(ev), Tid: ctx.proc(ev), ID: ctx.arrowSeq, Time: ctx.time(ev), Stack: ctx.stack(ev.Stk)})
+	ctx.emit(&ViewerEvent{Name: name, Phase: "t", Pid: ctx.pid(ev.Link), Tid: ctx.proc(ev.Link), ID: ctx.arrowSeq, Time: ctx.time(ev.Link)})
 }
 
 func (ctx *traceContext) stack(stk []*trace.Frame) int {
@@ -595,3 +866,129 @@ func (ctx *traceContext) buildBranch(parent frameNode, stk []*trace.Frame) int {
 	}
 	return ctx.buildBranch(node, stk)
 }
+
+// annotationAnchors returns user tasks and regions keyed by the first event
+// in the trace that belongs to them.
+func (ctx *traceContext) annotationAnchors() (tasks map[*trace.Event][]*trace.Task, regions map[*trace.Event][]*trace.Region) {
+	annots := trace.UserAnnotations(ctx.events)
+	tasks = make(map[*trace.Event][]*trace.Task)
+	regions = make(map[*trace.Event][]*trace.Region)
+	seen := make(map[uint64]bool)
+	for _, ev := range ctx.events {
+		if id, ok := ev.TaskID(); ok && id != 0 && !seen[id] {
+			seen[id] = true
+			tasks[ev] = append(tasks[ev], annots.Tasks[id])
+		}
+	}
+	for _, r := range annots.Regions {
+		ev := r.Start
+		if ev == nil {
+			ev = r.End
+		}
+		regions[ev] = append(regions[ev], r)
+	}
+	return tasks, regions
+}
+
+// emitTask emits a slice spanning the user task t on its own row in the TASKS section.
+// Tasks without goroutines of ctx.gs are skipped.
+// It reports whether the task was emitted.
+func (ctx *traceContext) emitTask(t *trace.Task) bool {
+	type Arg struct {
+		ID     uint64
+		Parent uint64 `json:",omitempty"`
+	}
+	if ctx.gtrace {
+		return false
+	}
+	if ctx.gs != nil {
+		found := false
+		for g := range t.Goroutines {
+			if ctx.gs[g] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	start, end := t.StartTime, t.EndTime
+	if end < ctx.startTime || start > ctx.endTime {
+		return false
+	}
+	if start < ctx.startTime {
+		start = ctx.startTime
+	}
+	if end > ctx.endTime {
+		end = ctx.endTime
+	}
+	arg := &Arg{ID: t.ID}
+	if t.Parent != nil {
+		arg.Parent = t.Parent.ID
+	}
+	var stk []*trace.Frame
+	if t.Create != nil {
+		stk = t.Create.Stk
+	}
+	ctx.emit(&ViewerEvent{
+		Name:  t.Name,
+		Phase: "X",
+		Time:  ctx.ts(start),
+		Dur:   ctx.ts(end) - ctx.ts(start),
+		Pid:   2,
+		Tid:   t.ID,
+		Stack: ctx.stack(stk),
+		Arg:   arg,
+	})
+	return true
+}
+
+// emitRegion emits a slice spanning the user region r on the row of its goroutine.
+// Nested regions of the goroutine become nested slices.
+// It reports whether the region was emitted.
+func (ctx *traceContext) emitRegion(r *trace.Region) bool {
+	if ctx.gs != nil && !ctx.gs[r.G] {
+		return false
+	}
+	start, end := r.StartTime, r.EndTime
+	if end < ctx.startTime || start > ctx.endTime {
+		return false
+	}
+	if start < ctx.startTime {
+		start = ctx.startTime
+	}
+	if end > ctx.endTime {
+		end = ctx.endTime
+	}
+	pid := uint64(3)
+	if ctx.gtrace {
+		pid = 0
+	}
+	var stk []*trace.Frame
+	if r.Start != nil {
+		stk = r.Start.Stk
+	}
+	ctx.emit(&ViewerEvent{
+		Name:  r.Name,
+		Phase: "X",
+		Time:  ctx.ts(start),
+		Dur:   ctx.ts(end) - ctx.ts(start),
+		Pid:   pid,
+		Tid:   r.G,
+		Stack: ctx.stack(stk),
+	})
+	return true
+}
+
+// formatUserLog returns the name of the instant event for a user log message.
+func formatUserLog(ev *trace.Event) string {
+	k, v, _ := ev.Log()
+	if k == "" {
+		return v
+	}
+	if v == "" {
+		return k
+	}
+	return fmt.Sprintf("%v=%v", k, v)
+}
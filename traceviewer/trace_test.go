@@ -0,0 +1,68 @@
+package traceviewer
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hyangah/tracer/trace"
+)
+
+func TestGenerateTraceMerged(t *testing.T) {
+	// Each process of a merged trace has its own counters, which are
+	// those of the process alone.
+	events, err := trace.ParseFile("../trace/testdata/stress_1_11_good", nil)
+	if err != nil {
+		t.Fatalf("failed to parse trace: %v", err)
+	}
+	merged, err := trace.Merge([]trace.Source{{Name: "a", Events: events}, {Name: "b", Events: events}})
+	if err != nil {
+		t.Fatalf("failed to merge traces: %v", err)
+	}
+	defer func(names []string) { processes = names }(processes)
+	processes = nil
+	want := heapCounters(generateTrace(&traceParams{events: events, endTime: 1<<63 - 1}))
+	processes = []string{"a", "b"}
+	data := generateTrace(&traceParams{events: merged, endTime: 1<<63 - 1})
+
+	names := make(map[uint64]string)
+	for _, ev := range data.Events {
+		if ev.Name == "process_name" {
+			names[ev.Pid] = ev.Arg.(*NameArg).Name
+		}
+	}
+	got := heapCounters(data)
+	for _, name := range []string{"STATS (a)", "STATS (b)"} {
+		var pid uint64
+		for p, n := range names {
+			if n == name {
+				pid = p
+			}
+		}
+		if pid == 0 {
+			t.Errorf("no %v group in %v", name, names)
+			continue
+		}
+		if len(want[1]) == 0 || !reflect.DeepEqual(got[pid], want[1]) {
+			t.Errorf("%v: got %v heap counters, want the %v of the process alone", name, len(got[pid]), len(want[1]))
+		}
+	}
+	for pid := range got {
+		if names[pid] != "STATS (a)" && names[pid] != "STATS (b)" {
+			t.Errorf("heap counters in group %v %q", pid, names[pid])
+		}
+	}
+}
+
+// heapCounters returns the arguments of the heap counters by viewer process.
+func heapCounters(data ViewerData) map[uint64][]string {
+	m := make(map[uint64][]string)
+	for _, ev := range data.Events {
+		if ev.Name != "Heap" {
+			continue
+		}
+		arg, _ := json.Marshal(ev.Arg)
+		m[ev.Pid] = append(m[ev.Pid], string(arg))
+	}
+	return m
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hyangah/tracer/trace"
+)
+
+const verifyUsageMessage = "" +
+	`Usage of 'tracer verify':
+Report every consistency violation of a trace, rather than only the first:
+	tracer verify [flags] trace.out
+
+The exit status is 1 if the trace has violations.
+
+Flags:
+	-json: write the report as JSON
+`
+
+// verifyMain runs the verify command with the arguments following "verify".
+func verifyMain(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, verifyUsageMessage)
+		os.Exit(2)
+	}
+	jsonFlag := fs.Bool("json", false, "write the report as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+	}
+	traceFile = fs.Arg(0)
+
+	f, err := trace.Open(traceFile)
+	if err != nil {
+		dief("%v\n", err)
+	}
+	defer f.Close()
+	r, err := trace.Verify(bufio.NewReader(f))
+	if err != nil {
+		dief("failed to parse trace: %v\n", err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if *jsonFlag {
+		err = r.WriteJSON(w)
+	} else {
+		err = r.WriteText(w)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		dief("failed to write report: %v\n", err)
+	}
+	if !r.Valid() {
+		os.Exit(1)
+	}
+}